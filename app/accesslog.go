@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat selects the line format of an AccessLog.
+type AccessLogFormat int
+
+const (
+	// FormatCommon is the Common Log Format:
+	//	host ident authuser [date] "request" status bytes
+	FormatCommon AccessLogFormat = iota
+	// FormatCombined adds the referer and user agent to the Common Log
+	// Format.
+	FormatCombined
+)
+
+// clfTimeLayout is the timestamp layout of the Common Log Format.
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLog writes one line per request in the Common or Combined Log
+// Format, followed by the time taken to serve the request in seconds, the
+// one field the standard formats lack, and the request's ID when the
+// RequestID middleware gave it one. Leave Server.AccessLog nil to turn
+// the log off, e.g. for benchmarks.
+type AccessLog struct {
+	Format AccessLogFormat
+	Output io.Writer
+
+	mu sync.Mutex
+}
+
+func NewAccessLog(output io.Writer, format AccessLogFormat) *AccessLog {
+	return &AccessLog{Format: format, Output: output}
+}
+
+func (l *AccessLog) record(request *HTTPRequest, w *ResponseWriter, latency time.Duration) {
+	var b strings.Builder
+	b.WriteString(clfField(request.ClientIP()))
+	b.WriteString(" - ")
+	user := ""
+	if request.Principal != nil {
+		user = request.Principal.Subject
+	}
+	b.WriteString(clfField(user))
+	fmt.Fprintf(&b, " [%s] %s %d ", time.Now().Format(clfTimeLayout),
+		clfQuote(string(request.Method)+" "+request.RequestURI+" "+request.Proto), w.status.Code())
+	if w.bodySize > 0 {
+		b.WriteString(strconv.FormatInt(w.bodySize, 10))
+	} else {
+		b.WriteString("-")
+	}
+	if l.Format == FormatCombined {
+		fmt.Fprintf(&b, " %s %s", clfQuote(request.Headers.Get("Referer")), clfQuote(request.Headers.Get("User-Agent")))
+	}
+	fmt.Fprintf(&b, " %.6f", latency.Seconds())
+	if id := RequestIDFromContext(request.Context()); id != "" {
+		b.WriteString(" " + id)
+	}
+	b.WriteString("\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.Output, b.String())
+}
+
+// clfField returns value, or "-" when it is empty.
+func clfField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// clfQuote quotes value for the log, escaping quotes, backslashes and
+// control characters so a client can't forge log lines.
+func clfQuote(value string) string {
+	if value == "" {
+		return `"-"`
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
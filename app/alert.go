@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrorAlert watches the 5xx rate and panic count over a sliding window and
+// raises an Alert when either crosses its threshold. It is a lightweight
+// stand-in for a full APM on small deployments.
+type ErrorAlert struct {
+	// Window is the length of the sliding window, at one-second
+	// resolution.
+	Window time.Duration
+
+	// ErrorRate is the fraction of 5xx responses, between 0 and 1, that
+	// triggers an alert once at least MinRequests have been seen in the
+	// window. Zero disables the rate check.
+	ErrorRate   float64
+	MinRequests int
+
+	// Panics is the number of handler panics in the window that triggers an
+	// alert. Zero disables the panic check.
+	Panics int
+
+	// Cooldown is the minimum time between two alerts.
+	Cooldown time.Duration
+
+	// OnAlert and WebhookURL receive each alert: OnAlert is called directly
+	// and WebhookURL gets it POSTed as JSON. Either may be left empty.
+	OnAlert    func(Alert)
+	WebhookURL string
+
+	// Samples is how many recent errors are kept to include in alerts.
+	Samples int
+
+	mu        sync.Mutex
+	buckets   []alertBucket
+	recent    []ErrorSample
+	lastAlert time.Time
+}
+
+type alertBucket struct {
+	second    int64
+	requests  int
+	errors5xx int
+	panics    int
+}
+
+// Alert is the payload delivered when a threshold is crossed.
+type Alert struct {
+	Time      time.Time     `json:"time"`
+	Reason    string        `json:"reason"`
+	Window    string        `json:"window"`
+	Requests  int           `json:"requests"`
+	Errors5xx int           `json:"errors_5xx"`
+	Panics    int           `json:"panics"`
+	ErrorRate float64       `json:"error_rate"`
+	Samples   []ErrorSample `json:"samples"`
+}
+
+// ErrorSample describes one failed request.
+type ErrorSample struct {
+	Time   time.Time  `json:"time"`
+	Method HTTPMethod `json:"method"`
+	Path   string     `json:"path"`
+	Status int        `json:"status,omitempty"`
+	Panic  string     `json:"panic,omitempty"`
+}
+
+// NewErrorAlert returns an alerter with the given thresholds and defaults
+// suitable for a small deployment.
+func NewErrorAlert(errorRate float64, panics int) *ErrorAlert {
+	return &ErrorAlert{
+		Window:      time.Minute,
+		ErrorRate:   errorRate,
+		MinRequests: 20,
+		Panics:      panics,
+		Cooldown:    5 * time.Minute,
+		Samples:     10,
+	}
+}
+
+// record accounts for one finished request. panicValue is non-nil when
+// the handler panicked.
+func (a *ErrorAlert) record(request *HTTPRequest, status StatusCode, panicValue any) {
+	now := time.Now()
+	code := status.Code()
+
+	a.mu.Lock()
+	bucket := a.bucket(now)
+	bucket.requests++
+	if code >= 500 || panicValue != nil {
+		sample := ErrorSample{Time: now, Status: code}
+		if request != nil {
+			sample.Method, sample.Path = request.Method, request.Path
+		}
+		if panicValue != nil {
+			bucket.panics++
+			sample.Panic = fmt.Sprint(panicValue)
+		} else {
+			bucket.errors5xx++
+		}
+		a.recent = append(a.recent, sample)
+		if len(a.recent) > a.Samples {
+			a.recent = a.recent[len(a.recent)-a.Samples:]
+		}
+	}
+
+	alert, fire := a.check(now)
+	a.mu.Unlock()
+
+	if fire {
+		a.deliver(alert)
+	}
+}
+
+// bucket returns the counters for the current second, dropping buckets
+// that have slid out of the window. The caller must hold a.mu.
+func (a *ErrorAlert) bucket(now time.Time) *alertBucket {
+	second := now.Unix()
+	oldest := second - int64(a.Window/time.Second)
+	keep := a.buckets[:0]
+	for _, b := range a.buckets {
+		if b.second > oldest {
+			keep = append(keep, b)
+		}
+	}
+	a.buckets = keep
+
+	if n := len(a.buckets); n == 0 || a.buckets[n-1].second != second {
+		a.buckets = append(a.buckets, alertBucket{second: second})
+	}
+	return &a.buckets[len(a.buckets)-1]
+}
+
+// check evaluates the thresholds over the window. The caller must hold
+// a.mu.
+func (a *ErrorAlert) check(now time.Time) (Alert, bool) {
+	if !a.lastAlert.IsZero() && now.Sub(a.lastAlert) < a.Cooldown {
+		return Alert{}, false
+	}
+
+	alert := Alert{Time: now, Window: a.Window.String()}
+	for _, b := range a.buckets {
+		alert.Requests += b.requests
+		alert.Errors5xx += b.errors5xx
+		alert.Panics += b.panics
+	}
+	if alert.Requests > 0 {
+		alert.ErrorRate = float64(alert.Errors5xx) / float64(alert.Requests)
+	}
+
+	switch {
+	case a.Panics > 0 && alert.Panics >= a.Panics:
+		alert.Reason = fmt.Sprintf("%d panics in %s", alert.Panics, a.Window)
+	case a.ErrorRate > 0 && alert.Requests >= a.MinRequests && alert.ErrorRate >= a.ErrorRate:
+		alert.Reason = fmt.Sprintf("5xx rate %.1f%% in %s", alert.ErrorRate*100, a.Window)
+	default:
+		return Alert{}, false
+	}
+
+	cutoff := now.Add(-a.Window)
+	for _, sample := range a.recent {
+		if sample.Time.After(cutoff) {
+			alert.Samples = append(alert.Samples, sample)
+		}
+	}
+	a.lastAlert = now
+	return alert, true
+}
+
+func (a *ErrorAlert) deliver(alert Alert) {
+	defaultLogger("alerts").Warn("Error alert", "reason", alert.Reason)
+	if a.OnAlert != nil {
+		a.OnAlert(alert)
+	}
+	if a.WebhookURL == "" {
+		return
+	}
+
+	go func() {
+		body, err := json.Marshal(alert)
+		if err != nil {
+			defaultLogger("alerts").Error("Failed to encode alert", "err", err)
+			return
+		}
+		client := &Client{Timeout: 10 * time.Second}
+		headers := Header{"Content-Type": {string(ContentTypeApplicationJSON)}}
+		response, err := client.Do(MethodPost, a.WebhookURL, headers, body)
+		if err != nil {
+			defaultLogger("alerts").Error("Failed to deliver alert webhook", "err", err)
+			return
+		}
+		if response.StatusCode >= 300 {
+			defaultLogger("alerts").Error("Alert webhook failed", "status", response.StatusCode)
+		}
+	}()
+}
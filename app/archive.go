@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// handleFilesArchive serves the whole files directory as a ZIP download.
+func (s *Server) handleFilesArchive(w *ResponseWriter, request *HTTPRequest, _ map[string]string) error {
+	s.sendZip(request.Context(), w, s.FilesDir, "files.zip")
+	return nil
+}
+
+// sendZip streams a ZIP archive of dir to the client. Entries are compressed
+// and written as the directory is walked, so nothing is buffered beyond a
+// single copy buffer and no temporary archive touches the disk. The walk
+// stops as soon as ctx is canceled.
+func (s *Server) sendZip(ctx context.Context, w *ResponseWriter, dir, name string) {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		s.sendResponse(w, StatusNotFound, ContentTypePlainText, "")
+		return
+	}
+
+	w.Header().Set("Content-Disposition", contentDisposition(DispositionAttachment, name))
+	s.SendStream(w, StatusOK, ContentTypeZip, func(out io.Writer) error {
+		archive := zip.NewWriter(out)
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if entry.IsDir() && entry.Name() == versionsDirName {
+				return filepath.SkipDir
+			}
+			if !entry.Type().IsRegular() || isUploadTemp(entry.Name()) {
+				return nil
+			}
+			return addZipEntry(ctx, archive, dir, path, entry)
+		})
+		if err != nil {
+			return err
+		}
+		return archive.Close()
+	})
+}
+
+func addZipEntry(ctx context.Context, archive *zip.Writer, root, path string, entry fs.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(rel)
+	header.Method = zip.Deflate
+
+	dst, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = copyContext(ctx, dst, src)
+	return err
+}
+
+// isArchiveRequest reports whether a files request asked for a ZIP of a
+// directory rather than the file itself.
+func isArchiveRequest(request *HTTPRequest) bool {
+	return strings.EqualFold(request.Query.Get("format"), "zip")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestAuthPolicyAuthorize(t *testing.T) {
+	policy := &AuthPolicy{Rules: []PolicyRule{
+		{Pattern: "/", Public: true},
+		{Pattern: "/healthz", Methods: []HTTPMethod{MethodGet}, Public: true},
+		{Pattern: "/files/*filepath", Methods: []HTTPMethod{MethodGet}, Roles: []string{"reader", "admin"}},
+		{Pattern: "/files/*filepath", Roles: []string{"admin"}, Scopes: []string{"files:write"}},
+		{Pattern: "/users/:id", Scopes: []string{"users:read", "users:list"}},
+	}}
+	reader := &Principal{Subject: "r", Roles: []string{"reader"}}
+	admin := &Principal{Subject: "a", Roles: []string{"admin"}, Scopes: []string{"files:write"}}
+	scoped := &Principal{Subject: "s", Scopes: []string{"users:read", "users:list"}}
+
+	tests := []struct {
+		method    HTTPMethod
+		path      string
+		principal *Principal
+		allowed   bool
+		reason    string
+	}{
+		{MethodGet, "/", nil, true, ""},
+		{MethodGet, "/healthz", nil, true, ""},
+		{MethodPost, "/healthz", nil, false, "no matching rule"},
+		{MethodGet, "/files/a/b.txt", nil, false, "unauthenticated"},
+		{MethodGet, "/files/a/b.txt", reader, true, ""},
+		{MethodGet, "/files/a/b.txt", admin, true, ""},
+		{MethodPost, "/files/a/b.txt", reader, false, "missing role: one of admin"},
+		{MethodPost, "/files/a/b.txt", &Principal{Roles: []string{"admin"}}, false, "missing scope: files:write"},
+		{MethodPost, "/files/a/b.txt", admin, true, ""},
+		{MethodGet, "/users/7", &Principal{Scopes: []string{"users:read"}}, false, "missing scope: users:list"},
+		{MethodGet, "/users/7", scoped, true, ""},
+		{MethodGet, "/users/7/posts", scoped, false, "no matching rule"},
+		{MethodGet, "/nowhere", admin, false, "no matching rule"},
+	}
+	s := NewServer()
+	for _, tt := range tests {
+		request := &HTTPRequest{Method: tt.method, Path: tt.path, RawPath: tt.path, Principal: tt.principal}
+		allowed, reason := policy.authorize(s, request)
+		if allowed != tt.allowed || reason != tt.reason {
+			t.Errorf("%s %s as %v: authorize = %v, %q; want %v, %q", tt.method, tt.path, tt.principal, allowed, reason, tt.allowed, tt.reason)
+		}
+	}
+}
+
+func TestApplyAuthPolicy(t *testing.T) {
+	var denials []AuthDenial
+	s := NewServer()
+	s.Authenticate = func(request *HTTPRequest) *Principal {
+		if request.Headers.Get("Authorization") == "" {
+			return nil
+		}
+		return &Principal{Subject: "alice"}
+	}
+	s.Policy = &AuthPolicy{
+		Rules: []PolicyRule{{Pattern: "/admin", Roles: []string{"admin"}}, {Pattern: "/public", Public: true}},
+		Audit: func(denial AuthDenial) { denials = append(denials, denial) },
+	}
+
+	tests := []struct {
+		path    string
+		auth    string
+		allowed bool
+		status  int
+	}{
+		{path: "/public", allowed: true},
+		{path: "/admin", status: 401},
+		{path: "/admin", auth: "Bearer x", status: 403},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		w := s.newResponseWriter(server)
+		request := &HTTPRequest{Method: MethodGet, Path: tt.path, RawPath: tt.path, Headers: Header{}}
+		if tt.auth != "" {
+			request.Headers.Set("Authorization", tt.auth)
+		}
+		status := make(chan int, 1)
+		go func() {
+			response, err := http.ReadResponse(bufio.NewReader(client), nil)
+			if err != nil {
+				status <- 0
+				return
+			}
+			io.Copy(io.Discard, response.Body)
+			status <- response.StatusCode
+		}()
+		allowed := s.applyAuthPolicy(w, request)
+		server.Close()
+		got := <-status
+		client.Close()
+		if allowed != tt.allowed || (!allowed && got != tt.status) {
+			t.Errorf("%s with %q: allowed = %v, status %d; want %v, %d", tt.path, tt.auth, allowed, got, tt.allowed, tt.status)
+		}
+	}
+
+	if len(denials) != 2 {
+		t.Fatalf("audited %d denials, want 2", len(denials))
+	}
+	if denials[0].Subject != "" || denials[0].Reason != "unauthenticated" {
+		t.Errorf("first denial = %+v, want an unauthenticated one", denials[0])
+	}
+	if denials[1].Subject != "alice" || denials[1].Path != "/admin" || denials[1].Reason != "missing role: one of admin" {
+		t.Errorf("second denial = %+v, want alice refused /admin for a missing role", denials[1])
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// BasicAuth requires requests to carry credentials, checked with validate,
+// in an Authorization header using the Basic scheme. Requests without
+// valid ones are refused with 401 and a WWW-Authenticate challenge for
+// realm, which makes browsers prompt for a user and password. The user
+// becomes the request's Principal unless one was already established.
+// validate should compare credentials in constant time; BasicAuthUsers
+// does.
+// https://www.rfc-editor.org/rfc/rfc7617
+func BasicAuth(realm string, validate func(user, pass string) bool) Middleware {
+	challenge := "Basic realm=" + strconv.Quote(realm) + `, charset="UTF-8"`
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+			user, pass, ok := request.BasicAuth()
+			if !ok || !validate(user, pass) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				return &HTTPError{Code: StatusUnauthorized}
+			}
+			if request.Principal == nil {
+				request.Principal = &Principal{Subject: user}
+			}
+			return next(w, request, params)
+		}
+	}
+}
+
+// BasicAuth returns the user and password of an Authorization header using
+// the Basic scheme, reporting false if there is none or it is malformed.
+func (r *HTTPRequest) BasicAuth() (user, pass string, ok bool) {
+	scheme, credentials, found := strings.Cut(r.Headers.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// BasicAuthUsers returns a BasicAuth validator accepting the given users
+// and passwords. Credentials are compared in constant time, through their
+// hashes so that neither their content nor their length leaks through
+// timing, and every user is checked so that which ones exist doesn't
+// either.
+func BasicAuthUsers(users map[string]string) func(user, pass string) bool {
+	type credentials struct{ user, pass [sha256.Size]byte }
+	accounts := make([]credentials, 0, len(users))
+	for user, pass := range users {
+		accounts = append(accounts, credentials{sha256.Sum256([]byte(user)), sha256.Sum256([]byte(pass))})
+	}
+	return func(user, pass string) bool {
+		userHash, passHash := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(pass))
+		match := 0
+		for _, account := range accounts {
+			match |= subtle.ConstantTimeCompare(userHash[:], account.user[:]) &
+				subtle.ConstantTimeCompare(passHash[:], account.pass[:])
+		}
+		return match == 1
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchResult is what one load-generator worker observed.
+type benchResult struct {
+	latencies []time.Duration
+	errors    int
+	non2xx    int
+	bytes     int64
+}
+
+// benchMemStats are the allocation counters a server started with -metrics
+// publishes on /debug/vars.
+type benchMemStats struct {
+	Mallocs    uint64
+	TotalAlloc uint64
+}
+
+// runBench implements the "bench" subcommand: it drives concurrent GET load
+// against a target and reports throughput and latency percentiles, and for
+// a target that publishes its memory statistics, how much it allocated per
+// request.
+//
+//	app bench -c 16 -d 10s -rate 500 -paths /,/echo/hi http://localhost:4221
+func runBench(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	connections := fs.Int("c", 10, "number of concurrent connections")
+	duration := fs.Duration("d", 10*time.Second, "duration of the test")
+	rate := fs.Int("rate", 0, "total requests per second across all connections (0 = unlimited)")
+	paths := fs.String("paths", "/", "comma-separated request paths, used round-robin")
+	timeout := fs.Duration("timeout", 5*time.Second, "per-request timeout")
+	insecure := fs.Bool("k", false, "skip TLS certificate verification")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || *connections < 1 {
+		fmt.Fprintln(os.Stderr, "usage: bench [flags] <target-url>")
+		fs.PrintDefaults()
+		return 2
+	}
+	target := strings.TrimSuffix(fs.Arg(0), "/")
+	targetPaths := strings.Split(*paths, ",")
+
+	client := &Client{Timeout: *timeout, InsecureSkipVerify: *insecure}
+
+	// With a rate limit, workers draw permission to send from a shared
+	// ticker instead of firing as fast as responses come back.
+	var tokens <-chan time.Time
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(*rate))
+		defer ticker.Stop()
+		tokens = ticker.C
+	}
+
+	before, haveMemStats := fetchMemStats(client, target)
+	deadline := time.Now().Add(*duration)
+	results := make([]benchResult, *connections)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for worker := range *connections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := &results[worker]
+			for i := worker; time.Now().Before(deadline); i++ {
+				if tokens != nil {
+					<-tokens
+				}
+				path := targetPaths[i%len(targetPaths)]
+				sent := time.Now()
+				response, err := client.Do(MethodGet, target+path, nil, nil)
+				if err != nil {
+					result.errors++
+					continue
+				}
+				result.latencies = append(result.latencies, time.Since(sent))
+				result.bytes += int64(len(response.Body))
+				if response.StatusCode < 200 || response.StatusCode > 299 {
+					result.non2xx++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	reportBench(out, results, elapsed)
+	if after, ok := fetchMemStats(client, target); ok && haveMemStats {
+		reportAllocs(out, results, before, after)
+	}
+	return 0
+}
+
+// fetchMemStats reads the target's allocation counters from /debug/vars,
+// reporting false if it doesn't publish them.
+func fetchMemStats(client *Client, target string) (benchMemStats, bool) {
+	var vars struct {
+		MemStats *benchMemStats `json:"memstats"`
+	}
+	response, err := client.Do(MethodGet, target+"/debug/vars", nil, nil)
+	if err != nil || response.StatusCode != 200 || json.Unmarshal(response.Body, &vars) != nil || vars.MemStats == nil {
+		return benchMemStats{}, false
+	}
+	return *vars.MemStats, true
+}
+
+// reportAllocs prints what the target allocated per request served during
+// the run, which is what pooling and buffer reuse in the server bring down.
+func reportAllocs(out io.Writer, results []benchResult, before, after benchMemStats) {
+	requests := 0
+	for _, result := range results {
+		requests += len(result.latencies)
+	}
+	if requests == 0 {
+		return
+	}
+	fmt.Fprintf(out, "allocs:     %.1f allocs/req, %.1f KiB/req on the server\n",
+		float64(after.Mallocs-before.Mallocs)/float64(requests),
+		float64(after.TotalAlloc-before.TotalAlloc)/1024/float64(requests))
+}
+
+func reportBench(out io.Writer, results []benchResult, elapsed time.Duration) {
+	var all []time.Duration
+	var errors, non2xx int
+	var bytes int64
+	for _, result := range results {
+		all = append(all, result.latencies...)
+		errors += result.errors
+		non2xx += result.non2xx
+		bytes += result.bytes
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+
+	seconds := elapsed.Seconds()
+	fmt.Fprintf(out, "requests:   %d in %s (%d errors, %d non-2xx)\n", len(all), elapsed.Round(time.Millisecond), errors, non2xx)
+	fmt.Fprintf(out, "throughput: %.1f req/s, %.1f KiB/s\n", float64(len(all))/seconds, float64(bytes)/1024/seconds)
+	if len(all) == 0 {
+		return
+	}
+
+	var total time.Duration
+	for _, latency := range all {
+		total += latency
+	}
+	fmt.Fprintf(out, "latency:    mean %s, p50 %s, p90 %s, p99 %s, max %s\n",
+		(total / time.Duration(len(all))).Round(time.Microsecond),
+		percentile(all, 50), percentile(all, 90), percentile(all, 99),
+		all[len(all)-1].Round(time.Microsecond))
+}
+
+// percentile returns the p-th percentile of sorted latencies using the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Round(time.Microsecond)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxBodyDrain is how much of a body its handler left unread the server
+// will discard to keep the connection alive. Anything larger and the
+// connection is closed instead.
+const maxBodyDrain = 256 << 10
+
+// maxBodyPrealloc is the most BodyBytes allocates for a body before any
+// of it has been read.
+const maxBodyPrealloc = 64 << 10
+
+// defaultMaxDecompressedBodyBytes is the decompressed size limit for
+// gzip-encoded request bodies when Server.MaxDecompressedBodyBytes is zero.
+const defaultMaxDecompressedBodyBytes = 32 << 20
+
+// errBodyTooLarge is returned by reads of a request body that grows beyond
+// the limit set by Server.MaxBodyBytes or LimitBody, or whose decompressed
+// size grows beyond Server.MaxDecompressedBodyBytes.
+var errBodyTooLarge = errors.New("request body too large")
+
+// requestBody reads a request body straight off the connection, either up
+// to its Content-Length or by decoding its chunks, so that a handler can
+// stream it rather than hold it in memory.
+type requestBody struct {
+	r      io.Reader
+	length int64 // -1 for chunked bodies
+	limit  int64 // cap on bytes read, if greater than zero
+	read   int64
+	err    error
+	onEOF  func()
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.limit > 0 {
+		if b.read >= b.limit {
+			// Only a body that has more to give breaks the limit.
+			if n, err := b.r.Read(make([]byte, 1)); n > 0 || err != io.EOF {
+				b.finish(errBodyTooLarge)
+			} else {
+				b.finish(io.EOF)
+			}
+			return 0, b.err
+		}
+		if int64(len(p)) > b.limit-b.read {
+			p = p[:b.limit-b.read]
+		}
+	}
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+func (b *requestBody) finish(err error) {
+	b.err = err
+	if err == io.EOF && b.onEOF != nil {
+		b.onEOF()
+	}
+}
+
+// drain discards whatever the handler left unread, up to maxBodyDrain, and
+// reports whether the body was consumed cleanly so that the next request
+// on the connection can be read.
+func (b *requestBody) drain() bool {
+	if b.err == nil {
+		if _, err := io.CopyN(io.Discard, b, maxBodyDrain+1); err == nil {
+			return false
+		}
+	}
+	return b.err == io.EOF
+}
+
+// drainable reports whether drain can be expected to consume the rest of
+// the body, so that the connection can be kept open past the response.
+// Chunked bodies are given the benefit of the doubt.
+func (b *requestBody) drainable() bool {
+	if b.err != nil {
+		return b.err == io.EOF
+	}
+	return b.length < 0 || b.remaining() <= maxBodyDrain
+}
+
+// lengthReader reads exactly length bytes from r, reporting a body cut
+// short by the client as io.ErrUnexpectedEOF.
+type lengthReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.left {
+		p = p[:l.left]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if err == io.EOF && l.left > 0 {
+		err = io.ErrUnexpectedEOF
+	} else if err == nil && l.left == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// parseBody sets up request.Body to read the body that follows the head.
+// Heads that leave the body's length ambiguous are refused with 400, as
+// RFC 7230 section 3.3.3 requires: a proxy in front of the server could
+// otherwise frame the body differently and have the rest of it taken for
+// another request.
+func (s *Server) parseBody(reader *bufio.Reader, request *HTTPRequest) error {
+	body := &request.body
+	request.Body = body
+
+	contentLengths := request.Headers.Values("Content-Length")
+	if transferEncoding := request.Headers.Values("Transfer-Encoding"); len(transferEncoding) > 0 {
+		if len(contentLengths) > 0 {
+			return &requestError{status: StatusBadRequest, err: errors.New("both Transfer-Encoding and Content-Length sent")}
+		}
+		codings := strings.Split(strings.Join(transferEncoding, ","), ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return &requestError{status: StatusBadRequest, err: fmt.Errorf("Transfer-Encoding doesn't end in chunked: %q", strings.Join(transferEncoding, ", "))}
+		}
+		if len(codings) > 1 {
+			// Only chunked is understood as a transfer coding.
+			return &requestError{status: StatusNotImplemented, err: fmt.Errorf("unsupported Transfer-Encoding: %q", strings.Join(transferEncoding, ", "))}
+		}
+		limit := s.MaxChunkedBodyBytes
+		if limit == 0 {
+			limit = defaultMaxChunkedBodyBytes
+		}
+		body.r = newChunkedReader(reader, limit, &request.Trailers)
+		body.length = -1
+		return s.decodeBody(request)
+	}
+
+	length, err := parseContentLength(contentLengths)
+	if err != nil {
+		return &requestError{status: StatusBadRequest, err: err}
+	}
+	body.r = &lengthReader{r: reader, left: length}
+	body.length = length
+	if length == 0 {
+		body.err = io.EOF
+	}
+	return s.decodeBody(request)
+}
+
+// parseContentLength parses the Content-Length fields of a request, which
+// may be repeated or hold a list, provided every value is the same. No
+// fields means an empty body.
+func parseContentLength(values []string) (int64, error) {
+	length := int64(-1)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			n, err := strconv.ParseInt(part, 10, 64)
+			if err != nil || n < 0 || part[0] == '+' {
+				return 0, fmt.Errorf("invalid Content-Length: %q", value)
+			}
+			if length >= 0 && n != length {
+				return 0, fmt.Errorf("conflicting Content-Length values: %q", strings.Join(values, ", "))
+			}
+			length = n
+		}
+	}
+	return max(length, 0), nil
+}
+
+// decodeBody sets up request.Body to decompress a body sent with a
+// Content-Encoding, which is then removed from the headers along with the
+// Content-Length, as neither describes the body handlers read. Only gzip is
+// understood; other codings are refused with 415.
+func (s *Server) decodeBody(request *HTTPRequest) error {
+	encoding := strings.ToLower(strings.TrimSpace(request.Headers.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+	default:
+		return &requestError{status: StatusUnsupportedMediaType, err: fmt.Errorf("unsupported Content-Encoding: %q", encoding)}
+	}
+
+	limit := s.MaxDecompressedBodyBytes
+	if limit == 0 {
+		limit = defaultMaxDecompressedBodyBytes
+	}
+	request.Body = &gzipBody{raw: &request.body, limit: limit}
+	request.Headers.Del("Content-Encoding")
+	request.Headers.Del("Content-Length")
+	return nil
+}
+
+// gzipBody decompresses a gzip-encoded request body as it is read, failing
+// with errBodyTooLarge once the decompressed bytes outgrow limit, if it is
+// greater than zero, so that a small upload can't expand without bound.
+// Corrupt input fails with a 400 HTTPError.
+type gzipBody struct {
+	raw   *requestBody
+	zr    *gzip.Reader
+	limit int64
+	read  int64
+	err   error
+}
+
+func (g *gzipBody) Read(p []byte) (int, error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+	if g.zr == nil {
+		// The gzip header is only read once the handler asks for the body.
+		zr, err := gzip.NewReader(g.raw)
+		if err != nil {
+			g.err = malformedGzip(err)
+			return 0, g.err
+		}
+		g.zr = zr
+	}
+	if g.limit > 0 {
+		if g.read >= g.limit {
+			if n, err := g.zr.Read(make([]byte, 1)); n > 0 || err != io.EOF {
+				g.err = errBodyTooLarge
+			} else {
+				g.err = io.EOF
+			}
+			return 0, g.err
+		}
+		if int64(len(p)) > g.limit-g.read {
+			p = p[:g.limit-g.read]
+		}
+	}
+	n, err := g.zr.Read(p)
+	g.read += int64(n)
+	if err != nil {
+		g.err = malformedGzip(err)
+	}
+	return n, g.err
+}
+
+// malformedGzip turns errors about the gzip stream itself into a 400, and
+// passes anything else, such as the connection failing, through.
+func malformedGzip(err error) error {
+	var corrupt flate.CorruptInputError
+	if errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum) || errors.As(err, &corrupt) {
+		return &HTTPError{Code: StatusBadRequest, Message: "malformed gzip body", Err: err}
+	}
+	return err
+}
+
+// remaining returns how many bytes of a Content-Length body are left.
+func (b *requestBody) remaining() int64 {
+	if l, ok := b.r.(*lengthReader); ok && b.err == nil {
+		return l.left
+	}
+	return 0
+}
+
+// BodyBytes reads the rest of the request body into memory. Later calls
+// return the same bytes. Handlers dealing with large bodies should read
+// Body as a stream instead.
+func (r *HTTPRequest) BodyBytes() ([]byte, error) {
+	if r.bodyRead {
+		return r.bodyBytes, nil
+	}
+	var content []byte
+	var err error
+	if body, ok := r.Body.(*requestBody); ok && body.length >= 0 {
+		// The declared length only sizes the first allocation, up to
+		// maxBodyPrealloc: the rest grows as bytes actually arrive, so a
+		// forged Content-Length can't reserve memory for a body never sent.
+		buf := bytes.NewBuffer(make([]byte, 0, min(body.remaining(), maxBodyPrealloc)))
+		_, err = buf.ReadFrom(body)
+		content = buf.Bytes()
+	} else {
+		content, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.bodyBytes, r.bodyRead = content, true
+	return content, nil
+}
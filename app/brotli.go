@@ -0,0 +1,413 @@
+package main
+
+import "io"
+
+// brotliBlockSize is how much input goes into each meta-block. Copies
+// stay within their meta-block, so it also bounds how far back they
+// reach, well inside the window the stream header announces.
+const brotliBlockSize = 1 << 16
+
+// brotliWindowBits is the WBITS of the stream header: a window of 256 KiB
+// less 16 bytes.
+const brotliWindowBits = 18
+
+// Insert and copy lengths are sent as one of 24 codes, each covering a
+// range starting at its base with as many extra bits as the range needs
+// (RFC 7932, section 5).
+var (
+	brotliInsertBase = [24]int{0, 1, 2, 3, 4, 5, 6, 8, 10, 14, 18, 26, 34, 50, 66, 98, 130, 194, 322, 578, 1090, 2114, 6210, 22594}
+	brotliInsertBits = [24]uint{0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 12, 14, 24}
+	brotliCopyBase   = [24]int{2, 3, 4, 5, 6, 7, 8, 9, 10, 12, 14, 18, 22, 30, 38, 54, 70, 102, 134, 198, 326, 582, 1094, 2118}
+	brotliCopyBits   = [24]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 24}
+)
+
+// brotliCommandBase maps the high bits of an insert code and a copy code
+// to the first of their 64 insert-and-copy symbols, among those followed
+// by an explicit distance.
+var brotliCommandBase = [3][3]int{
+	{128, 192, 384},
+	{256, 320, 512},
+	{448, 576, 640},
+}
+
+// brotliCodeLengthOrder is the order code length code lengths are sent in.
+var brotliCodeLengthOrder = [18]int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// brotliCodeLengthCodes are the fixed codes, as bits to write and their
+// count, for the lengths 0 to 5 of the code length code.
+var brotliCodeLengthCodes = [6]struct {
+	bits uint64
+	n    uint
+}{{0, 2}, {7, 4}, {3, 3}, {2, 2}, {1, 2}, {15, 4}}
+
+// brotliWriter compresses into the brotli format (RFC 7932). Input is
+// gathered into meta-blocks, each with its own prefix codes for literals,
+// insert-and-copy lengths and distances over the copies lzMatcher finds.
+// Blocks that would come out larger are stored uncompressed instead.
+type brotliWriter struct {
+	dst     io.Writer
+	buf     []byte
+	bw      bitWriter
+	matcher lzMatcher
+	seqs    []lzSequence
+	started bool
+	err     error
+}
+
+func newBrotliWriter(dst io.Writer) io.WriteCloser {
+	return &brotliWriter{dst: dst}
+}
+
+func (w *brotliWriter) Reset(dst io.Writer) {
+	w.dst = dst
+	w.buf = w.buf[:0]
+	w.bw.reset()
+	w.started = false
+	w.err = nil
+}
+
+func (w *brotliWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		take := min(len(p), brotliBlockSize-len(w.buf))
+		w.buf = append(w.buf, p[:take]...)
+		p = p[take:]
+		if len(w.buf) == brotliBlockSize {
+			if err := w.flushBlock(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes the buffered input and the empty meta-block ending the
+// stream.
+func (w *brotliWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) > 0 {
+		if err := w.flushBlock(); err != nil {
+			return err
+		}
+	}
+	w.header()
+	// ISLAST and ISLASTEMPTY.
+	w.bw.write(3, 2)
+	w.bw.align()
+	w.err = w.output()
+	if w.err == nil {
+		w.err = errWriterClosed
+		return nil
+	}
+	return w.err
+}
+
+func (w *brotliWriter) header() {
+	if !w.started {
+		w.bw.write(1, 1)
+		w.bw.write(brotliWindowBits-17, 3)
+		w.started = true
+	}
+}
+
+// output writes the completed bytes of the stream to dst, keeping back the
+// bits of the last partial byte.
+func (w *brotliWriter) output() error {
+	if len(w.bw.out) == 0 {
+		return nil
+	}
+	_, err := w.dst.Write(w.bw.out)
+	w.bw.out = w.bw.out[:0]
+	return err
+}
+
+func (w *brotliWriter) flushBlock() error {
+	w.header()
+	data := w.buf
+	start, bits, nbits := len(w.bw.out), w.bw.bits, w.bw.nbits
+	w.compressedMetaBlock(data)
+	if len(w.bw.out)-start > len(data)+8 {
+		w.bw.out, w.bw.bits, w.bw.nbits = w.bw.out[:start], bits, nbits
+		w.metaBlockHeader(len(data), true)
+		w.bw.align()
+		w.bw.out = append(w.bw.out, data...)
+	}
+	w.buf = w.buf[:0]
+	w.err = w.output()
+	return w.err
+}
+
+// metaBlockHeader writes the header of a meta-block that isn't the last,
+// holding length bytes.
+func (w *brotliWriter) metaBlockHeader(length int, uncompressed bool) {
+	nibbles := 4
+	for nibbles < 6 && length-1 >= 1<<(4*nibbles) {
+		nibbles++
+	}
+	w.bw.write(0, 1) // ISLAST
+	w.bw.write(uint64(nibbles-4), 2)
+	w.bw.write(uint64(length-1), uint(4*nibbles))
+	if uncompressed {
+		w.bw.write(1, 1)
+	} else {
+		w.bw.write(0, 1)
+	}
+}
+
+// brotliCommand is a sequence put into the symbols that encode it.
+type brotliCommand struct {
+	seq          lzSequence
+	symbol       int
+	insertExtra  uint64
+	insertBits   uint
+	copyExtra    uint64
+	copyBits     uint
+	distance     int
+	distanceExtr uint64
+	distanceBits uint
+}
+
+func (w *brotliWriter) compressedMetaBlock(data []byte) {
+	w.seqs = w.matcher.find(data, w.seqs[:0])
+	commands := make([]brotliCommand, len(w.seqs))
+	literalCounts := make([]int, 256)
+	commandCounts := make([]int, 704)
+	distanceCounts := make([]int, 64)
+
+	pos := 0
+	for i, seq := range w.seqs {
+		for _, c := range data[pos : pos+seq.literals] {
+			literalCounts[c]++
+		}
+		pos += seq.literals + seq.length
+
+		cmd := &commands[i]
+		cmd.seq = seq
+		insertCode := brotliLengthCode(brotliInsertBase[:], seq.literals)
+		cmd.insertExtra = uint64(seq.literals - brotliInsertBase[insertCode])
+		cmd.insertBits = brotliInsertBits[insertCode]
+		// A copy after the final literals is never made, so its length
+		// is a placeholder.
+		copyCode := 0
+		if seq.length > 0 {
+			copyCode = brotliLengthCode(brotliCopyBase[:], seq.length)
+			cmd.copyExtra = uint64(seq.length - brotliCopyBase[copyCode])
+			cmd.copyBits = brotliCopyBits[copyCode]
+		}
+		cmd.symbol = brotliCommandBase[insertCode>>3][copyCode>>3] + (insertCode&7)<<3 + copyCode&7
+		commandCounts[cmd.symbol]++
+
+		if seq.length > 0 {
+			// With no postfix bits or direct codes, distance codes 16
+			// and up cover distance+3 by its top two bits and the
+			// number of bits below them.
+			value := seq.offset + 3
+			nbits := uint(bitLength(value) - 2)
+			cmd.distance = 16 + 2*int(nbits-1) + (value>>nbits)&1
+			cmd.distanceExtr = uint64(value & (1<<nbits - 1))
+			cmd.distanceBits = nbits
+			distanceCounts[cmd.distance]++
+		}
+	}
+
+	w.metaBlockHeader(len(data), false)
+	// One block type of each kind, no postfix bits or direct distance
+	// codes, the LSB6 context mode, and a single literal and distance
+	// prefix code, so no context maps.
+	w.bw.write(0, 1) // NBLTYPESL
+	w.bw.write(0, 1) // NBLTYPESI
+	w.bw.write(0, 1) // NBLTYPESD
+	w.bw.write(0, 2) // NPOSTFIX
+	w.bw.write(0, 4) // NDIRECT
+	w.bw.write(0, 2) // context mode
+	w.bw.write(0, 1) // NTREESL
+	w.bw.write(0, 1) // NTREESD
+
+	literals := w.writePrefixCode(literalCounts, 8)
+	commandCode := w.writePrefixCode(commandCounts, 10)
+	distances := w.writePrefixCode(distanceCounts, 6)
+
+	pos = 0
+	for _, cmd := range commands {
+		commandCode.put(&w.bw, cmd.symbol)
+		w.bw.write(cmd.insertExtra, cmd.insertBits)
+		w.bw.write(cmd.copyExtra, cmd.copyBits)
+		for _, c := range data[pos : pos+cmd.seq.literals] {
+			literals.put(&w.bw, int(c))
+		}
+		pos += cmd.seq.literals + cmd.seq.length
+		if cmd.seq.length > 0 {
+			distances.put(&w.bw, cmd.distance)
+			w.bw.write(cmd.distanceExtr, cmd.distanceBits)
+		}
+	}
+}
+
+// prefixCode holds the codes of a canonical prefix code, bit-reversed so
+// that writing them least significant bit first sends them in the
+// most-significant-first order brotli and DEFLATE read them in.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16
+}
+
+func newPrefixCode(lengths []uint8) prefixCode {
+	var lengthCounts [16]int
+	for _, length := range lengths {
+		lengthCounts[length]++
+	}
+	lengthCounts[0] = 0
+	var next [16]int
+	code := 0
+	for length := 1; length < 16; length++ {
+		code = (code + lengthCounts[length-1]) << 1
+		next[length] = code
+	}
+	codes := make([]uint16, len(lengths))
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		codes[symbol] = reverseBits(uint16(next[length]), length)
+		next[length]++
+	}
+	return prefixCode{lengths: lengths, codes: codes}
+}
+
+func (p prefixCode) put(bw *bitWriter, symbol int) {
+	bw.write(uint64(p.codes[symbol]), uint(p.lengths[symbol]))
+}
+
+// writePrefixCode describes a prefix code for symbols occurring counts
+// times and returns it. A code for a single symbol, or none, is sent in
+// the simple form and spends no bits on the symbol.
+func (w *brotliWriter) writePrefixCode(counts []int, alphabetBits uint) prefixCode {
+	lengths := huffmanLengths(counts, 15)
+	used, last := 0, 0
+	for symbol, length := range lengths {
+		if length > 0 {
+			used++
+			last = symbol
+		}
+	}
+	if used <= 1 {
+		w.bw.write(1, 2) // HSKIP of 1: a simple prefix code
+		w.bw.write(0, 2) // of one symbol
+		w.bw.write(uint64(last), alphabetBits)
+		return prefixCode{lengths: make([]uint8, len(counts)), codes: make([]uint16, len(counts))}
+	}
+
+	// The lengths are run-length coded up to the last used symbol, with
+	// code 17 for runs of zeros.
+	type token struct {
+		symbol int
+		extra  uint64
+	}
+	var tokens []token
+	for i := 0; i <= last; {
+		if lengths[i] != 0 {
+			tokens = append(tokens, token{symbol: int(lengths[i])})
+			i++
+			continue
+		}
+		run := 0
+		for i+run <= last && lengths[i+run] == 0 {
+			run++
+		}
+		i += run
+		if run < 3 {
+			for ; run > 0; run-- {
+				tokens = append(tokens, token{})
+			}
+			continue
+		}
+		// Consecutive 17s multiply the run by 8 each, so the run goes
+		// out as base-8 digits, most significant first.
+		start := len(tokens)
+		run -= 3
+		for {
+			tokens = append(tokens, token{symbol: 17, extra: uint64(run & 7)})
+			run >>= 3
+			if run == 0 {
+				break
+			}
+			run--
+		}
+		for a, b := start, len(tokens)-1; a < b; a, b = a+1, b-1 {
+			tokens[a], tokens[b] = tokens[b], tokens[a]
+		}
+	}
+
+	tokenCounts := make([]int, 18)
+	for _, t := range tokens {
+		tokenCounts[t.symbol]++
+	}
+	distinct := 0
+	for _, count := range tokenCounts {
+		if count > 0 {
+			distinct++
+		}
+	}
+	if distinct == 1 {
+		// A code length code needs two symbols to be complete.
+		if tokenCounts[0] == 0 {
+			tokenCounts[0] = 1
+		} else {
+			tokenCounts[1] = 1
+		}
+	}
+	codeLengthLengths := huffmanLengths(tokenCounts, 5)
+	codeLengthCode := newPrefixCode(codeLengthLengths)
+
+	w.bw.write(0, 2) // HSKIP of 0: a complex prefix code, nothing skipped
+	space := 32
+	for _, symbol := range brotliCodeLengthOrder {
+		length := codeLengthLengths[symbol]
+		w.bw.write(brotliCodeLengthCodes[length].bits, brotliCodeLengthCodes[length].n)
+		if length != 0 {
+			space -= 32 >> length
+			if space <= 0 {
+				break
+			}
+		}
+	}
+	for _, t := range tokens {
+		codeLengthCode.put(&w.bw, t.symbol)
+		if t.symbol == 17 {
+			w.bw.write(t.extra, 3)
+		}
+	}
+	return newPrefixCode(lengths)
+}
+
+// brotliLengthCode returns the code whose range holds n, for insert or
+// copy lengths.
+func brotliLengthCode(base []int, n int) int {
+	code := len(base) - 1
+	for base[code] > n {
+		code--
+	}
+	return code
+}
+
+func bitLength(n int) int {
+	length := 0
+	for ; n > 0; n >>= 1 {
+		length++
+	}
+	return length
+}
+
+func reverseBits(v uint16, n uint8) uint16 {
+	var r uint16
+	for i := uint8(0); i < n; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// encoderCorpus returns the inputs the encoders are checked against:
+// empty and tiny inputs, text, data with long repeats and none at all,
+// sizes either side of a block, and a large body spanning many blocks.
+func encoderCorpus(t *testing.T) []struct {
+	name string
+	data []byte
+} {
+	t.Helper()
+	sources, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text []byte
+	for _, name := range sources {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text = append(text, content...)
+	}
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 200<<10)
+	r.Read(random)
+	alphabet := make([]byte, 4096)
+	for i := range alphabet {
+		alphabet[i] = byte(i)
+	}
+	// The large body mixes text, noise and repeats from far back, so that
+	// matches cross blocks and stored and compressed blocks alternate.
+	var large []byte
+	for len(large) < 8<<20 {
+		start := r.Intn(len(text) - 64<<10)
+		large = append(large, text[start:start+r.Intn(64<<10)]...)
+		large = append(large, random[:r.Intn(16<<10)]...)
+		if len(large) > 1<<20 {
+			start := r.Intn(len(large) - 1<<20)
+			large = append(large, large[start:start+r.Intn(4<<10)]...)
+		}
+	}
+
+	return []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"one byte", []byte("a")},
+		{"short", []byte("hello, world\n")},
+		{"every byte value", alphabet},
+		{"run", bytes.Repeat([]byte("a"), 300<<10)},
+		{"repeated phrase", []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 5000))},
+		{"random", random},
+		{"block less one", text[:1<<16-1]},
+		{"block", text[:1<<16]},
+		{"block and one", text[:1<<16+1]},
+		{"source", text},
+		{"large", large},
+	}
+}
+
+// referenceDecode decompresses data with the reference decoder of a
+// content coding, run as "name -dc". Tests are skipped where it isn't
+// installed.
+func referenceDecode(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+	path, err := exec.LookPath(name)
+	if err != nil {
+		t.Skipf("%s is not installed", name)
+	}
+	cmd := exec.Command(path, "-dc")
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s -dc: %v: %s", name, err, stderr.Bytes())
+	}
+	return out
+}
+
+// testEncoderRoundTrip compresses the corpus with encoder, in one write
+// and in uneven pieces, through one writer reset between inputs as the
+// pool reuses them, and checks that decoder gives every input back.
+func testEncoderRoundTrip(t *testing.T, encoder Encoder, decoder string) {
+	var compressed bytes.Buffer
+	zw := encoder(&compressed)
+	for _, tt := range encoderCorpus(t) {
+		for _, pieces := range []bool{false, true} {
+			name := tt.name
+			if pieces {
+				name += " in pieces"
+			}
+			t.Run(name, func(t *testing.T) {
+				compressed.Reset()
+				zw.(interface{ Reset(io.Writer) }).Reset(&compressed)
+				data := tt.data
+				for len(data) > 0 {
+					n := len(data)
+					if pieces {
+						n = min(n, 1+len(data)%7919)
+					}
+					if _, err := zw.Write(data[:n]); err != nil {
+						t.Fatal(err)
+					}
+					data = data[n:]
+				}
+				if err := zw.Close(); err != nil {
+					t.Fatal(err)
+				}
+				got := referenceDecode(t, decoder, compressed.Bytes())
+				if !bytes.Equal(got, tt.data) {
+					t.Fatalf("decoded %d bytes, want the %d put in", len(got), len(tt.data))
+				}
+				if len(tt.data) >= 4<<10 && compressed.Len() > len(tt.data)+len(tt.data)/100+64 {
+					t.Errorf("compressed %d bytes into %d", len(tt.data), compressed.Len())
+				}
+			})
+		}
+	}
+}
+
+func TestBrotliWriterRoundTrip(t *testing.T) {
+	testEncoderRoundTrip(t, newBrotliWriter, "brotli")
+}
+
+func TestBrotliWriterClosed(t *testing.T) {
+	zw := newBrotliWriter(io.Discard)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write([]byte("late")); err != errWriterClosed {
+		t.Errorf("Write after Close = %v, want %v", err, errWriterClosed)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// chunkedWriter frames each write as one chunk of a response sent with
+// Transfer-Encoding: chunked. Close writes the terminating zero-length
+// chunk.
+type chunkedWriter struct {
+	w io.Writer
+}
+
+func (c *chunkedWriter) Write(p []byte) (int, error) {
+	// A zero-length chunk would end the body early.
+	if len(p) == 0 {
+		return 0, nil
+	}
+	chunk := make([]byte, 0, len(p)+20)
+	chunk = strconv.AppendInt(chunk, int64(len(p)), 16)
+	chunk = append(chunk, "\r\n"...)
+	chunk = append(chunk, p...)
+	chunk = append(chunk, "\r\n"...)
+	if _, err := c.w.Write(chunk); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *chunkedWriter) Close() error {
+	_, err := io.WriteString(c.w, "0\r\n\r\n")
+	return err
+}
+
+// errChunkedBodyTooLarge is returned by chunkedReader when the decoded body
+// grows beyond its limit.
+var errChunkedBodyTooLarge = errors.New("chunked body too large")
+
+// chunkedReader decodes a body sent with Transfer-Encoding: chunked as it
+// is read. Once the body has been read to EOF, its trailer fields are
+// stored in *trailers, if any were sent.
+type chunkedReader struct {
+	r        *bufio.Reader
+	limit    int64 // cap on the decoded size; zero or less means none
+	read     int64
+	left     int64 // bytes left in the current chunk
+	trailers *Header
+	err      error
+}
+
+func newChunkedReader(r *bufio.Reader, limit int64, trailers *Header) *chunkedReader {
+	return &chunkedReader{r: r, limit: limit, trailers: trailers}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.left == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+	}
+
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	c.read += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && c.left == 0 {
+		err = c.chunkEnd()
+	}
+	c.err = err
+	return n, err
+}
+
+// maxChunkLineBytes caps a chunk-size line, extensions included, and
+// maxTrailerBytes the whole trailer section, so that a line that never
+// ends can't have the server buffer without bound.
+const (
+	maxChunkLineBytes = 4 << 10
+	maxTrailerBytes   = 64 << 10
+)
+
+// nextChunk reads the size line of the next chunk, and the trailer section
+// after the last one, in which case it returns io.EOF.
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.readLine(maxChunkLineBytes)
+	if err != nil {
+		return err
+	}
+	n, err := parseChunkSize(line)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if err := c.readTrailers(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	if c.limit > 0 && n > c.limit-c.read {
+		return errChunkedBodyTooLarge
+	}
+	c.left = n
+	return nil
+}
+
+// parseChunkSize parses a chunk-size line, without its line ending. The
+// size must be hex digits alone: signs, prefixes and padding are refused,
+// since a server that read them differently would disagree over where the
+// chunk ends. Chunk extensions follow the size after a ";" and are
+// ignored.
+func parseChunkSize(line string) (int64, error) {
+	size, _, hasExt := strings.Cut(line, ";")
+	if hasExt {
+		size = strings.TrimRight(size, " \t")
+	}
+	if size == "" || strings.TrimLeft(size, "0123456789abcdefABCDEF") != "" {
+		return 0, malformedChunked("malformed chunk size: %q", line)
+	}
+	n, err := strconv.ParseInt(size, 16, 64)
+	if err != nil {
+		return 0, malformedChunked("malformed chunk size: %q", line)
+	}
+	return n, nil
+}
+
+// readLine reads a line of at most limit bytes and returns it without its
+// line ending.
+func (c *chunkedReader) readLine(limit int) (string, error) {
+	var line []byte
+	for {
+		part, err := c.r.ReadSlice('\n')
+		if len(line)+len(part) > limit {
+			return "", malformedChunked("line longer than %d bytes", limit)
+		}
+		line = append(line, part...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", unexpectedEOF(err)
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+	}
+}
+
+func (c *chunkedReader) chunkEnd() error {
+	var crlf [2]byte
+	if _, err := io.ReadFull(c.r, crlf[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	if string(crlf[:]) != "\r\n" {
+		return malformedChunked("chunk not terminated by CRLF")
+	}
+	return nil
+}
+
+func (c *chunkedReader) readTrailers() error {
+	budget := maxTrailerBytes
+	for {
+		line, err := c.readLine(budget)
+		if err != nil {
+			return err
+		}
+		budget -= len(line) + 2
+		if line == "" {
+			return nil
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || !isToken(key) {
+			return malformedChunked("malformed trailer: %q", line)
+		}
+		value = strings.TrimSpace(value)
+		if !validFieldValue(value) {
+			return malformedChunked("invalid value for trailer %s", key)
+		}
+		if c.trailers == nil {
+			continue
+		}
+		if *c.trailers == nil {
+			*c.trailers = make(Header)
+		}
+		c.trailers.Add(key, value)
+	}
+}
+
+// malformedChunked reports a body that breaks the chunked framing, which
+// is answered with 400.
+func malformedChunked(format string, args ...any) error {
+	return &HTTPError{Code: StatusBadRequest, Message: "malformed chunked body", Err: fmt.Errorf(format, args...)}
+}
+
+// unexpectedEOF reports a connection closed in the middle of a body as
+// io.ErrUnexpectedEOF rather than a clean end of input.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// errMalformed stands for the 400 HTTPError of a broken chunked framing in
+// the tests below.
+var errMalformed = errors.New("malformed")
+
+func TestChunkedReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		limit    int64
+		want     string
+		trailers Header
+		err      error
+	}{
+		{name: "chunks", body: "5\r\nhello\r\n7\r\n, world\r\n0\r\n\r\n", want: "hello, world"},
+		{name: "empty", body: "0\r\n\r\n", want: ""},
+		{name: "upper-case hex", body: "A\r\n0123456789\r\n0\r\n\r\n", want: "0123456789"},
+		{name: "leading zeros", body: "005\r\nhello\r\n0\r\n\r\n", want: "hello"},
+		{name: "extensions", body: "5;name=value\r\nhello\r\n0 ; last\r\n\r\n", want: "hello"},
+		{
+			name:     "trailers",
+			body:     "5\r\nhello\r\n0\r\nX-Checksum: abc\r\nX-Other:  d \r\n\r\n",
+			want:     "hello",
+			trailers: Header{"X-Checksum": {"abc"}, "X-Other": {"d"}},
+		},
+		{name: "sign", body: "+5\r\nhello\r\n0\r\n\r\n", err: errMalformed},
+		{name: "hex prefix", body: "0x5\r\nhello\r\n0\r\n\r\n", err: errMalformed},
+		{name: "space before size", body: " 5\r\nhello\r\n0\r\n\r\n", err: errMalformed},
+		{name: "space after size", body: "5 \r\nhello\r\n0\r\n\r\n", err: errMalformed},
+		{name: "not hex", body: "g\r\nhello\r\n0\r\n\r\n", err: errMalformed},
+		{name: "empty size", body: "\r\nhello\r\n0\r\n\r\n", err: errMalformed},
+		{name: "overflowing size", body: "ffffffffffffffffff\r\nhello\r\n0\r\n\r\n", err: errMalformed},
+		{name: "chunk too long", body: "3\r\nhello\r\n0\r\n\r\n", err: errMalformed},
+		{name: "long size line", body: "5;" + strings.Repeat("x", maxChunkLineBytes) + "\r\nhello\r\n0\r\n\r\n", err: errMalformed},
+		{name: "malformed trailer", body: "0\r\nX-Checksum abc\r\n\r\n", err: errMalformed},
+		{name: "trailer with whitespace before colon", body: "0\r\nX-Checksum : abc\r\n\r\n", err: errMalformed},
+		{name: "large trailers", body: "0\r\n" + strings.Repeat("X-A: "+strings.Repeat("a", 1000)+"\r\n", 70) + "\r\n", err: errMalformed},
+		{name: "over the limit", body: "5\r\nhello\r\n7\r\n, world\r\n0\r\n\r\n", limit: 10, err: errChunkedBodyTooLarge},
+		{name: "size overflowing the limit", body: "5\r\nhello\r\n7fffffffffffffff\r\nworld", limit: 10, err: errChunkedBodyTooLarge},
+		{name: "at the limit", body: "5\r\nhello\r\n7\r\n, world\r\n0\r\n\r\n", limit: 12, want: "hello, world"},
+		{name: "cut short in chunk", body: "5\r\nhel", err: io.ErrUnexpectedEOF},
+		{name: "cut short before last chunk", body: "5\r\nhello\r\n", err: io.ErrUnexpectedEOF},
+		{name: "cut short in trailers", body: "0\r\nX-A: b\r\n", err: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var trailers Header
+			r := newChunkedReader(bufio.NewReader(strings.NewReader(tt.body)), tt.limit, &trailers)
+			got, err := io.ReadAll(r)
+			if tt.err != nil {
+				var httpErr *HTTPError
+				if tt.err == errMalformed {
+					if !errors.As(err, &httpErr) || httpErr.Code != StatusBadRequest {
+						t.Fatalf("read = %q, %v; want a 400 HTTPError", got, err)
+					}
+				} else if !errors.Is(err, tt.err) {
+					t.Fatalf("read = %q, %v; want %v", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+			if len(trailers) != len(tt.trailers) {
+				t.Fatalf("trailers = %q, want %q", trailers, tt.trailers)
+			}
+			for name, values := range tt.trailers {
+				if got := trailers.Values(name); strings.Join(got, "\n") != strings.Join(values, "\n") {
+					t.Errorf("trailer %s = %q, want %q", name, got, values)
+				}
+			}
+		})
+	}
+}
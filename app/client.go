@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client is a small HTTP/1.1 client that speaks the same subset of the
+// protocol as the server. Each request uses a fresh connection.
+type Client struct {
+	Timeout time.Duration
+
+	// InsecureSkipVerify disables certificate verification for https
+	// targets, for testing against self-signed certificates.
+	InsecureSkipVerify bool
+}
+
+type ClientResponse struct {
+	StatusCode int
+	// Status is the reason phrase of the status line, e.g. "Not Found".
+	Status  string
+	Headers Header
+	Body    []byte
+}
+
+// Do sends a request to rawURL and reads the complete response.
+func (c *Client) Do(method HTTPMethod, rawURL string, headers Header, body []byte) (*ClientResponse, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.dial(target)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+
+	path := target.RequestURI()
+	request := fmt.Sprintf("%s %s HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n", method, path, target.Host)
+	for key, values := range headers {
+		if !isToken(key) {
+			return nil, fmt.Errorf("invalid header name %q", key)
+		}
+		for _, value := range values {
+			// A value with a line break would smuggle a header of its
+			// own, or a second request, to the server.
+			if !validFieldValue(value) {
+				return nil, fmt.Errorf("invalid value for header %s", key)
+			}
+			request += fmt.Sprintf("%s: %s\r\n", key, value)
+		}
+	}
+	if len(body) > 0 {
+		request += fmt.Sprintf("Content-Length: %d\r\n", len(body))
+	}
+	request += "\r\n"
+	if _, err := conn.Write(append([]byte(request), body...)); err != nil {
+		return nil, err
+	}
+
+	return readClientResponse(bufio.NewReader(conn), method == MethodHead)
+}
+
+func (c *Client) dial(target *url.URL) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	host := target.Host
+	switch target.Scheme {
+	case "http":
+		if target.Port() == "" {
+			host = net.JoinHostPort(target.Hostname(), "80")
+		}
+		return dialer.Dial("tcp", host)
+	case "https":
+		if target.Port() == "" {
+			host = net.JoinHostPort(target.Hostname(), "443")
+		}
+		return tls.DialWithDialer(dialer, "tcp", host, &tls.Config{
+			ServerName:         target.Hostname(),
+			InsecureSkipVerify: c.InsecureSkipVerify,
+		})
+	}
+	return nil, fmt.Errorf("unsupported scheme: %s", target.Scheme)
+}
+
+// readClientResponse reads a response from reader. Responses to HEAD, and
+// 1xx, 204 and 304 responses, have no body, whatever their headers say.
+func readClientResponse(reader *bufio.Reader, head bool) (*ClientResponse, error) {
+	statusLine, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(strings.TrimSpace(statusLine), " ", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("malformed status line: %q", statusLine)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed status line: %q", statusLine)
+	}
+
+	headers := make(Header)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			headers.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+		}
+	}
+
+	var body []byte
+	if head || code/100 == 1 || code == 204 || code == 304 {
+		// No body to read.
+	} else if strings.EqualFold(headers.Get("Transfer-Encoding"), "chunked") {
+		body, err = io.ReadAll(newChunkedReader(reader, 0, nil))
+		if err != nil {
+			return nil, err
+		}
+	} else if length, ok := headers.lookup("Content-Length"); ok {
+		n, err := strconv.Atoi(length)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Content-Length: %q", length)
+		}
+		body = make([]byte, n)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return nil, err
+		}
+	} else {
+		// Without a length the body runs until the server closes.
+		body, err = io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response := &ClientResponse{StatusCode: code, Headers: headers, Body: body}
+	if len(parts) == 3 {
+		response.Status = parts[2]
+	}
+	return response, nil
+}
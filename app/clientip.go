@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseTrustedProxies parses a list of CIDR ranges, or bare addresses, for
+// Server.TrustedProxies.
+func ParseTrustedProxies(cidrs ...string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range: %w", err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// ClientIP returns the address of the client that sent the request. That
+// is the peer's address, unless the peer is one of Server.TrustedProxies:
+// then the forwarding headers it added are followed back, through any
+// further trusted proxies, to the first address that isn't one. Forwarded
+// is preferred over X-Forwarded-For, and X-Forwarded-For over X-Real-IP.
+// Headers from untrusted peers are ignored, as anyone can send them.
+func (r *HTTPRequest) ClientIP() string {
+	client := stripPort(r.RemoteAddr)
+	if !r.trustedProxy(client) {
+		return client
+	}
+
+	hops := forwardedFor(r.Headers.Values("Forwarded"))
+	if len(hops) == 0 {
+		hops = splitList(r.Headers.Values("X-Forwarded-For"))
+	}
+	if len(hops) == 0 {
+		hops = splitList(r.Headers.Values("X-Real-IP"))
+	}
+	// Each proxy appends the address it got the request from, so the
+	// rightmost hops are the nearest; only those added by trusted proxies
+	// can be believed.
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.Trim(stripPort(hops[i]), "[]"))
+		if ip == nil {
+			// An obfuscated or unknown hop; nothing beyond it can be
+			// traced.
+			break
+		}
+		client = ip.String()
+		if !r.trustedProxy(client) {
+			break
+		}
+	}
+	return client
+}
+
+func (r *HTTPRequest) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range r.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the for= addresses of a Forwarded header, in order.
+// https://www.rfc-editor.org/rfc/rfc7239
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, element := range splitList(values) {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				hops = append(hops, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return hops
+}
+
+// splitList splits comma-separated header values into their trimmed,
+// non-empty elements.
+func splitList(values []string) []string {
+	var elements []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			if element = strings.TrimSpace(element); element != "" {
+				elements = append(elements, element)
+			}
+		}
+	}
+	return elements
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressOptions configures Compress.
+type CompressOptions struct {
+	// Level is the gzip compression level, from gzip.BestSpeed to
+	// gzip.BestCompression. Zero means gzip.DefaultCompression. Other
+	// encodings choose their own.
+	Level int
+
+	// MinSize is the smallest body worth compressing, in bytes. Zero
+	// compresses every body. Streams, whose size isn't known up front, are
+	// always compressed.
+	MinSize int64
+
+	// Types lists the media types to compress; "text/*" matches every
+	// text type. Nil means defaultCompressibleTypes.
+	Types []string
+}
+
+// defaultCompressibleTypes are the media types compressed when
+// CompressOptions.Types is nil. Media types ending in +json or +xml are
+// compressed too.
+var defaultCompressibleTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// Encoder creates a writer compressing everything written to it into dst in
+// one content coding. Closing the writer must end the compressed stream
+// but leave dst open.
+type Encoder func(dst io.Writer) io.WriteCloser
+
+// encoders holds the content codings besides gzip that Compress offers,
+// those enabled with EnableEncoding or registered with RegisterEncoding.
+var encoders = map[string]Encoder{}
+
+// builtinEncoders are the encoders that ship with the server besides gzip.
+// Compress only offers them once enabled with EnableEncoding.
+var builtinEncoders = map[string]Encoder{
+	"br":   newBrotliWriter,
+	"zstd": newZstdWriter,
+}
+
+// encodingPreference orders the content codings Compress knows of, best
+// ratio for text first, so that they win ties in the client's q-values.
+var encodingPreference = []string{"br", "zstd", "gzip"}
+
+// RegisterEncoding adds a content coding for Compress to offer, or replaces
+// a built-in one, such as br with a brotli encoder tuned for a better ratio.
+// It must be called before the server starts. gzip is built in, and so are
+// br and zstd (RFC 8878), though they are off until enabled. Writers with a
+// Reset(io.Writer) method are pooled and reused.
+func RegisterEncoding(name string, encoder Encoder) {
+	name = strings.ToLower(name)
+	encoders[name] = encoder
+	if !slices.Contains(encodingPreference, name) {
+		encodingPreference = append(encodingPreference, name)
+	}
+}
+
+// EnableEncoding has Compress offer one of the built-in content codings
+// that are off by default, br or zstd. It must be called before the server
+// starts.
+func EnableEncoding(name string) error {
+	encoder, ok := builtinEncoders[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("no built-in encoder for %q", name)
+	}
+	RegisterEncoding(name, encoder)
+	return nil
+}
+
+// offeredEncodings lists the content codings there is an encoder for, in
+// order of preference.
+func offeredEncodings() []string {
+	var offers []string
+	for _, name := range encodingPreference {
+		if _, ok := encoders[name]; ok || name == "gzip" {
+			offers = append(offers, name)
+		}
+	}
+	return offers
+}
+
+// compressor is the compression a response has been set up for.
+type compressor struct {
+	options  CompressOptions
+	encoding string
+	active   bool // the response has been marked as compressed
+}
+
+// Compress compresses responses for clients that accept it, in the best of
+// the registered content codings they accept, when their Content-Type is
+// compressible and their body is at least MinSize bytes.
+// Partial content, responses that already carry a Content-Encoding and
+// event streams, which have to reach the client as they are written, are
+// sent as is. Routes can opt out again with NoCompress.
+func Compress(options CompressOptions) Middleware {
+	if options.Level == 0 {
+		options.Level = gzip.DefaultCompression
+	}
+	if _, err := gzip.NewWriterLevel(io.Discard, options.Level); err != nil {
+		panic(fmt.Sprintf("Compress: %v", err))
+	}
+	if options.Types == nil {
+		options.Types = defaultCompressibleTypes
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+			if encoding := negotiateContentEncoding(w, request, offeredEncodings()...); encoding != "identity" && encoding != "" {
+				w.compress = &compressor{options: options, encoding: encoding}
+			}
+			return next(w, request, params)
+		}
+	}
+}
+
+// NoCompress turns off compression set up by Compress, for routes whose
+// responses don't benefit from it or must not be buffered by it.
+func NoCompress(next HandlerFunc) HandlerFunc {
+	return func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+		w.compress = nil
+		return next(w, request, params)
+	}
+}
+
+// compresses reports whether a response with the given status, content
+// type and body size, or -1 if unknown, should be compressed, and marks it
+// with the Content-Encoding if so. Once marked, it stays compressed.
+func (w *ResponseWriter) compresses(status StatusCode, contentType ContentType, size int64) bool {
+	c := w.compress
+	if c == nil {
+		return false
+	}
+	if c.active {
+		return true
+	}
+	if w.header.Has("Content-Encoding") || w.header.Has("Content-Range") {
+		return false
+	}
+	if code := status.Code(); code < 200 || code == 204 || code == 206 || code == 304 {
+		return false
+	}
+	if size >= 0 && size < c.options.MinSize {
+		return false
+	}
+	if !c.compressible(contentType) {
+		return false
+	}
+	w.header.Set("Content-Encoding", c.encoding)
+	c.active = true
+	return true
+}
+
+func (c *compressor) compressible(contentType ContentType) bool {
+	mediaType, _, err := mime.ParseMediaType(string(contentType))
+	if err != nil || mediaType == string(ContentTypeEventStream) {
+		return false
+	}
+	if strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	for _, t := range c.options.Types {
+		if t == mediaType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// resettableWriter is a compressing writer that can be pointed at a new
+// destination and reused, as gzip.Writer can.
+type resettableWriter interface {
+	io.WriteCloser
+	Reset(dst io.Writer)
+}
+
+// writerPools recycles compressing writers, which are costly to set up: a
+// gzip writer allocates hundreds of kilobytes of state. Pools are keyed by
+// encoding and level.
+var writerPools sync.Map // string -> *sync.Pool
+
+func (c *compressor) poolKey() string {
+	return c.encoding + "/" + strconv.Itoa(c.options.Level)
+}
+
+// newWriter returns a writer compressing everything written to it into dst,
+// reusing a pooled one if there is one. Closing it ends the compressed
+// stream but leaves dst open; it can then be handed back with release.
+func (c *compressor) newWriter(dst io.Writer) io.WriteCloser {
+	if pool, ok := writerPools.Load(c.poolKey()); ok {
+		if zw, ok := pool.(*sync.Pool).Get().(resettableWriter); ok {
+			zw.Reset(dst)
+			return zw
+		}
+	}
+	if encoder, ok := encoders[c.encoding]; ok {
+		return encoder(dst)
+	}
+	gz, _ := gzip.NewWriterLevel(dst, c.options.Level)
+	return gz
+}
+
+// release returns a closed writer from newWriter to its pool, if it can be
+// reused.
+func (c *compressor) release(zw io.WriteCloser) {
+	if rw, ok := zw.(resettableWriter); ok {
+		pool, _ := writerPools.LoadOrStore(c.poolKey(), &sync.Pool{})
+		pool.(*sync.Pool).Put(rw)
+	}
+}
+
+// compressBytes compresses body into dst in one go.
+func (c *compressor) compressBytes(dst *bytes.Buffer, body []byte) error {
+	zw := c.newWriter(dst)
+	if _, err := zw.Write(body); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	c.release(zw)
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// setFileValidators sets the ETag and Last-Modified headers of a response
+// serving the file described by info, for caches to revalidate against.
+func setFileValidators(w *ResponseWriter, info fs.FileInfo) {
+	w.Header().Set("ETag", fileETag(info))
+	if modTime := info.ModTime(); !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(dateFormat))
+	}
+}
+
+// notModified reports whether a GET or HEAD request's cached copy is still
+// current, judged against the ETag and Last-Modified headers already set on
+// the response. If-None-Match takes precedence over If-Modified-Since, and
+// compares entity tags weakly so that a cache's W/ tag still matches.
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/If-None-Match
+func notModified(w *ResponseWriter, request *HTTPRequest) bool {
+	if request.Method != MethodGet && request.Method != MethodHead {
+		return false
+	}
+	if ifNoneMatch, ok := request.Headers.lookup("If-None-Match"); ok {
+		etag, ok := w.Header().lookup("ETag")
+		if !ok {
+			return false
+		}
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ifModifiedSince, ok := request.Headers.lookup("If-Modified-Since")
+	if !ok {
+		return false
+	}
+	lastModified, ok := w.Header().lookup("Last-Modified")
+	if !ok {
+		return false
+	}
+	since, err := time.Parse(dateFormat, ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	modified, err := time.Parse(dateFormat, lastModified)
+	return err == nil && !modified.After(since)
+}
+
+// sendNotModified answers a conditional request whose cached copy is still
+// current. The response carries the validators and caching headers already
+// set, but neither a body nor anything describing one.
+func (s *Server) sendNotModified(w *ResponseWriter) {
+	for _, name := range []string{"Accept-Ranges", "Content-Encoding", "Content-Range", "Content-Disposition"} {
+		w.header.Del(name)
+	}
+	s.sendEmpty(w, StatusNotModified)
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigFile is a server configuration read from a file: the core Config
+// plus logging and the directories to serve statically.
+type ConfigFile struct {
+	Config
+	Log    LogOptions
+	Static []StaticDir
+
+	// logFormat is the log.format key as written, kept so Validate can
+	// report a bad value against it.
+	logFormat string
+}
+
+// StaticDir maps a URL prefix onto a directory on disk, served with
+// Server.Static.
+type StaticDir struct {
+	Prefix string
+	Dir    string
+	SPA    bool
+}
+
+// LoadConfig reads the configuration file at path. The file is written in
+// a subset of TOML:
+//
+//	addr = ":8080"
+//	files_dir = "/srv/files"
+//	file_versions = 3
+//
+//	[tls]
+//	cert_file = "/etc/nethttp/cert.pem"
+//	key_file = "/etc/nethttp/key.pem"
+//
+//	[timeouts]
+//	read_header = "5s"
+//	idle = "2m"
+//
+//	[limits]
+//	max_body_bytes = 10_485_760
+//	max_conns = 512
+//
+//	[log]
+//	format = "json"
+//	level = "info"
+//
+//	[log.levels]
+//	http2 = "debug"
+//
+//	[[static]]
+//	prefix = "/assets/"
+//	dir = "./public"
+//	spa = true
+//
+// Values are strings, integers or booleans, and durations are strings in
+// time.ParseDuration's syntax. Errors name the file, line and offending
+// key, and unknown keys, and keys set twice, are errors rather than being
+// ignored.
+func LoadConfig(path string) (*ConfigFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config, err := ParseConfig(file, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// ParseConfig parses a configuration in LoadConfig's format from r, using
+// name in error messages. It doesn't validate the result.
+func ParseConfig(r io.Reader, name string) (*ConfigFile, error) {
+	config := &ConfigFile{}
+	table := ""
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("%s:%d: %s", name, line, fmt.Sprintf(format, args...))
+		}
+
+		if strings.HasPrefix(text, "[[") {
+			header, ok := strings.CutSuffix(text[2:], "]]")
+			if !ok {
+				return nil, fail("unterminated table header")
+			}
+			header = strings.TrimSpace(header)
+			if header != "static" {
+				return nil, fail("unknown table %q", "[["+header+"]]")
+			}
+			config.Static = append(config.Static, StaticDir{})
+			table = fmt.Sprintf("static[%d]", len(config.Static)-1)
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			header, ok := strings.CutSuffix(text[1:], "]")
+			if !ok {
+				return nil, fail("unterminated table header")
+			}
+			table = strings.TrimSpace(header)
+			switch table {
+			case "tls", "timeouts", "limits", "log", "log.levels":
+			default:
+				return nil, fail("unknown table %q", "["+table+"]")
+			}
+			continue
+		}
+
+		key, raw, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fail("expected key = value")
+		}
+		key = strings.TrimSpace(key)
+		if !validConfigKey(key) {
+			return nil, fail("invalid key %q", key)
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		if seen[key] {
+			return nil, fail("%s: duplicate key", key)
+		}
+		seen[key] = true
+		value, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fail("%s: %v", key, err)
+		}
+		if err := config.set(key, value); err != nil {
+			return nil, fail("%s: %v", key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return config, nil
+}
+
+// validConfigKey reports whether key is a bare or dotted key as written in
+// the file, leaving the static[i] keys to [[static]] tables.
+func validConfigKey(key string) bool {
+	for _, part := range strings.Split(key, ".") {
+		if part == "" {
+			return false
+		}
+		for _, c := range part {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// set assigns value to the setting at the dotted key.
+func (c *ConfigFile) set(key string, value any) error {
+	if component, ok := strings.CutPrefix(key, "log.levels."); ok {
+		name, err := configString(value)
+		if err != nil {
+			return err
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return err
+		}
+		if c.Log.Levels == nil {
+			c.Log.Levels = make(map[string]slog.Level)
+		}
+		c.Log.Levels[component] = level
+		return nil
+	}
+	if strings.HasPrefix(key, "static[") {
+		if len(c.Static) == 0 {
+			return errors.New("unknown key")
+		}
+		dir := &c.Static[len(c.Static)-1]
+		switch key[strings.IndexByte(key, '.')+1:] {
+		case "prefix":
+			return setConfigString(&dir.Prefix, value)
+		case "dir":
+			return setConfigString(&dir.Dir, value)
+		case "spa":
+			return setConfigBool(&dir.SPA, value)
+		}
+		return errors.New("unknown key")
+	}
+
+	switch key {
+	case "addr":
+		return setConfigString(&c.Addr, value)
+	case "files_dir":
+		return setConfigString(&c.FilesDir, value)
+	case "file_versions":
+		return setConfigInt(&c.FileVersions, value)
+	case "tls.cert_file":
+		return setConfigString(&c.TLSCertFile, value)
+	case "tls.key_file":
+		return setConfigString(&c.TLSKeyFile, value)
+	case "timeouts.read_header":
+		return setConfigDuration(&c.Timeouts.ReadHeader, value)
+	case "timeouts.read":
+		return setConfigDuration(&c.Timeouts.Read, value)
+	case "timeouts.write":
+		return setConfigDuration(&c.Timeouts.Write, value)
+	case "timeouts.idle":
+		return setConfigDuration(&c.Timeouts.Idle, value)
+	case "timeouts.handler":
+		return setConfigDuration(&c.Timeouts.Handler, value)
+	case "timeouts.drain":
+		return setConfigDuration(&c.Timeouts.Drain, value)
+	case "limits.max_body_bytes":
+		return setConfigInt(&c.Limits.MaxBodyBytes, value)
+	case "limits.max_header_bytes":
+		return setConfigInt(&c.Limits.MaxHeaderBytes, value)
+	case "limits.max_header_count":
+		return setConfigInt(&c.Limits.MaxHeaderCount, value)
+	case "limits.max_conns":
+		return setConfigInt(&c.Limits.MaxConns, value)
+	case "log.format":
+		if err := setConfigString(&c.logFormat, value); err != nil {
+			return err
+		}
+		c.Log.JSON = c.logFormat == "json"
+		return nil
+	case "log.level":
+		name, err := configString(value)
+		if err != nil {
+			return err
+		}
+		return c.Log.Level.UnmarshalText([]byte(name))
+	}
+	return errors.New("unknown key")
+}
+
+// Validate checks the settings that parse but can't be served, naming the
+// offending key in the error.
+func (c *ConfigFile) Validate() error {
+	if c.FileVersions < 0 {
+		return errors.New("file_versions: must not be negative")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("tls: cert_file and key_file must be set together")
+	}
+	if c.logFormat != "" && c.logFormat != "text" && c.logFormat != "json" {
+		return fmt.Errorf("log.format: unknown format %q, want text or json", c.logFormat)
+	}
+	timeouts := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"read_header", c.Timeouts.ReadHeader},
+		{"read", c.Timeouts.Read},
+		{"write", c.Timeouts.Write},
+		{"idle", c.Timeouts.Idle},
+		{"handler", c.Timeouts.Handler},
+		{"drain", c.Timeouts.Drain},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			return fmt.Errorf("timeouts.%s: must not be negative", timeout.key)
+		}
+	}
+	limits := []struct {
+		key   string
+		value int64
+	}{
+		{"max_body_bytes", c.Limits.MaxBodyBytes},
+		{"max_header_bytes", int64(c.Limits.MaxHeaderBytes)},
+		{"max_header_count", int64(c.Limits.MaxHeaderCount)},
+		{"max_conns", int64(c.Limits.MaxConns)},
+	}
+	for _, limit := range limits {
+		if limit.value < 0 {
+			return fmt.Errorf("limits.%s: must not be negative", limit.key)
+		}
+	}
+	for i, static := range c.Static {
+		if !strings.HasPrefix(static.Prefix, "/") {
+			return fmt.Errorf("static[%d].prefix: must start with /", i)
+		}
+		if static.Dir == "" {
+			return fmt.Errorf("static[%d].dir: required", i)
+		}
+		info, err := os.Stat(static.Dir)
+		if err != nil {
+			return fmt.Errorf("static[%d].dir: %w", i, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("static[%d].dir: %s is not a directory", i, static.Dir)
+		}
+	}
+	return nil
+}
+
+// Options returns the options that build a server from the configuration,
+// serving its static directories too.
+func (c *ConfigFile) Options() []Option {
+	options := []Option{WithConfig(c.Config)}
+	for _, static := range c.Static {
+		options = append(options, func(s *Server) {
+			s.Static(static.Prefix, os.DirFS(static.Dir)).SPA = static.SPA
+		})
+	}
+	return options
+}
+
+// parseConfigValue parses the right-hand side of a key = value line into
+// a string, an int64 or a bool.
+func parseConfigValue(raw string) (any, error) {
+	switch {
+	case raw == "":
+		return nil, errors.New("missing value")
+	case raw == "true":
+		return true, nil
+	case raw == "false":
+		return false, nil
+	case raw[0] == '"':
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", raw)
+		}
+		return value, nil
+	case raw[0] == '\'':
+		if len(raw) < 2 || raw[len(raw)-1] != '\'' || strings.Contains(raw[1:len(raw)-1], "'") {
+			return nil, fmt.Errorf("invalid string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	}
+	value, err := strconv.ParseInt(raw, 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %s", raw)
+	}
+	return value, nil
+}
+
+// stripComment removes a # comment from line, leaving # signs inside
+// quoted strings alone.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func configString(value any) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("want a string, got %v", value)
+	}
+	return s, nil
+}
+
+func setConfigString(field *string, value any) error {
+	s, err := configString(value)
+	if err != nil {
+		return err
+	}
+	*field = s
+	return nil
+}
+
+func setConfigBool(field *bool, value any) error {
+	b, ok := value.(bool)
+	if !ok {
+		return fmt.Errorf("want true or false, got %v", value)
+	}
+	*field = b
+	return nil
+}
+
+func setConfigInt[T int | int64](field *T, value any) error {
+	n, ok := value.(int64)
+	if !ok {
+		return fmt.Errorf("want an integer, got %v", value)
+	}
+	*field = T(n)
+	return nil
+}
+
+func setConfigDuration(field *time.Duration, value any) error {
+	s, err := configString(value)
+	if err != nil {
+		return err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*field = d
+	return nil
+}
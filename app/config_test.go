@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	const file = `
+# The server.
+addr = ":8080"   # trailing comment
+files_dir = '/srv/#files'
+file_versions = 3
+
+[tls]
+cert_file = "cert.pem"
+key_file = "key.pem"
+
+[timeouts]
+read_header = "5s"
+idle = "2m"
+
+[limits]
+max_body_bytes = 10_485_760
+max_conns = 0x200
+
+[log]
+format = "json"
+level = "warn"
+
+[log.levels]
+http2 = "debug"
+
+[[static]]
+prefix = "/assets/"
+dir = "./public"
+spa = true
+
+[[static]]
+prefix = "/docs/"
+dir = "./docs"
+`
+	config, err := ParseConfig(strings.NewReader(file), "test.toml")
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"addr", config.Addr, ":8080"},
+		{"files_dir", config.FilesDir, "/srv/#files"},
+		{"file_versions", config.FileVersions, 3},
+		{"tls.cert_file", config.TLSCertFile, "cert.pem"},
+		{"tls.key_file", config.TLSKeyFile, "key.pem"},
+		{"timeouts.read_header", config.Timeouts.ReadHeader, 5 * time.Second},
+		{"timeouts.idle", config.Timeouts.Idle, 2 * time.Minute},
+		{"limits.max_body_bytes", config.Limits.MaxBodyBytes, int64(10 << 20)},
+		{"limits.max_conns", config.Limits.MaxConns, 512},
+		{"log.format", config.Log.JSON, true},
+		{"log.level", config.Log.Level, slog.LevelWarn},
+		{"log.levels.http2", config.Log.Levels["http2"], slog.LevelDebug},
+		{"static count", len(config.Static), 2},
+		{"static[0]", config.Static[0], StaticDir{Prefix: "/assets/", Dir: "./public", SPA: true}},
+		{"static[1]", config.Static[1], StaticDir{Prefix: "/docs/", Dir: "./docs"}},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s = %v, want %v", check.name, check.got, check.want)
+		}
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		err  string
+	}{
+		{name: "unknown key", file: "port = 80", err: "test.toml:1: port: unknown key"},
+		{name: "unknown table key", file: "[tls]\ncert = \"a\"", err: "test.toml:2: tls.cert: unknown key"},
+		{name: "unknown table", file: "[server]", err: `test.toml:1: unknown table "[server]"`},
+		{name: "unknown array table", file: "[[files]]", err: `test.toml:1: unknown table "[[files]]"`},
+		{name: "unterminated table", file: "[tls", err: "test.toml:1: unterminated table header"},
+		{name: "no equals sign", file: "addr", err: "test.toml:1: expected key = value"},
+		{name: "missing value", file: "addr =", err: "test.toml:1: addr: missing value"},
+		{name: "unterminated string", file: `addr = ":80`, err: "test.toml:1: addr: invalid string"},
+		{name: "wrong type", file: "addr = 80", err: "test.toml:1: addr: want a string"},
+		{name: "bad duration", file: "[timeouts]\nidle = \"soon\"", err: "test.toml:2: timeouts.idle:"},
+		{name: "bad level", file: "[log.levels]\nhttp2 = \"loud\"", err: "test.toml:2: log.levels.http2:"},
+		{name: "duplicate key", file: "addr = \":1\"\n\naddr = \":2\"", err: "test.toml:3: addr: duplicate key"},
+		{name: "duplicate key in a reopened table", file: "[tls]\ncert_file = \"a\"\n[tls]\ncert_file = \"b\"", err: "test.toml:4: tls.cert_file: duplicate key"},
+		{name: "static key outside a table", file: `static[0].dir = "x"`, err: `test.toml:1: invalid key "static[0].dir"`},
+		{name: "static key in another table", file: "[[static]]\ndir = \"a\"\n[tls]\nstatic[0].dir = \"b\"", err: `test.toml:4: invalid key "static[0].dir"`},
+		{name: "empty key part", file: "log..level = \"info\"", err: `test.toml:1: invalid key "log..level"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseConfig(strings.NewReader(tt.file), "test.toml")
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%s: ParseConfig = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestParseConfigStaticKeysPerTable(t *testing.T) {
+	// The same key in two [[static]] tables sets each table's own entry.
+	config, err := ParseConfig(strings.NewReader("[[static]]\ndir = \"a\"\n[[static]]\ndir = \"b\""), "test.toml")
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if len(config.Static) != 2 || config.Static[0].Dir != "a" || config.Static[1].Dir != "b" {
+		t.Errorf("Static = %+v, want dirs a and b", config.Static)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		config ConfigFile
+		err    string // empty when the configuration is valid
+	}{
+		{name: "empty", config: ConfigFile{}},
+		{name: "static dir", config: ConfigFile{Static: []StaticDir{{Prefix: "/a/", Dir: dir}}}},
+		{name: "negative versions", config: ConfigFile{Config: Config{FileVersions: -1}}, err: "file_versions"},
+		{name: "cert without key", config: ConfigFile{Config: Config{TLSCertFile: "cert.pem"}}, err: "tls"},
+		{name: "log format", config: ConfigFile{logFormat: "xml"}, err: "log.format"},
+		{name: "negative timeout", config: ConfigFile{Config: Config{Timeouts: Timeouts{Idle: -time.Second}}}, err: "timeouts.idle"},
+		{name: "static without dir", config: ConfigFile{Static: []StaticDir{{Prefix: "/a/"}}}, err: "static[0].dir"},
+		{name: "static dir missing", config: ConfigFile{Static: []StaticDir{{Prefix: "/a/", Dir: dir + "/missing"}}}, err: "static[0].dir"},
+	}
+	for _, tt := range tests {
+		err := tt.config.Validate()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: Validate: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+			t.Errorf("%s: Validate = %v, want an error about %s", tt.name, err, tt.err)
+		}
+	}
+}
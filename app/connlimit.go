@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// overloadLinger is how long a connection refused with 503 is kept open
+// to read what the client already sent, so that closing it doesn't reset
+// the connection before the response has been read.
+const overloadLinger = time.Second
+
+// maxOverloadRejections caps the connections being answered with 503 at
+// once; those accepted beyond it are closed without an answer.
+const maxOverloadRejections = 64
+
+// acquireConnSlot takes one of the MaxConns slots for a new connection. It
+// reports false when RejectOverload is set and every slot is in use;
+// otherwise it waits for a slot to free up, leaving further connections
+// in the listen backlog meanwhile. Without MaxConns it always succeeds.
+func (s *Server) acquireConnSlot() bool {
+	slots := s.connSlotChan()
+	if slots == nil {
+		return true
+	}
+	if !s.RejectOverload {
+		slots <- struct{}{}
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) releaseConnSlot() {
+	if slots := s.connSlotChan(); slots != nil {
+		<-slots
+	}
+}
+
+func (s *Server) connSlotChan() chan struct{} {
+	if s.MaxConns <= 0 {
+		return nil
+	}
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.connSlots == nil {
+		s.connSlots = make(chan struct{}, s.MaxConns)
+	}
+	return s.connSlots
+}
+
+// rejectOverload answers a connection accepted beyond MaxConns with 503
+// and closes it.
+func (s *Server) rejectOverload(conn net.Conn) {
+	defer s.rejecting.Add(-1)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(overloadLinger))
+	if _, ok := conn.(*net.TCPConn); !ok {
+		// Only plain TCP clients get an answer: a TLS one would have to
+		// complete its handshake first, which is more than an overloaded
+		// server should spend on it.
+		return
+	}
+
+	w := s.newResponseWriter(conn)
+	w.Header().Set("Retry-After", "1")
+	s.sendResponse(w, StatusServiceUnavailable, ContentTypePlainText, "")
+	conn.(*net.TCPConn).CloseWrite()
+	io.Copy(io.Discard, conn)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// disconnectWatcher cancels a request's context as soon as the client
+// closes its side of the connection. It is started once the request body
+// has been read in full, from which point a read can only block until the
+// client either sends its next request or hangs up.
+type disconnectWatcher struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	cancel context.CancelFunc
+
+	once sync.Once
+	done chan struct{}
+}
+
+func (d *disconnectWatcher) start() {
+	d.once.Do(func() {
+		d.done = make(chan struct{})
+		go func() {
+			defer close(d.done)
+			if _, err := d.reader.Peek(1); err != nil && !isTimeout(err) {
+				d.cancel()
+			}
+		}()
+	})
+}
+
+// stop stops the watcher, leaving anything it has buffered for the next
+// request, and keeps it from starting later. It must be called before the
+// reader is used again.
+func (d *disconnectWatcher) stop() {
+	d.once.Do(func() {})
+	if d.done != nil {
+		d.conn.SetReadDeadline(time.Now())
+		<-d.done
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// copyBufferSize is the chunk size used by copyContext.
+const copyBufferSize = 32 * 1024
+
+// sendfileChunkSize is how much copyContext hands to the kernel at a time
+// when it can copy a file to a connection with sendfile.
+const sendfileChunkSize = 4 << 20
+
+// copyContext copies src to dst like io.Copy, but checks ctx between chunks
+// so an abandoned request stops doing I/O promptly. A section of a file
+// copied to a ResponseWriter over plain TCP goes through ReadFrom, which
+// lets the kernel send it with sendfile rather than through a buffer.
+func copyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	if w, ok := dst.(*ResponseWriter); ok && w.canSendfile() {
+		if file, offset, size, ok := fileSection(src); ok {
+			return copyFileContext(ctx, w, file, offset, size)
+		}
+	}
+
+	buf := make([]byte, copyBufferSize)
+	var written int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			m, werr := dst.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// fileSection reports the file, offset and remaining length behind src, if
+// it is an io.SectionReader over an *os.File, possibly cut short by an
+// io.LimitedReader.
+func fileSection(src io.Reader) (*os.File, int64, int64, bool) {
+	limit := int64(-1)
+	if limited, ok := src.(*io.LimitedReader); ok {
+		src, limit = limited.R, limited.N
+	}
+	section, ok := src.(*io.SectionReader)
+	if !ok {
+		return nil, 0, 0, false
+	}
+	outer, base, n := section.Outer()
+	file, ok := outer.(*os.File)
+	if !ok {
+		return nil, 0, 0, false
+	}
+	pos, err := section.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, 0, false
+	}
+	size := n - pos
+	if limit >= 0 && limit < size {
+		size = limit
+	}
+	return file, base + pos, size, true
+}
+
+// copyFileContext copies size bytes of file from offset to w in
+// sendfileChunkSize pieces, checking ctx between them. It moves the file's
+// offset, which is fine for the file handles the server opens per request.
+func copyFileContext(ctx context.Context, w *ResponseWriter, file *os.File, offset, size int64) (int64, error) {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var written int64
+	for written < size {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		n, err := w.ReadFrom(io.LimitReader(file, min(sendfileChunkSize, size-written)))
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			// The file is shorter than it was.
+			return written, nil
+		}
+	}
+	return written, nil
+}
+
+// openRegularFile opens path for streaming to a client, refusing anything
+// but a regular file, such as a directory, as not found.
+func openRegularFile(path string) (*os.File, os.FileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = fs.ErrNotExist
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// uploadTempPrefix starts the names of the temporary files uploads are
+// written to before they are renamed into place.
+const uploadTempPrefix = ".upload-"
+
+// isUploadTemp reports whether name is an upload still being written.
+func isUploadTemp(name string) bool {
+	return strings.HasPrefix(name, uploadTempPrefix)
+}
+
+// writeTempFileContext writes everything read from src to a new temporary
+// file in dir, giving up as soon as ctx is done, and syncs it so that it
+// can be renamed into place. It returns the file's path and details; on
+// failure nothing is left behind.
+func writeTempFileContext(ctx context.Context, dir string, src io.Reader, perm os.FileMode) (string, os.FileInfo, error) {
+	file, err := os.CreateTemp(dir, uploadTempPrefix+"*")
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = copyContext(ctx, file, src)
+	if err == nil {
+		err = file.Chmod(perm)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = file.Stat()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", nil, err
+	}
+	return file.Name(), info, nil
+}
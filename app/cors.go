@@ -0,0 +1,146 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy lets browser pages from other origins call the server, as set
+// out by the Fetch standard's CORS protocol.
+// https://fetch.spec.whatwg.org/#http-cors-protocol
+type CORSPolicy struct {
+	// AllowedOrigins lists the origins allowed, e.g.
+	// "https://app.example.com". "*" allows any origin, and an entry with
+	// a "*" in it, like "https://*.example.com", any origin matching it.
+	// OriginPatterns allows origins matching a regular expression.
+	AllowedOrigins []string
+	OriginPatterns []*regexp.Regexp
+
+	// AllowedMethods are the methods preflight requests may ask for. Nil
+	// means GET, HEAD and POST.
+	AllowedMethods []HTTPMethod
+
+	// AllowedHeaders are the request headers preflight requests may ask
+	// for. Nil allows whichever they ask for.
+	AllowedHeaders []string
+
+	// ExposedHeaders are the response headers scripts may read beyond the
+	// safelisted ones.
+	ExposedHeaders []string
+
+	// AllowCredentials lets requests carry cookies and HTTP
+	// authentication. The allowed origin is then always named, as
+	// browsers don't accept "*" for credentialed requests.
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache a preflight response. Zero
+	// leaves it to the browser, which caches it briefly if at all.
+	MaxAge time.Duration
+}
+
+var defaultCORSMethods = []HTTPMethod{MethodGet, MethodHead, MethodPost}
+
+// allowOrigin reports whether origin is allowed and, if so, the value of
+// Access-Control-Allow-Origin for it.
+func (p *CORSPolicy) allowOrigin(origin string) (string, bool) {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			if p.AllowCredentials {
+				return origin, true
+			}
+			return "*", true
+		}
+		if prefix, suffix, wildcard := strings.Cut(allowed, "*"); wildcard {
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return origin, true
+			}
+		} else if strings.EqualFold(origin, allowed) {
+			return origin, true
+		}
+	}
+	for _, pattern := range p.OriginPatterns {
+		if pattern.MatchString(origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// applyCORS adds the CORS headers for a cross-origin request and answers
+// preflight requests itself. It runs before authentication, as browsers
+// send preflights without credentials. It reports whether the request
+// should go on to be dispatched; when it returns false a response has
+// already been sent.
+func (s *Server) applyCORS(w *ResponseWriter, request *HTTPRequest) bool {
+	policy := s.CORS
+	origin := request.Headers.Get("Origin")
+	if policy == nil || origin == "" {
+		return true
+	}
+	header := w.Header()
+	addVary(header, "Origin")
+	preflight := request.Method == MethodOptions && request.Headers.Has("Access-Control-Request-Method")
+	if preflight {
+		addVary(header, "Access-Control-Request-Method")
+		addVary(header, "Access-Control-Request-Headers")
+	}
+
+	allowOrigin, ok := policy.allowOrigin(origin)
+	if !ok {
+		if preflight {
+			s.sendResponse(w, StatusForbidden, ContentTypePlainText, "")
+			return false
+		}
+		// Served as usual, but without the headers the browser would
+		// need to let the page see the response.
+		return true
+	}
+	header.Set("Access-Control-Allow-Origin", allowOrigin)
+	if policy.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if len(policy.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+		}
+		return true
+	}
+
+	methods := policy.AllowedMethods
+	if methods == nil {
+		methods = defaultCORSMethods
+	}
+	method := HTTPMethod(strings.TrimSpace(request.Headers.Get("Access-Control-Request-Method")))
+	requested := splitList(request.Headers.Values("Access-Control-Request-Headers"))
+	allowedHeaders := requested
+	if policy.AllowedHeaders != nil {
+		allowedHeaders = policy.AllowedHeaders
+		for _, name := range requested {
+			if !slices.ContainsFunc(allowedHeaders, func(allowed string) bool { return strings.EqualFold(allowed, name) }) {
+				s.sendResponse(w, StatusForbidden, ContentTypePlainText, "")
+				return false
+			}
+		}
+	}
+	if !slices.Contains(methods, method) {
+		s.sendResponse(w, StatusForbidden, ContentTypePlainText, "")
+		return false
+	}
+
+	names := make([]string, len(methods))
+	for i, m := range methods {
+		names[i] = string(m)
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(names, ", "))
+	if len(allowedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(allowedHeaders, ", "))
+	}
+	if policy.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+	}
+	s.sendEmpty(w, StatusNoContent)
+	return false
+}
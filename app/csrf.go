@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSRFOptions configures CSRF. Zero values take the defaults noted.
+type CSRFOptions struct {
+	// CookieName is the cookie the token is kept in: "csrf_token".
+	CookieName string
+	// HeaderName is the request header scripts send the token back in:
+	// "X-CSRF-Token". FieldName is the form field forms send it back in:
+	// "csrf_token".
+	HeaderName string
+	FieldName  string
+	// MaxAge is how long the token cookie lasts: 12 hours.
+	MaxAge time.Duration
+	// Secure restricts the cookie to HTTPS; set it for sites served over
+	// TLS.
+	Secure bool
+}
+
+// csrfTokenBytes is how much randomness goes into a token.
+const csrfTokenBytes = 32
+
+type csrfKey struct{}
+
+// csrfToken is what CSRF leaves on a request's context for CSRFToken and
+// CSRFField.
+type csrfToken struct {
+	token     string
+	fieldName string
+}
+
+// CSRF protects against cross-site request forgery with the double-submit
+// cookie pattern. Every client is given a random token in a cookie, which
+// requests with unsafe methods (anything but GET, HEAD, OPTIONS and TRACE)
+// must echo back in a header or form field; another site can make a
+// browser send the cookie, but can't read it to fill in the copy. Requests
+// without a matching copy are refused with 403. Handlers embed the token
+// in their pages with CSRFField, or hand it to scripts with CSRFToken.
+//
+// There is no session store: the cookie itself is the state, so the token
+// lives as long as the cookie rather than a login.
+// https://cheatsheetseries.owasp.org/cheatsheets/Cross-Site_Request_Forgery_Prevention_Cheat_Sheet.html
+func CSRF(options CSRFOptions) Middleware {
+	if options.CookieName == "" {
+		options.CookieName = "csrf_token"
+	}
+	if options.HeaderName == "" {
+		options.HeaderName = "X-CSRF-Token"
+	}
+	if options.FieldName == "" {
+		options.FieldName = "csrf_token"
+	}
+	if options.MaxAge <= 0 {
+		options.MaxAge = 12 * time.Hour
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+			token, ok := request.Cookie(options.CookieName)
+			if !ok || !validCSRFToken(token) {
+				token = newCSRFToken()
+				w.Header().Add("Set-Cookie", options.cookie(token))
+			}
+
+			switch request.Method {
+			case MethodGet, MethodHead, MethodOptions, "TRACE":
+			default:
+				sent := request.Headers.Get(options.HeaderName)
+				if sent == "" {
+					sent = csrfFormValue(request, options.FieldName)
+				}
+				if !ok || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+					return &HTTPError{Code: StatusForbidden, Message: "missing or invalid CSRF token"}
+				}
+			}
+
+			request.SetContext(context.WithValue(request.Context(), csrfKey{}, csrfToken{token, options.FieldName}))
+			return next(w, request, params)
+		}
+	}
+}
+
+func (o *CSRFOptions) cookie(token string) string {
+	// The cookie can't be HttpOnly, as scripts have to read it to send it
+	// back in a header.
+	cookie := o.CookieName + "=" + token + "; Path=/; Max-Age=" + strconv.Itoa(int(o.MaxAge.Seconds())) + "; SameSite=Lax"
+	if o.Secure {
+		cookie += "; Secure"
+	}
+	return cookie
+}
+
+// csrfFormValue returns the token sent in a form body, urlencoded or
+// multipart.
+func csrfFormValue(request *HTTPRequest, fieldName string) string {
+	mediaType, _, _ := mime.ParseMediaType(request.Headers.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		return request.FormValue(fieldName)
+	case "multipart/form-data":
+		form, err := request.MultipartForm(defaultMultipartMemory)
+		if err != nil || len(form.Value[fieldName]) == 0 {
+			return ""
+		}
+		return form.Value[fieldName][0]
+	}
+	return ""
+}
+
+func newCSRFToken() string {
+	b := make([]byte, csrfTokenBytes)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func validCSRFToken(token string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(b) == csrfTokenBytes
+}
+
+// CSRFToken returns the CSRF token of a request that passed through CSRF,
+// or "" for one that didn't.
+func CSRFToken(request *HTTPRequest) string {
+	token, _ := request.Context().Value(csrfKey{}).(csrfToken)
+	return token.token
+}
+
+// CSRFField returns a hidden form input carrying the request's CSRF token,
+// for templates to place inside their forms.
+func CSRFField(request *HTTPRequest) template.HTML {
+	token, ok := request.Context().Value(csrfKey{}).(csrfToken)
+	if !ok {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(token.fieldName) +
+		`" value="` + token.token + `">`)
+}
+
+// Cookie returns the value of the named cookie sent with the request,
+// reporting false if there is none.
+func (r *HTTPRequest) Cookie(name string) (string, bool) {
+	for _, line := range r.Headers.Values("Cookie") {
+		for _, pair := range strings.Split(line, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && key == name {
+				return strings.Trim(value, `"`), true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Disposition says whether a browser should display a file or save it.
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Disposition
+type Disposition string
+
+const (
+	DispositionInline     Disposition = "inline"
+	DispositionAttachment Disposition = "attachment"
+)
+
+// contentDisposition builds a Content-Disposition value for filename. The
+// plain filename parameter carries an ASCII-only fallback for old clients,
+// and filename* carries the exact name encoded per RFC 5987.
+func contentDisposition(disposition Disposition, filename string) string {
+	var fallback strings.Builder
+	lossy := false
+	for _, r := range filename {
+		if r == '"' || r == '\\' || r < 0x20 || r > 0x7e {
+			fallback.WriteByte('_')
+			lossy = true
+		} else {
+			fallback.WriteRune(r)
+		}
+	}
+
+	value := string(disposition) + `; filename="` + fallback.String() + `"`
+	if lossy {
+		// url.PathEscape leaves a few sub-delims unescaped that RFC 5987
+		// attr-chars do not allow.
+		encoded := strings.NewReplacer("'", "%27", "(", "%28", ")", "%29", "*", "%2A", ",", "%2C", ";", "%3B", "=", "%3D", "@", "%40", ":", "%3A").
+			Replace(url.PathEscape(filename))
+		value += "; filename*=UTF-8''" + encoded
+	}
+	return value
+}
+
+// SendFile sends the file at path with a Content-Disposition header naming
+// it filename, or the file's base name when filename is empty. The content
+// type is derived from the name's extension and Range requests are honored.
+func (s *Server) SendFile(w *ResponseWriter, request *HTTPRequest, path string, disposition Disposition, filename string) {
+	if filename == "" {
+		filename = filepath.Base(path)
+	}
+
+	file, info, err := openRegularFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			s.sendResponse(w, StatusNotFound, ContentTypePlainText, "")
+		} else {
+			s.sendResponse(w, StatusInternalServerError, ContentTypePlainText, "")
+		}
+		return
+	}
+	defer file.Close()
+
+	setFileValidators(w, info)
+	contentType := s.contentTypeFor(filename, readHead(file))
+
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, filename))
+	s.ServeContent(w, request, contentType, file, info.Size())
+}
+
+// Attachment sends the file at path as a download under its own name.
+func (s *Server) Attachment(w *ResponseWriter, request *HTTPRequest, path string) {
+	s.SendFile(w, request, path, DispositionAttachment, "")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"slices"
+)
+
+// This file holds the pieces the brotli and Zstandard encoders share: a
+// bit writer, a builder of length-limited prefix codes and an LZ77 match
+// finder.
+
+// errWriterClosed is returned by compressing writers used after Close
+// until they are Reset.
+var errWriterClosed = errors.New("write to a closed compressing writer")
+
+// bitWriter packs values into bytes least significant bit first, the bit
+// order of both brotli and Zstandard streams.
+type bitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+// write appends the low n bits of value, n being at most 32.
+func (b *bitWriter) write(value uint64, n uint) {
+	b.bits |= (value & (1<<n - 1)) << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits >>= 8
+		b.nbits -= 8
+	}
+}
+
+// align pads the stream with zero bits to a byte boundary.
+func (b *bitWriter) align() {
+	if b.nbits > 0 {
+		b.write(0, 8-b.nbits)
+	}
+}
+
+func (b *bitWriter) reset() {
+	b.out = b.out[:0]
+	b.bits, b.nbits = 0, 0
+}
+
+// huffmanLengths returns the code lengths of a prefix code for symbols
+// occurring counts times, none longer than maxBits. Symbols that don't
+// occur get length 0; a lone symbol gets length 1. When a plain Huffman
+// code would be too deep, the rarest symbols are counted as more frequent
+// than they are until it fits, which keeps the code complete.
+func huffmanLengths(counts []int, maxBits int) []uint8 {
+	lengths := make([]uint8, len(counts))
+	var symbols []int
+	for symbol, count := range counts {
+		if count > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	switch len(symbols) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[symbols[0]] = 1
+		return lengths
+	}
+
+	type node struct {
+		weight int
+		parent int
+	}
+	nodes := make([]node, 0, 2*len(symbols))
+	for floor := 1; ; floor *= 2 {
+		nodes = nodes[:0]
+		for _, symbol := range symbols {
+			nodes = append(nodes, node{weight: max(counts[symbol], floor), parent: -1})
+		}
+		leaves := make([]int, len(symbols))
+		for i := range leaves {
+			leaves[i] = i
+		}
+		slices.SortStableFunc(leaves, func(a, b int) int { return nodes[a].weight - nodes[b].weight })
+
+		// The two-queue construction: leaves in order of weight, and the
+		// internal nodes, which are made in order of weight too.
+		next, internal := 0, len(nodes)
+		smallest := func() int {
+			if next < len(leaves) && (internal >= len(nodes) || nodes[leaves[next]].weight <= nodes[internal].weight) {
+				next++
+				return leaves[next-1]
+			}
+			internal++
+			return internal - 1
+		}
+		for i := 1; i < len(symbols); i++ {
+			a, b := smallest(), smallest()
+			nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+			nodes[a].parent = len(nodes) - 1
+			nodes[b].parent = len(nodes) - 1
+		}
+
+		depths := make([]int, len(nodes))
+		deepest := 0
+		for i := len(nodes) - 2; i >= 0; i-- {
+			depths[i] = depths[nodes[i].parent] + 1
+		}
+		for i := range symbols {
+			deepest = max(deepest, depths[i])
+		}
+		if deepest <= maxBits {
+			for i, symbol := range symbols {
+				lengths[symbol] = uint8(depths[i])
+			}
+			return lengths
+		}
+	}
+}
+
+// lzSequence is a run of literals followed by a copy of length bytes from
+// offset bytes back, as found by lzMatcher. The last sequence of a block
+// may be literals alone, with a length of 0.
+type lzSequence struct {
+	literals int
+	length   int
+	offset   int
+}
+
+const (
+	lzHashBits = 15
+	lzMinMatch = 4
+	lzMaxChain = 16
+	// lzGoodMatch is a match long enough to stop looking for a longer one.
+	lzGoodMatch = 256
+)
+
+// lzMatcher finds repeated strings in a block with hash chains over its
+// four-byte prefixes. Its tables are kept between blocks to be reused.
+type lzMatcher struct {
+	head []int32 // hash to the last position with it, plus one
+	prev []int32 // position to the previous one with the same hash, plus one
+}
+
+// find appends the sequences src breaks into to seqs. Copies only reach
+// back within src.
+func (m *lzMatcher) find(src []byte, seqs []lzSequence) []lzSequence {
+	if m.head == nil {
+		m.head = make([]int32, 1<<lzHashBits)
+	} else {
+		clear(m.head)
+	}
+	if cap(m.prev) < len(src) {
+		m.prev = make([]int32, len(src))
+	}
+	prev := m.prev[:len(src)]
+
+	insert := func(i int) {
+		h := lzHash(src[i:])
+		prev[i] = m.head[h]
+		m.head[h] = int32(i + 1)
+	}
+	literalStart := 0
+	for i := 0; i+lzMinMatch <= len(src); {
+		bestLength, bestOffset := 0, 0
+		candidate := int(m.head[lzHash(src[i:])]) - 1
+		for chain := 0; candidate >= 0 && chain < lzMaxChain; chain++ {
+			if n := commonPrefix(src[candidate:], src[i:]); n > bestLength {
+				bestLength, bestOffset = n, i-candidate
+				if n >= lzGoodMatch {
+					break
+				}
+			}
+			candidate = int(prev[candidate]) - 1
+		}
+		insert(i)
+		if bestLength < lzMinMatch {
+			i++
+			continue
+		}
+
+		seqs = append(seqs, lzSequence{literals: i - literalStart, length: bestLength, offset: bestOffset})
+		end := i + bestLength
+		for i++; i < end && i+lzMinMatch <= len(src); i++ {
+			insert(i)
+		}
+		i = end
+		literalStart = end
+	}
+	if literalStart < len(src) {
+		seqs = append(seqs, lzSequence{literals: len(src) - literalStart})
+	}
+	return seqs
+}
+
+func lzHash(b []byte) uint32 {
+	v := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+	return (v * 2654435761) >> (32 - lzHashBits)
+}
+
+// commonPrefix returns how many leading bytes a and b share.
+func commonPrefix(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestHuffmanLengths(t *testing.T) {
+	fibonacci := make([]int, 40)
+	fibonacci[0], fibonacci[1] = 1, 1
+	for i := 2; i < len(fibonacci); i++ {
+		fibonacci[i] = fibonacci[i-1] + fibonacci[i-2]
+	}
+	r := rand.New(rand.NewSource(1))
+	random := make([]int, 256)
+	for i := range random {
+		random[i] = r.Intn(1000)
+	}
+
+	tests := []struct {
+		name    string
+		counts  []int
+		maxBits int
+	}{
+		{name: "two symbols", counts: []int{0, 5, 0, 1}, maxBits: 15},
+		{name: "uniform", counts: []int{3, 3, 3, 3, 3, 3, 3, 3}, maxBits: 15},
+		{name: "random", counts: random, maxBits: 11},
+		{name: "fibonacci, limited", counts: fibonacci, maxBits: 11},
+		{name: "fibonacci, brotli limit", counts: fibonacci, maxBits: 15},
+	}
+	for _, tt := range tests {
+		lengths := huffmanLengths(tt.counts, tt.maxBits)
+		// The code must be complete: the codeword space sums to exactly 1.
+		kraft := 0
+		for symbol, length := range lengths {
+			if (length == 0) != (tt.counts[symbol] == 0) {
+				t.Errorf("%s: symbol %d with count %d has length %d", tt.name, symbol, tt.counts[symbol], length)
+			}
+			if int(length) > tt.maxBits {
+				t.Errorf("%s: symbol %d has length %d, beyond %d", tt.name, symbol, length, tt.maxBits)
+			}
+			if length > 0 {
+				kraft += 1 << (tt.maxBits - int(length))
+			}
+		}
+		if kraft != 1<<tt.maxBits {
+			t.Errorf("%s: Kraft sum %d/%d, want a complete code", tt.name, kraft, 1<<tt.maxBits)
+		}
+	}
+
+	if lengths := huffmanLengths([]int{0, 7, 0}, 15); lengths[1] != 1 {
+		t.Errorf("lone symbol has length %d, want 1", lengths[1])
+	}
+}
+
+func TestLZMatcher(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	var text bytes.Buffer
+	words := []string{"the", "server", "request", "response", "header", "body", "chunk"}
+	for text.Len() < 100000 {
+		text.WriteString(words[r.Intn(len(words))] + " ")
+	}
+	random := make([]byte, 5000)
+	r.Read(random)
+	inputs := map[string][]byte{
+		"empty":    nil,
+		"short":    []byte("abc"),
+		"repeated": bytes.Repeat([]byte("abcd"), 1000),
+		"overlap":  append([]byte("x"), bytes.Repeat([]byte("y"), 500)...),
+		"random":   random,
+		"text":     text.Bytes(),
+	}
+	var m lzMatcher
+	for name, src := range inputs {
+		seqs := m.find(src, nil)
+		// Replaying the sequences must give back the input.
+		var out []byte
+		pos := 0
+		for i, seq := range seqs {
+			out = append(out, src[pos:pos+seq.literals]...)
+			pos += seq.literals
+			if seq.length == 0 {
+				if i != len(seqs)-1 {
+					t.Errorf("%s: sequence %d of %d has no copy", name, i, len(seqs))
+				}
+				continue
+			}
+			if seq.length < lzMinMatch || seq.offset <= 0 || seq.offset > len(out) {
+				t.Fatalf("%s: sequence %d copies %d bytes from %d back, with %d out", name, i, seq.length, seq.offset, len(out))
+			}
+			for j := 0; j < seq.length; j++ {
+				out = append(out, out[len(out)-seq.offset])
+			}
+			pos += seq.length
+		}
+		if !bytes.Equal(out, src) {
+			t.Errorf("%s: replayed %d bytes, differing from the %d of the input", name, len(out), len(src))
+		}
+	}
+}
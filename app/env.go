@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// envPrefix starts the names of the environment variables that stand in
+// for flags, e.g. NETHTTP_LOG_LEVEL for -log-level.
+const envPrefix = "NETHTTP_"
+
+// envName returns the environment variable for the flag called name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets each flag of flags that wasn't given on the command line
+// from its environment variable, looked up with lookup. Settings are
+// taken in order of precedence from the command line, the environment, the
+// -config file and finally the defaults, so a container can be configured
+// through its environment while a flag still wins for a one-off run.
+func applyEnv(flags *flag.FlagSet, lookup func(string) (string, bool)) error {
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		value, ok := lookup(envName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s=%q: %w", envName(f.Name), value, setErr)
+		}
+	})
+	return err
+}
+
+// envUsage prints flag's usage followed by how the environment can set
+// the same flags.
+func envUsage(flags *flag.FlagSet) func() {
+	return func() {
+		output := flags.Output()
+		fmt.Fprintf(output, "Usage of %s:\n", flags.Name())
+		flags.PrintDefaults()
+		fmt.Fprintf(output, "\nEvery flag can also be set through the environment as %sNAME, e.g.\n", envPrefix)
+		fmt.Fprintf(output, "%s for -log-level. Command-line flags take precedence over the\n", envName("log-level"))
+		fmt.Fprintf(output, "environment, which takes precedence over the -config file.\n")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+)
+
+// HTTPError is an error a handler returns to answer with a given status.
+// Message, if any, is sent to the client as the plain-text body; Err is the
+// underlying cause and is only logged.
+type HTTPError struct {
+	Code    StatusCode
+	Message string
+	Err     error
+}
+
+func (e *HTTPError) Error() string {
+	switch {
+	case e.Err != nil && e.Message != "":
+		return e.Message + ": " + e.Err.Error()
+	case e.Err != nil:
+		return e.Err.Error()
+	case e.Message != "":
+		return e.Message
+	}
+	return e.Code.Text()
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorStatus maps an error returned by a handler onto the status and body
+// of its response: an HTTPError answers with its own code and message,
+// missing files with 404, permission errors with 403, bodies over their
+// size limit with 413, an expired HandlerTimeout with 503, and anything
+// else with an empty 500.
+func ErrorStatus(err error) (StatusCode, string) {
+	var httpErr *HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.Code, httpErr.Message
+	case errors.Is(err, errBodyTooLarge), errors.Is(err, errChunkedBodyTooLarge):
+		return StatusPayloadTooLarge, ""
+	case errors.Is(err, fs.ErrNotExist):
+		return StatusNotFound, ""
+	case errors.Is(err, fs.ErrPermission):
+		return StatusForbidden, ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return StatusServiceUnavailable, ""
+	}
+	return StatusInternalServerError, ""
+}
+
+// handleError answers a request whose handler returned err, through
+// ErrorRenderer if one is set. Errors after the response has started, and
+// those of clients that went away, can only be logged.
+func (s *Server) handleError(w *ResponseWriter, request *HTTPRequest, err error) {
+	if w.wroteHeader {
+		s.logger("http").Error("Error after the response started", requestLogArgs(request, "err", err)...)
+		return
+	}
+	if errors.Is(err, context.Canceled) && request.Context().Err() != nil {
+		s.logger("http").Debug("Client went away", requestLogArgs(request)...)
+		return
+	}
+	if s.ErrorRenderer != nil {
+		s.ErrorRenderer(w, request, err)
+		return
+	}
+	s.renderError(w, request, err)
+}
+
+// renderError is the default error renderer, answering with the status and
+// message given by ErrorStatus. Server errors are logged.
+func (s *Server) renderError(w *ResponseWriter, request *HTTPRequest, err error) {
+	status, message := ErrorStatus(err)
+	if status.Code() >= 500 {
+		s.logger("http").Error("Error serving request", requestLogArgs(request, "err", err)...)
+	}
+	s.sendResponse(w, status, ContentTypePlainText, message)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startFilesServer serves the default routes over a fresh files directory,
+// returning the server's base URL and the directory.
+func startFilesServer(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	s := NewServer(WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	s.FilesDir = dir
+	s.setupRoutes()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serve(listener)
+	t.Cleanup(func() { listener.Close() })
+	return "http://" + listener.Addr().String(), dir
+}
+
+// doRequest sends a request with the given headers and body, returning
+// the response with its body read.
+func doRequest(t *testing.T, method, url string, headers map[string]string, body string) (*http.Response, string) {
+	t.Helper()
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, string(content)
+}
+
+func TestPathLocker(t *testing.T) {
+	l := newPathLocker()
+	unlock := l.Lock("a")
+
+	// Another path isn't held up, and readers share.
+	unlockB := l.RLock("b")
+	unlockB2 := l.RLock("b")
+	unlockB()
+	unlockB2()
+
+	acquired := make(chan struct{})
+	go func() {
+		unlock := l.Lock("a")
+		close(acquired)
+		unlock()
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second Lock of a held path went through")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-acquired
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.locks) != 0 {
+		t.Errorf("%d locks left after release, want 0", len(l.locks))
+	}
+}
+
+func TestConcurrentUploads(t *testing.T) {
+	base, dir := startFilesServer(t)
+
+	// The writers all send a body of their own letter at once; the file
+	// must end up as one of them in full.
+	const writers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(letter byte) {
+			defer wg.Done()
+			body := bytes.Repeat([]byte{letter}, 1<<20)
+			request, err := http.NewRequest("PUT", base+"/files/shared.txt", bytes.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			response.Body.Close()
+			if response.StatusCode != 200 && response.StatusCode != 201 {
+				t.Errorf("PUT = %d", response.StatusCode)
+			}
+		}('a' + byte(i))
+	}
+	wg.Wait()
+
+	content, err := os.ReadFile(filepath.Join(dir, "shared.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content) != 1<<20 || !bytes.Equal(content, bytes.Repeat(content[:1], len(content))) {
+		t.Errorf("file is %d bytes mixing uploads, want 1 MiB of a single one", len(content))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "shared.txt" {
+			t.Errorf("%s left behind", entry.Name())
+		}
+	}
+}
+
+func TestWritePreconditions(t *testing.T) {
+	base, _ := startFilesServer(t)
+	url := base + "/files/doc.txt"
+
+	response, _ := doRequest(t, "PUT", url, map[string]string{"If-Match": "*"}, "v0")
+	if response.StatusCode != 412 {
+		t.Errorf("If-Match: * on a missing file = %d, want 412", response.StatusCode)
+	}
+	response, _ = doRequest(t, "PUT", url, map[string]string{"If-None-Match": "*"}, "v1")
+	if response.StatusCode != 201 {
+		t.Fatalf("create with If-None-Match: * = %d, want 201", response.StatusCode)
+	}
+	etag := response.Header.Get("ETag")
+	response, _ = doRequest(t, "PUT", url, map[string]string{"If-None-Match": "*"}, "v2")
+	if response.StatusCode != 412 {
+		t.Errorf("If-None-Match: * on an existing file = %d, want 412", response.StatusCode)
+	}
+
+	// Modification times are at least this fine on the filesystems the
+	// tests run on, so the next write gets a new ETag.
+	time.Sleep(10 * time.Millisecond)
+	response, _ = doRequest(t, "PUT", url, map[string]string{"If-Match": `"stale", ` + etag}, "v2")
+	if response.StatusCode != 200 {
+		t.Fatalf("PUT with the current ETag = %d, want 200", response.StatusCode)
+	}
+	if response.Header.Get("ETag") == etag {
+		t.Fatal("ETag unchanged by the write")
+	}
+	response, _ = doRequest(t, "PUT", url, map[string]string{"If-Match": etag}, "v3")
+	if response.StatusCode != 412 {
+		t.Errorf("PUT with a stale ETag = %d, want 412", response.StatusCode)
+	}
+	response, _ = doRequest(t, "DELETE", url, map[string]string{"If-Match": etag}, "")
+	if response.StatusCode != 412 {
+		t.Errorf("DELETE with a stale ETag = %d, want 412", response.StatusCode)
+	}
+	if _, body := doRequest(t, "GET", url, nil, ""); body != "v2" {
+		t.Errorf("file holds %q, want v2", body)
+	}
+}
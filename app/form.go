@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/url"
+)
+
+// defaultMaxFormBytes caps the body Form will read when neither the route
+// nor the server sets a body size limit.
+const defaultMaxFormBytes = 10 << 20
+
+// Form returns the request's form values: the fields of an
+// application/x-www-form-urlencoded body, followed by the query
+// parameters, so body values come first where a name is in both. Names and
+// values are decoded, "+" included. The body is read and parsed on the
+// first call only; bodies of other types are left alone and only the query
+// is returned. Failures are returned as an *HTTPError: 413 for a body that
+// is too large and 400 for one that doesn't parse.
+func (r *HTTPRequest) Form() (url.Values, error) {
+	if r.form != nil {
+		return r.form, nil
+	}
+	form := make(url.Values)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		limit := r.body.limit
+		if limit <= 0 {
+			limit = defaultMaxFormBytes
+		}
+		content, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+		if errors.Is(err, errBodyTooLarge) || errors.Is(err, errChunkedBodyTooLarge) || int64(len(content)) > limit {
+			return nil, &HTTPError{Code: StatusPayloadTooLarge, Err: errBodyTooLarge}
+		}
+		if err != nil {
+			return nil, &HTTPError{Code: StatusBadRequest, Message: "incomplete body", Err: err}
+		}
+		if form, err = url.ParseQuery(string(content)); err != nil {
+			return nil, &HTTPError{Code: StatusBadRequest, Message: "invalid form body: " + err.Error(), Err: err}
+		}
+	}
+
+	for name, values := range r.Query {
+		form[name] = append(form[name], values...)
+	}
+	r.form = form
+	return form, nil
+}
+
+// FormValue returns the first value of the named form field, or "" if
+// there is none or the form can't be parsed.
+func (r *HTTPRequest) FormValue(name string) string {
+	form, err := r.Form()
+	if err != nil {
+		return ""
+	}
+	return form.Get(name)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// RouteGroup registers routes under a shared path prefix and middleware
+// stack, e.g.
+//
+//	api := s.Group("/api/v1", authMiddleware)
+//	api.GET("/users/:id", handleUser)
+//
+// serves /api/v1/users/:id behind authMiddleware. Groups nest: a group's
+// own groups add to both its prefix and its middleware.
+type RouteGroup struct {
+	router     *router
+	prefix     string
+	middleware []Middleware
+}
+
+// Group returns a group of routes under prefix, wrapped in middleware
+// inside any installed with Use.
+func (r *router) Group(prefix string, middleware ...Middleware) *RouteGroup {
+	return &RouteGroup{router: r, prefix: strings.TrimSuffix(prefix, "/"), middleware: middleware}
+}
+
+// Group returns a group nested in g, under g's prefix followed by prefix
+// and wrapped in g's middleware followed by middleware.
+func (g *RouteGroup) Group(prefix string, middleware ...Middleware) *RouteGroup {
+	return &RouteGroup{
+		router:     g.router,
+		prefix:     g.prefix + strings.TrimSuffix(prefix, "/"),
+		middleware: slices.Concat(g.middleware, middleware),
+	}
+}
+
+// path returns the full pattern of a route registered on the group. An
+// empty path names the group's prefix itself.
+func (g *RouteGroup) path(path string) string {
+	if path == "" {
+		return g.prefix
+	}
+	return g.prefix + path
+}
+
+// HandleFunc registers handlerFunc for path under the group regardless of
+// the request method, as router.HandleFunc does. Any middleware given
+// wraps this route only, inside the group's.
+func (g *RouteGroup) HandleFunc(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return g.router.HandleFunc(g.path(path), handlerFunc, slices.Concat(g.middleware, middleware)...)
+}
+
+// Handle registers handlerFunc for requests to path under the group with
+// the given method, as router.Handle does.
+func (g *RouteGroup) Handle(method HTTPMethod, path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return g.router.Handle(method, g.path(path), handlerFunc, slices.Concat(g.middleware, middleware)...)
+}
+
+// LimitBody overrides Server.MaxBodyBytes for requests to path under the
+// group.
+func (g *RouteGroup) LimitBody(path string, n int64) {
+	g.router.LimitBody(g.path(path), n)
+}
+
+func (g *RouteGroup) GET(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return g.Handle(MethodGet, path, handlerFunc, middleware...)
+}
+
+func (g *RouteGroup) POST(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return g.Handle(MethodPost, path, handlerFunc, middleware...)
+}
+
+func (g *RouteGroup) PUT(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return g.Handle(MethodPut, path, handlerFunc, middleware...)
+}
+
+func (g *RouteGroup) DELETE(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return g.Handle(MethodDelete, path, handlerFunc, middleware...)
+}
+
+func (g *RouteGroup) PATCH(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return g.Handle(MethodPatch, path, handlerFunc, middleware...)
+}
+
+func (g *RouteGroup) HEAD(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return g.Handle(MethodHead, path, handlerFunc, middleware...)
+}
+
+func (g *RouteGroup) OPTIONS(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return g.Handle(MethodOptions, path, handlerFunc, middleware...)
+}
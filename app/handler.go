@@ -1,82 +1,239 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
-	"net"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
+	"syscall"
 )
 
-func (s *Server) handleIndex(conn net.Conn, _ *HTTPRequest, _ map[string]string) {
-	s.sendResponse(conn, "HTTP/1.1 200 OK", "text/plain", "", "", false)
+func (s *Server) handleIndex(w *ResponseWriter, _ *HTTPRequest, _ map[string]string) error {
+	s.sendResponse(w, "HTTP/1.1 200 OK", "text/plain", "")
+	return nil
 }
 
-func (s *Server) handleUserAgent(conn net.Conn, request *HTTPRequest, _ map[string]string) {
-	userAgent := request.Headers["User-Agent"]
-	s.sendResponse(conn, "HTTP/1.1 200 OK", "text/plain", userAgent, "", false)
+func (s *Server) handleUserAgent(w *ResponseWriter, request *HTTPRequest, _ map[string]string) error {
+	userAgent := request.Headers.Get("User-Agent")
+	s.sendResponse(w, "HTTP/1.1 200 OK", "text/plain", userAgent)
+	return nil
 }
 
-func (s *Server) handleEchoMessage(conn net.Conn, request *HTTPRequest, params map[string]string) {
+func (s *Server) handleEchoMessage(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
 	message := params["message"]
-	acceptEncoding := request.Headers["Accept-Encoding"]
-	encodings := strings.Split(acceptEncoding, ",")
-	gzipSupported := false
-
-	for _, encoding := range encodings {
-		if strings.TrimSpace(encoding) == "gzip" {
-			gzipSupported = true
-			break
-		}
-	}
-
-	if gzipSupported {
-		s.sendResponse(conn, "HTTP/1.1 200 OK", "text/plain", message, "gzip", true)
-	} else {
-		s.sendResponse(conn, "HTTP/1.1 200 OK", "text/plain", message, "", false)
-	}
+	s.sendResponse(w, "HTTP/1.1 200 OK", "text/plain", message)
+	return nil
 }
 
-func (s *Server) handleFiles(conn net.Conn, request *HTTPRequest, params map[string]string) {
+func (s *Server) handleFiles(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
 	method := request.Method
-	filename := params["filename"]
-	filePath := fmt.Sprintf("%s%s", directoryFlag, filename)
+	filename, filePath, ok := s.resolveFilePath(params["filepath"])
+	if !ok {
+		return &HTTPError{Code: StatusForbidden}
+	}
 
 	switch method {
 
-	case "GET":
-		log.Printf("Reading file: %s", filePath)
+	case "GET", "HEAD":
+		if isArchiveRequest(request) {
+			name := "files.zip"
+			if filename != "" {
+				name = path.Base(filename) + ".zip"
+			}
+			s.sendZip(request.Context(), w, filePath, name)
+			return nil
+		}
+		if request.Query.Has("versions") {
+			return s.sendFileVersions(w, filename)
+		}
+		if version := request.Query.Get("version"); version != "" {
+			s.sendFileVersion(w, request, filename, version)
+			return nil
+		}
+
+		if request.Method == MethodHead && request.Query.Has("upload") {
+			return s.sendUploadProgress(w, filePath)
+		}
+
+		if s.DirectoryListing {
+			if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+				return s.sendDirectoryListing(w, request, filename, filePath)
+			}
+		}
+
+		s.logger("files").Info("Reading file", "path", filePath)
+
+		if s.Mmap != nil && s.sendFileMapped(w, request, filePath) {
+			return nil
+		}
 
-		content, err := os.ReadFile(filePath)
+		// Uploads replace a file by renaming a new one over it, so once it
+		// is open the response streams a consistent copy without the lock.
+		unlock := s.fileLocks.RLock(filePath)
+		file, info, err := openRegularFile(filePath)
+		unlock()
 		if err != nil {
-			s.sendResponse(conn, "HTTP/1.1 404 Not Found", "text/plain", "", "", false)
-			return
+			return &HTTPError{Code: StatusNotFound, Err: err}
 		}
+		defer file.Close()
+		setFileValidators(w, info)
 
-		s.sendResponse(conn, "HTTP/1.1 200 OK", "application/octet-stream", string(content), "", false)
+		contentType := s.contentTypeFor(filename, readHead(file))
+		s.ServeContent(w, request, contentType, file, info.Size())
+		return nil
 
-	case "POST":
-		log.Printf("Writing file: %s", filePath)
+	case "POST", "PUT":
+		if request.Method == MethodPut && request.Headers.Has("Content-Range") {
+			return s.putFileRange(w, request, filename, filePath)
+		}
+		if request.Method == MethodPost && isMultipartForm(request) {
+			return s.saveFormFiles(w, request, filename, filePath)
+		}
+		s.logger("files").Info("Writing file", "path", filePath)
 
-		body := request.Body
-		log.Printf("Body: %s", body)
-		err := os.WriteFile(filePath, []byte(body), 0644)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("creating directories: %w", err)
+		}
+		// The body goes to a temporary file next to the target first, so
+		// a slow or abandoned upload never leaves a partial file behind and
+		// readers aren't held up while it arrives.
+		tempPath, info, err := writeTempFileContext(request.Context(), filepath.Dir(filePath), request.Body, 0644)
 		if err != nil {
-			log.Printf("Error writing file: %s", err)
-			s.sendResponse(conn, "HTTP/1.1 500 Internal Server Error", "text/plain", "", "", false)
-			return
+			return fmt.Errorf("writing file: %w", err)
 		}
+		defer os.Remove(tempPath)
 
-		writtenContent, err := os.ReadFile(filePath)
+		created, err := s.replaceFile(request, filename, filePath, tempPath)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("ETag", fileETag(info))
+		// A PUT tells a new file from a replaced one; POST always reports
+		// creating one, as clients of the original API expect.
+		status := StatusCreated
+		if request.Method == MethodPut && !created {
+			status = StatusOK
+		}
+		s.sendResponse(w, status, ContentTypePlainText, "")
+		return nil
+
+	case "DELETE":
+		s.logger("files").Info("Deleting file", "path", filePath)
+
+		unlock := s.fileLocks.Lock(filePath)
+		defer unlock()
+
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return &HTTPError{Code: StatusForbidden, Message: "directories can't be deleted"}
+		}
+		ok, err := checkWritePreconditions(request, filePath)
 		if err != nil {
-			log.Printf("Error reading back the written file: %s", err)
-			s.sendResponse(conn, "HTTP/1.1 500 Internal Server Error", "text/plain", "", "", false)
-			return
+			return fmt.Errorf("checking preconditions: %w", err)
+		}
+		if !ok {
+			return &HTTPError{Code: StatusPreconditionFailed}
 		}
 
-		s.sendResponse(conn, "HTTP/1.1 201 Created", "application/octet-stream", string(writtenContent), "", false)
+		if s.Mmap != nil {
+			s.Mmap.Invalidate(filePath)
+		}
+		// With versioning on, the deleted file becomes its last version
+		// rather than disappearing.
+		if s.FileVersions > 0 {
+			err = s.saveVersion(filePath, filename)
+		} else {
+			err = os.Remove(filePath)
+		}
+		if errors.Is(err, syscall.EROFS) {
+			return &HTTPError{Code: StatusForbidden, Message: "the files directory is read-only", Err: err}
+		}
+		if err != nil {
+			return fmt.Errorf("deleting file: %w", err)
+		}
+		s.sendEmpty(w, StatusNoContent)
+		return nil
 
 	default:
-		s.sendResponse(conn, "HTTP/1.1 405 Method Not Allowed", "text/plain", "", "", false)
+		return &HTTPError{Code: StatusMethodNotAllowed}
+	}
+}
+
+// replaceFile moves the finished upload at tempPath into place as filePath,
+// under the file's lock and provided the request's preconditions still
+// hold, keeping the file it replaces as a version. It reports whether the
+// file is new rather than a replacement.
+func (s *Server) replaceFile(request *HTTPRequest, filename, filePath, tempPath string) (bool, error) {
+	unlock := s.fileLocks.Lock(filePath)
+	defer unlock()
+
+	ok, err := checkWritePreconditions(request, filePath)
+	if err != nil {
+		return false, fmt.Errorf("checking preconditions: %w", err)
+	}
+	if !ok {
+		return false, &HTTPError{Code: StatusPreconditionFailed}
+	}
+
+	_, err = os.Stat(filePath)
+	created := errors.Is(err, fs.ErrNotExist)
+	if s.Mmap != nil {
+		s.Mmap.Invalidate(filePath)
+	}
+	if err := s.saveVersion(filePath, filename); err != nil {
+		return false, fmt.Errorf("saving previous version: %w", err)
+	}
+	if err := os.Rename(tempPath, filePath); err != nil {
+		return false, fmt.Errorf("replacing file: %w", err)
+	}
+	return created, nil
+}
+
+// sendFileMapped serves a files request from a memory mapping. The file's
+// read lock is only held while the mapping is acquired: uploads replace a
+// file by renaming a new one over it rather than truncating it, and the
+// reference taken on the mapping keeps it from being unmapped until the
+// response is written. It reports false when the file is too small to map
+// or can't be mapped, leaving the request to the regular read path.
+func (s *Server) sendFileMapped(w *ResponseWriter, request *HTTPRequest, filePath string) bool {
+	unlock := s.fileLocks.RLock(filePath)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		unlock()
+		return false
+	}
+	data, release, ok := s.Mmap.acquire(filePath, info)
+	unlock()
+	if !ok {
+		return false
+	}
+	defer release()
+
+	setFileValidators(w, info)
+	s.sendMapped(w, request, data, s.contentTypeFor(filePath, data))
+	return true
+}
+
+// resolveFilePath maps a slash-separated path from a /files URL onto the
+// files directory. It returns the cleaned relative name and the path on
+// disk, and reports false for names that would escape the directory or
+// reach into the version store or an upload in progress.
+func (s *Server) resolveFilePath(name string) (string, string, bool) {
+	cleaned, filePath, err := safeJoin(s.FilesDir, name)
+	if err != nil {
+		s.logger("files").Warn("Rejected file path", "name", name, "err", err)
+		return "", "", false
+	}
+	for _, part := range strings.Split(cleaned, "/") {
+		if part == versionsDirName || isUploadTemp(part) {
+			return "", "", false
+		}
 	}
+	return cleaned, filePath, true
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNestedFiles(t *testing.T) {
+	base, dir := startFilesServer(t)
+
+	response, _ := doRequest(t, "POST", base+"/files/a/b/c.txt", nil, "nested")
+	if response.StatusCode != 201 {
+		t.Fatalf("POST = %d, want 201", response.StatusCode)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "a", "b", "c.txt"))
+	if err != nil || string(content) != "nested" {
+		t.Fatalf("file on disk = %q, %v; want nested", content, err)
+	}
+	if response, body := doRequest(t, "GET", base+"/files/a/b/c.txt", nil, ""); response.StatusCode != 200 || body != "nested" {
+		t.Errorf("GET = %d %q, want 200 nested", response.StatusCode, body)
+	}
+	if response, body := doRequest(t, "GET", base+"/files/a/b/%63.txt", nil, ""); response.StatusCode != 200 || body != "nested" {
+		t.Errorf("GET percent-encoded = %d %q, want 200 nested", response.StatusCode, body)
+	}
+
+	for _, path := range []string{
+		"/files/a/../../escape.txt",
+		"/files/a/..%2f..%2fescape.txt",
+		"/files/a/%2e%2e/%2e%2e/escape.txt",
+		"/files/.versions/x",
+	} {
+		response, _ := doRequest(t, "POST", base+path, nil, "escaped")
+		if response.StatusCode < 400 {
+			t.Errorf("POST %s = %d, want it refused", path, response.StatusCode)
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.txt")); err == nil {
+			t.Fatalf("POST %s wrote outside the files directory", path)
+		}
+	}
+
+	if response, _ := doRequest(t, "DELETE", base+"/files/a/b/c.txt", nil, ""); response.StatusCode != 204 {
+		t.Errorf("DELETE = %d, want 204", response.StatusCode)
+	}
+	if response, _ := doRequest(t, "GET", base+"/files/a/b/c.txt", nil, ""); response.StatusCode != 404 {
+		t.Errorf("GET after DELETE = %d, want 404", response.StatusCode)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// Header holds the fields of a request or response head. Keys are stored
+// in canonical form, e.g. "User-Agent", so lookups ignore case, and a field
+// sent more than once keeps all of its values in order.
+type Header map[string][]string
+
+// Get returns the first value of key, or "" if there is none.
+func (h Header) Get(key string) string {
+	if values := h[textproto.CanonicalMIMEHeaderKey(key)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Values returns every value of key. The slice is owned by h.
+func (h Header) Values(key string) []string {
+	return h[textproto.CanonicalMIMEHeaderKey(key)]
+}
+
+// Has reports whether key is present, even if with an empty value.
+func (h Header) Has(key string) bool {
+	_, ok := h[textproto.CanonicalMIMEHeaderKey(key)]
+	return ok
+}
+
+// Add appends value to the values of key.
+func (h Header) Add(key, value string) {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Set replaces the values of key with value.
+func (h Header) Set(key, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(key)] = []string{value}
+}
+
+// Del removes key.
+func (h Header) Del(key string) {
+	delete(h, textproto.CanonicalMIMEHeaderKey(key))
+}
+
+// lookup returns the first value of key and whether it is present.
+func (h Header) lookup(key string) (string, bool) {
+	values, ok := h[textproto.CanonicalMIMEHeaderKey(key)]
+	if !ok || len(values) == 0 {
+		return "", ok
+	}
+	return values[0], true
+}
+
+// headerNewlines turns line breaks in values into spaces, so that a value
+// taken from a request, such as a redirect target, can't start a field of
+// its own.
+var headerNewlines = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// validFieldValue reports whether value may be carried in a header field.
+// CR, LF and NUL are refused rather than repaired, since a value holding
+// them could end its field early once passed on to another server.
+func validFieldValue(value string) bool {
+	return !strings.ContainsAny(value, "\r\n\x00")
+}
+
+// write formats the fields as header lines, sorted by key, one line per
+// value.
+func (h Header) write(b *strings.Builder) {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range h[key] {
+			b.WriteString(key)
+			b.WriteString(": ")
+			headerNewlines.WriteString(b, value)
+			b.WriteString("\r\n")
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReadinessCheck reports why the server cannot serve traffic right now, or
+// nil when the dependency it checks is fine.
+type ReadinessCheck func(ctx context.Context) error
+
+// LivenessCheck reports why the process is wedged and should be
+// restarted, or nil when it is fine. Liveness checks must only look at the
+// process itself: a failing dependency calls for a readiness check, as
+// restarting the server won't fix it.
+type LivenessCheck = ReadinessCheck
+
+// readinessTimeout bounds how long /readyz waits for all checks.
+const readinessTimeout = 5 * time.Second
+
+type checkResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// AddReadinessCheck registers a named check consulted by /readyz. A check
+// with the same name replaces the earlier one.
+func (s *Server) AddReadinessCheck(name string, check ReadinessCheck) {
+	s.readinessMu.Lock()
+	defer s.readinessMu.Unlock()
+	if s.readinessChecks == nil {
+		s.readinessChecks = make(map[string]ReadinessCheck)
+	}
+	s.readinessChecks[name] = check
+}
+
+// AddLivenessCheck registers a named check consulted by /healthz and
+// /livez. A check with the same name replaces the earlier one.
+func (s *Server) AddLivenessCheck(name string, check LivenessCheck) {
+	s.readinessMu.Lock()
+	defer s.readinessMu.Unlock()
+	if s.livenessChecks == nil {
+		s.livenessChecks = make(map[string]LivenessCheck)
+	}
+	s.livenessChecks[name] = check
+}
+
+// handleHealthz is the liveness probe: if the server can answer at all and
+// none of its liveness checks fail, the process is alive. It is served on
+// both /healthz and /livez.
+func (s *Server) handleHealthz(w *ResponseWriter, _ *HTTPRequest, _ map[string]string) error {
+	s.readinessMu.Lock()
+	checks := make(map[string]LivenessCheck, len(s.livenessChecks))
+	for name, check := range s.livenessChecks {
+		checks[name] = check
+	}
+	s.readinessMu.Unlock()
+
+	report := healthReport{Status: "ok"}
+	if len(checks) > 0 {
+		report = runChecks(checks)
+	}
+	return s.sendJSONReport(w, report)
+}
+
+// handleReadyz is the readiness probe. It runs every registered check
+// concurrently and answers 503 if any of them fails, so load balancers stop
+// routing traffic here during a drain or a dependency outage.
+func (s *Server) handleReadyz(w *ResponseWriter, _ *HTTPRequest, _ map[string]string) error {
+	checks := map[string]ReadinessCheck{
+		"shutdown": func(context.Context) error {
+			if s.shuttingDown.Load() {
+				return errors.New("shutdown in progress")
+			}
+			return nil
+		},
+	}
+	s.readinessMu.Lock()
+	for name, check := range s.readinessChecks {
+		checks[name] = check
+	}
+	s.readinessMu.Unlock()
+
+	return s.sendJSONReport(w, runChecks(checks))
+}
+
+// runChecks runs checks concurrently, giving them readinessTimeout in all,
+// and reports each one's result. The report's status is "unavailable" if
+// any of them failed.
+func runChecks(checks map[string]ReadinessCheck) healthReport {
+	ctx, cancel := context.WithTimeout(context.Background(), readinessTimeout)
+	defer cancel()
+
+	report := healthReport{Status: "ok", Checks: make(map[string]checkResult)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			result := checkResult{Status: "ok"}
+			if err := check(ctx); err != nil {
+				result = checkResult{Status: "fail", Error: err.Error()}
+			}
+			result.Duration = time.Since(start).Round(time.Microsecond).String()
+			mu.Lock()
+			report.Checks[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != "ok" {
+			report.Status = "unavailable"
+		}
+	}
+	return report
+}
+
+func (s *Server) sendJSONReport(w *ResponseWriter, report healthReport) error {
+	status := StatusOK
+	if report.Status != "ok" {
+		status = StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	return s.WriteJSON(w, status, report)
+}
+
+// DirWritableCheck verifies that files can be created in dir.
+func DirWritableCheck(dir string) ReadinessCheck {
+	return func(context.Context) error {
+		file, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return err
+		}
+		name := file.Name()
+		file.Close()
+		return os.Remove(name)
+	}
+}
+
+// TCPReachableCheck verifies that a TCP connection to addr can be opened.
+func TCPReachableCheck(addr string) ReadinessCheck {
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
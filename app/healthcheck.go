@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthCheck configures active health checking of a pool's upstreams: a
+// GET of Path on each upstream every Interval, which passes if it answers
+// with a 2xx or 3xx within Timeout. An upstream is taken out of rotation
+// after UnhealthyThreshold failed checks in a row and put back after
+// HealthyThreshold passed ones. Zero fields take the defaults below.
+type HealthCheck struct {
+	Path               string
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+	defaultHealthyThreshold    = 2
+	defaultUnhealthyThreshold  = 3
+)
+
+// CheckHealth probes the pool's upstreams in the background as check
+// describes, until ctx is done. Upstreams start out healthy, so traffic
+// flows before the first round of checks has finished.
+func (p *UpstreamPool) CheckHealth(ctx context.Context, check HealthCheck) {
+	if check.Path == "" {
+		check.Path = "/"
+	}
+	if check.Interval <= 0 {
+		check.Interval = defaultHealthCheckInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthCheckTimeout
+	}
+	if check.HealthyThreshold <= 0 {
+		check.HealthyThreshold = defaultHealthyThreshold
+	}
+	if check.UnhealthyThreshold <= 0 {
+		check.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+
+	go func() {
+		client := &Client{Timeout: check.Timeout}
+		ticker := time.NewTicker(check.Interval)
+		defer ticker.Stop()
+		for {
+			var wg sync.WaitGroup
+			for _, upstream := range p.upstreams {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					upstream.recordCheck(probeUpstream(client, upstream, check.Path), check)
+				}()
+			}
+			wg.Wait()
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// probeUpstream runs one health check against upstream, returning why it
+// failed or nil.
+func probeUpstream(client *Client, upstream *Upstream, path string) error {
+	target := strings.TrimSuffix(upstream.URL.String(), "/") + "/" + strings.TrimPrefix(path, "/")
+	response, err := client.Do(MethodGet, target, nil, nil)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("status %d %s", response.StatusCode, response.Status)
+	}
+	return nil
+}
+
+// recordCheck counts a health check result towards the upstream's
+// thresholds, flipping its health when one is reached. It is only called
+// from the checker goroutine, one check per upstream at a time.
+func (u *Upstream) recordCheck(err error, check HealthCheck) {
+	if err == nil {
+		u.failedChecks = 0
+		u.passedChecks++
+		if u.unhealthy.Load() && u.passedChecks >= check.HealthyThreshold {
+			u.unhealthy.Store(false)
+			defaultLogger("proxy").Info("Upstream is healthy again", "upstream", u.URL.String())
+		}
+		return
+	}
+	u.passedChecks = 0
+	u.failedChecks++
+	if !u.unhealthy.Load() && u.failedChecks >= check.UnhealthyThreshold {
+		u.unhealthy.Store(true)
+		defaultLogger("proxy").Warn("Upstream is unhealthy", "upstream", u.URL.String(), "err", err)
+	}
+}
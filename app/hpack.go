@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HPACK header compression for HTTP/2, as specified in RFC 7541.
+// https://www.rfc-editor.org/rfc/rfc7541
+
+var errHpack = errors.New("hpack: malformed header block")
+
+type hpackField struct {
+	name, value string
+}
+
+func (f hpackField) size() int {
+	return len(f.name) + len(f.value) + 32
+}
+
+// hpackDecoder decodes header blocks, keeping the dynamic table that the
+// peer's encoder builds up across blocks on the connection.
+type hpackDecoder struct {
+	dynamic []hpackField // newest first
+	size    int
+	// maxSize is the table size the peer's encoder is currently using,
+	// and limit the largest it may choose, our SETTINGS_HEADER_TABLE_SIZE.
+	maxSize int
+	limit   int
+	// maxListSize caps the decoded size of one header block.
+	maxListSize int
+}
+
+func newHpackDecoder(tableSize, maxListSize int) *hpackDecoder {
+	return &hpackDecoder{maxSize: tableSize, limit: tableSize, maxListSize: maxListSize}
+}
+
+// decode decodes a complete header block into its fields, in order.
+func (d *hpackDecoder) decode(block []byte) ([]hpackField, error) {
+	var fields []hpackField
+	listSize := 0
+	for len(block) > 0 {
+		b := block[0]
+		var field hpackField
+		var err error
+		switch {
+		case b&0x80 != 0:
+			// Indexed header field.
+			var index uint64
+			if index, block, err = hpackReadInt(block, 7); err != nil {
+				return nil, err
+			}
+			if field, err = d.field(index); err != nil {
+				return nil, err
+			}
+		case b&0xc0 == 0x40:
+			// Literal with incremental indexing.
+			if field, block, err = d.readLiteral(block, 6); err != nil {
+				return nil, err
+			}
+			d.add(field)
+		case b&0xe0 == 0x20:
+			// Dynamic table size update, only allowed before the fields.
+			if len(fields) > 0 {
+				return nil, errHpack
+			}
+			var size uint64
+			if size, block, err = hpackReadInt(block, 5); err != nil {
+				return nil, err
+			}
+			if size > uint64(d.limit) {
+				return nil, fmt.Errorf("hpack: table size %d beyond the limit of %d", size, d.limit)
+			}
+			d.maxSize = int(size)
+			d.evict()
+			continue
+		default:
+			// Literal without indexing, or never indexed.
+			if field, block, err = d.readLiteral(block, 4); err != nil {
+				return nil, err
+			}
+		}
+		listSize += field.size()
+		if d.maxListSize > 0 && listSize > d.maxListSize {
+			return nil, errHeaderListTooLarge
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// errHeaderListTooLarge is returned for a header block that decodes to more
+// than the advertised SETTINGS_MAX_HEADER_LIST_SIZE.
+var errHeaderListTooLarge = errors.New("hpack: header list too large")
+
+// field looks up an index into the static table followed by the dynamic.
+func (d *hpackDecoder) field(index uint64) (hpackField, error) {
+	switch {
+	case index == 0:
+		return hpackField{}, errHpack
+	case index <= uint64(len(hpackStaticTable)):
+		return hpackStaticTable[index-1], nil
+	case index-uint64(len(hpackStaticTable)) <= uint64(len(d.dynamic)):
+		return d.dynamic[index-uint64(len(hpackStaticTable))-1], nil
+	}
+	return hpackField{}, fmt.Errorf("hpack: index %d out of range", index)
+}
+
+// readLiteral reads a literal field whose name index has an n-bit prefix.
+func (d *hpackDecoder) readLiteral(block []byte, n uint8) (hpackField, []byte, error) {
+	index, block, err := hpackReadInt(block, n)
+	if err != nil {
+		return hpackField{}, nil, err
+	}
+	var field hpackField
+	if index > 0 {
+		named, err := d.field(index)
+		if err != nil {
+			return hpackField{}, nil, err
+		}
+		field.name = named.name
+	} else if field.name, block, err = hpackReadString(block); err != nil {
+		return hpackField{}, nil, err
+	}
+	if field.value, block, err = hpackReadString(block); err != nil {
+		return hpackField{}, nil, err
+	}
+	return field, block, nil
+}
+
+func (d *hpackDecoder) add(field hpackField) {
+	d.dynamic = append([]hpackField{field}, d.dynamic...)
+	d.size += field.size()
+	d.evict()
+}
+
+func (d *hpackDecoder) evict() {
+	for d.size > d.maxSize && len(d.dynamic) > 0 {
+		last := d.dynamic[len(d.dynamic)-1]
+		d.dynamic = d.dynamic[:len(d.dynamic)-1]
+		d.size -= last.size()
+	}
+}
+
+// hpackReadInt reads an integer with an n-bit prefix.
+func hpackReadInt(block []byte, n uint8) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, errHpack
+	}
+	mask := uint64(1)<<n - 1
+	value := uint64(block[0]) & mask
+	block = block[1:]
+	if value < mask {
+		return value, block, nil
+	}
+	for shift := uint(0); len(block) > 0; shift += 7 {
+		if shift > 56 {
+			return 0, nil, errHpack
+		}
+		b := block[0]
+		block = block[1:]
+		value += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value, block, nil
+		}
+	}
+	return 0, nil, errHpack
+}
+
+// hpackReadString reads a string literal, Huffman-coded or not.
+func hpackReadString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, errHpack
+	}
+	huffman := block[0]&0x80 != 0
+	length, block, err := hpackReadInt(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if length > uint64(len(block)) {
+		return "", nil, errHpack
+	}
+	raw, block := block[:length], block[length:]
+	if !huffman {
+		return string(raw), block, nil
+	}
+	s, err := huffmanDecode(raw)
+	return s, block, err
+}
+
+// hpackAppendInt appends value with an n-bit prefix, the prefix's other
+// bits set to flags.
+func hpackAppendInt(dst []byte, flags byte, n uint8, value uint64) []byte {
+	mask := uint64(1)<<n - 1
+	if value < mask {
+		return append(dst, flags|byte(value))
+	}
+	dst = append(dst, flags|byte(mask))
+	value -= mask
+	for value >= 0x80 {
+		dst = append(dst, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(dst, byte(value))
+}
+
+// hpackEncode encodes fields as a header block. It keeps no dynamic table,
+// so a block decodes the same whatever came before it: fields are either
+// exact static table matches or literals, never indexed, with the name
+// taken from the static table where it is there.
+func hpackEncode(dst []byte, fields []hpackField) []byte {
+	for _, field := range fields {
+		nameIndex := 0
+		for i, static := range hpackStaticTable {
+			if static.name != field.name {
+				continue
+			}
+			if static.value == field.value {
+				nameIndex = -(i + 1)
+				break
+			}
+			if nameIndex == 0 {
+				nameIndex = i + 1
+			}
+		}
+		if nameIndex < 0 {
+			dst = hpackAppendInt(dst, 0x80, 7, uint64(-nameIndex))
+			continue
+		}
+		dst = hpackAppendInt(dst, 0x00, 4, uint64(nameIndex))
+		if nameIndex == 0 {
+			dst = hpackAppendInt(dst, 0x00, 7, uint64(len(field.name)))
+			dst = append(dst, field.name...)
+		}
+		dst = hpackAppendInt(dst, 0x00, 7, uint64(len(field.value)))
+		dst = append(dst, field.value...)
+	}
+	return dst
+}
+
+// huffmanNode is a node of the Huffman decoding tree: a leaf holds a byte
+// value, an inner node its two children.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	leaf     bool
+	value    byte
+}
+
+var huffmanRoot = buildHuffmanTree()
+
+func buildHuffmanTree() *huffmanNode {
+	root := &huffmanNode{}
+	for value, code := range huffmanCodes {
+		node := root
+		for bit := int(huffmanCodeLens[value]) - 1; bit >= 0; bit-- {
+			b := code >> uint(bit) & 1
+			if node.children[b] == nil {
+				node.children[b] = &huffmanNode{}
+			}
+			node = node.children[b]
+		}
+		node.leaf, node.value = true, byte(value)
+	}
+	return root
+}
+
+// huffmanDecode decodes a Huffman-coded string. The padding after the last
+// code must be fewer than eight bits, all ones, as the spec requires.
+func huffmanDecode(raw []byte) (string, error) {
+	var b strings.Builder
+	node := huffmanRoot
+	depth, ones := 0, true
+	for _, octet := range raw {
+		for bit := 7; bit >= 0; bit-- {
+			one := octet>>uint(bit)&1 == 1
+			node = node.children[octet>>uint(bit)&1]
+			if node == nil {
+				return "", errHpack
+			}
+			depth++
+			ones = ones && one
+			if node.leaf {
+				b.WriteByte(node.value)
+				node, depth, ones = huffmanRoot, 0, true
+			}
+		}
+	}
+	if depth >= 8 || !ones {
+		return "", errHpack
+	}
+	return b.String(), nil
+}
+
+// hpackStaticTable is the static table of RFC 7541 Appendix A, indexed
+// from 1.
+var hpackStaticTable = [...]hpackField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// huffmanCodes and huffmanCodeLens are the Huffman code of RFC 7541
+// Appendix B, one code per byte value; the EOS symbol is only ever seen as
+// padding.
+var huffmanCodes = [256]uint32{
+	0x1ff8, 0x7fffd8, 0xfffffe2, 0xfffffe3, 0xfffffe4, 0xfffffe5, 0xfffffe6, 0xfffffe7,
+	0xfffffe8, 0xffffea, 0x3ffffffc, 0xfffffe9, 0xfffffea, 0x3ffffffd, 0xfffffeb, 0xfffffec,
+	0xfffffed, 0xfffffee, 0xfffffef, 0xffffff0, 0xffffff1, 0xffffff2, 0x3ffffffe, 0xffffff3,
+	0xffffff4, 0xffffff5, 0xffffff6, 0xffffff7, 0xffffff8, 0xffffff9, 0xffffffa, 0xffffffb,
+	0x14, 0x3f8, 0x3f9, 0xffa, 0x1ff9, 0x15, 0xf8, 0x7fa,
+	0x3fa, 0x3fb, 0xf9, 0x7fb, 0xfa, 0x16, 0x17, 0x18,
+	0x0, 0x1, 0x2, 0x19, 0x1a, 0x1b, 0x1c, 0x1d,
+	0x1e, 0x1f, 0x5c, 0xfb, 0x7ffc, 0x20, 0xffb, 0x3fc,
+	0x1ffa, 0x21, 0x5d, 0x5e, 0x5f, 0x60, 0x61, 0x62,
+	0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0x6a,
+	0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72,
+	0xfc, 0x73, 0xfd, 0x1ffb, 0x7fff0, 0x1ffc, 0x3ffc, 0x22,
+	0x7ffd, 0x3, 0x23, 0x4, 0x24, 0x5, 0x25, 0x26,
+	0x27, 0x6, 0x74, 0x75, 0x28, 0x29, 0x2a, 0x7,
+	0x2b, 0x76, 0x2c, 0x8, 0x9, 0x2d, 0x77, 0x78,
+	0x79, 0x7a, 0x7b, 0x7ffe, 0x7fc, 0x3ffd, 0x1ffd, 0xffffffc,
+	0xfffe6, 0x3fffd2, 0xfffe7, 0xfffe8, 0x3fffd3, 0x3fffd4, 0x3fffd5, 0x7fffd9,
+	0x3fffd6, 0x7fffda, 0x7fffdb, 0x7fffdc, 0x7fffdd, 0x7fffde, 0xffffeb, 0x7fffdf,
+	0xffffec, 0xffffed, 0x3fffd7, 0x7fffe0, 0xffffee, 0x7fffe1, 0x7fffe2, 0x7fffe3,
+	0x7fffe4, 0x1fffdc, 0x3fffd8, 0x7fffe5, 0x3fffd9, 0x7fffe6, 0x7fffe7, 0xffffef,
+	0x3fffda, 0x1fffdd, 0xfffe9, 0x3fffdb, 0x3fffdc, 0x7fffe8, 0x7fffe9, 0x1fffde,
+	0x7fffea, 0x3fffdd, 0x3fffde, 0xfffff0, 0x1fffdf, 0x3fffdf, 0x7fffeb, 0x7fffec,
+	0x1fffe0, 0x1fffe1, 0x3fffe0, 0x1fffe2, 0x7fffed, 0x3fffe1, 0x7fffee, 0x7fffef,
+	0xfffea, 0x3fffe2, 0x3fffe3, 0x3fffe4, 0x7ffff0, 0x3fffe5, 0x3fffe6, 0x7ffff1,
+	0x3ffffe0, 0x3ffffe1, 0xfffeb, 0x7fff1, 0x3fffe7, 0x7ffff2, 0x3fffe8, 0x1ffffec,
+	0x3ffffe2, 0x3ffffe3, 0x3ffffe4, 0x7ffffde, 0x7ffffdf, 0x3ffffe5, 0xfffff1, 0x1ffffed,
+	0x7fff2, 0x1fffe3, 0x3ffffe6, 0x7ffffe0, 0x7ffffe1, 0x3ffffe7, 0x7ffffe2, 0xfffff2,
+	0x1fffe4, 0x1fffe5, 0x3ffffe8, 0x3ffffe9, 0xffffffd, 0x7ffffe3, 0x7ffffe4, 0x7ffffe5,
+	0xfffec, 0xfffff3, 0xfffed, 0x1fffe6, 0x3fffe9, 0x1fffe7, 0x1fffe8, 0x7ffff3,
+	0x3fffea, 0x3fffeb, 0x1ffffee, 0x1ffffef, 0xfffff4, 0xfffff5, 0x3ffffea, 0x7ffff4,
+	0x3ffffeb, 0x7ffffe6, 0x3ffffec, 0x3ffffed, 0x7ffffe7, 0x7ffffe8, 0x7ffffe9, 0x7ffffea,
+	0x7ffffeb, 0xffffffe, 0x7ffffec, 0x7ffffed, 0x7ffffee, 0x7ffffef, 0x7fffff0, 0x3ffffee,
+}
+
+var huffmanCodeLens = [256]uint8{
+	13, 23, 28, 28, 28, 28, 28, 28, 28, 24, 30, 28, 28, 30, 28, 28,
+	28, 28, 28, 28, 28, 28, 30, 28, 28, 28, 28, 28, 28, 28, 28, 28,
+	6, 10, 10, 12, 13, 6, 8, 11, 10, 10, 8, 11, 8, 6, 6, 6,
+	5, 5, 5, 6, 6, 6, 6, 6, 6, 6, 7, 8, 15, 6, 12, 10,
+	13, 6, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7, 7,
+	7, 7, 7, 7, 7, 7, 7, 7, 8, 7, 8, 13, 19, 13, 14, 6,
+	15, 5, 6, 5, 6, 5, 6, 6, 6, 5, 7, 7, 6, 6, 6, 5,
+	6, 7, 6, 5, 5, 6, 7, 7, 7, 7, 7, 15, 11, 14, 13, 28,
+	20, 22, 20, 20, 22, 22, 22, 23, 22, 23, 23, 23, 23, 23, 24, 23,
+	24, 24, 22, 23, 24, 23, 23, 23, 23, 21, 22, 23, 22, 23, 23, 24,
+	22, 21, 20, 22, 22, 23, 23, 21, 23, 22, 22, 24, 21, 22, 23, 23,
+	21, 21, 22, 21, 23, 22, 23, 23, 20, 22, 22, 22, 23, 22, 22, 23,
+	26, 26, 20, 19, 22, 23, 22, 25, 26, 26, 26, 27, 27, 26, 24, 25,
+	19, 21, 26, 27, 27, 26, 27, 24, 21, 21, 26, 26, 28, 27, 27, 27,
+	20, 24, 20, 21, 22, 21, 21, 23, 22, 22, 25, 25, 24, 24, 26, 23,
+	26, 27, 26, 26, 27, 27, 27, 27, 27, 28, 27, 27, 27, 27, 27, 26,
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The request and response examples of RFC 7541, appendix C, each a
+// sequence of blocks decoded in turn by one decoder.
+var hpackExamples = []struct {
+	name      string
+	tableSize int
+	blocks    []string
+	want      [][]hpackField
+	size      []int // of the dynamic table after each block
+}{
+	{
+		name:      "C.3 requests without Huffman coding",
+		tableSize: 4096,
+		blocks: []string{
+			"828684410f7777772e6578616d706c652e636f6d",
+			"828684be58086e6f2d6361636865",
+			"828785bf400a637573746f6d2d6b65790c637573746f6d2d76616c7565",
+		},
+		want: hpackExampleRequests,
+		size: []int{57, 110, 164},
+	},
+	{
+		name:      "C.4 requests with Huffman coding",
+		tableSize: 4096,
+		blocks: []string{
+			"828684418cf1e3c2e5f23a6ba0ab90f4ff",
+			"828684be5886a8eb10649cbf",
+			"828785bf408825a849e95ba97d7f8925a849e95bb8e8b4bf",
+		},
+		want: hpackExampleRequests,
+		size: []int{57, 110, 164},
+	},
+	{
+		name:      "C.5 responses evicting entries",
+		tableSize: 256,
+		blocks: []string{
+			"4803333032580770726976617465611d4d6f6e2c203231204f637420323031332032303a31333a323120474d546e1768747470733a2f2f7777772e6578616d706c652e636f6d",
+			"4803333037c1c0bf",
+		},
+		want: [][]hpackField{
+			{
+				{":status", "302"},
+				{"cache-control", "private"},
+				{"date", "Mon, 21 Oct 2013 20:13:21 GMT"},
+				{"location", "https://www.example.com"},
+			},
+			{
+				{":status", "307"},
+				{"cache-control", "private"},
+				{"date", "Mon, 21 Oct 2013 20:13:21 GMT"},
+				{"location", "https://www.example.com"},
+			},
+		},
+		size: []int{222, 222},
+	},
+}
+
+var hpackExampleRequests = [][]hpackField{
+	{
+		{":method", "GET"},
+		{":scheme", "http"},
+		{":path", "/"},
+		{":authority", "www.example.com"},
+	},
+	{
+		{":method", "GET"},
+		{":scheme", "http"},
+		{":path", "/"},
+		{":authority", "www.example.com"},
+		{"cache-control", "no-cache"},
+	},
+	{
+		{":method", "GET"},
+		{":scheme", "https"},
+		{":path", "/index.html"},
+		{":authority", "www.example.com"},
+		{"custom-key", "custom-value"},
+	},
+}
+
+func TestHpackDecoderExamples(t *testing.T) {
+	for _, tt := range hpackExamples {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newHpackDecoder(tt.tableSize, 0)
+			for i, block := range tt.blocks {
+				raw, err := hex.DecodeString(block)
+				if err != nil {
+					t.Fatal(err)
+				}
+				fields, err := d.decode(raw)
+				if err != nil {
+					t.Fatalf("block %d: %v", i+1, err)
+				}
+				if !reflect.DeepEqual(fields, tt.want[i]) {
+					t.Errorf("block %d = %q, want %q", i+1, fields, tt.want[i])
+				}
+				if d.size != tt.size[i] {
+					t.Errorf("table size after block %d = %d, want %d", i+1, d.size, tt.size[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHpackDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		block       string
+		maxListSize int
+	}{
+		{name: "index 0", block: "80"},
+		{name: "index beyond the tables", block: "be"},
+		{name: "literal name index beyond the tables", block: "7f0001" + "61"},
+		{name: "truncated integer", block: "ff"},
+		{name: "integer too large", block: "ffffffffffffffffffffff01"},
+		{name: "truncated string", block: "0003" + "6162"},
+		{name: "truncated value", block: "40016103"},
+		{name: "table size update after a field", block: "82" + "20"},
+		{name: "table size beyond the limit", block: "3fe21f"},
+		{name: "invalid Huffman padding", block: "0081" + "00" + "00"},
+		{name: "header list too large", block: strings.Repeat("82", 3), maxListSize: 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := hex.DecodeString(tt.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fields, err := newHpackDecoder(4096, tt.maxListSize).decode(raw); err == nil {
+				t.Errorf("decode = %q, want an error", fields)
+			}
+		})
+	}
+}
+
+func TestHpackEncodeRoundTrip(t *testing.T) {
+	fields := []hpackField{
+		{":status", "200"},
+		{":status", "418"},
+		{"content-type", "text/plain"},
+		{"x-custom", "value"},
+		{"x-long", strings.Repeat("v", 300)},
+	}
+	got, err := newHpackDecoder(4096, 0).decode(hpackEncode(nil, fields))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(got, fields) {
+		t.Errorf("decode = %q, want %q", got, fields)
+	}
+}
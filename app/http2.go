@@ -0,0 +1,1098 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// HTTP/2 support, as specified in RFC 9113. Connections speak HTTP/2 when
+// the client opens with the connection preface ("prior knowledge"), asks
+// to upgrade an HTTP/1.1 request with "Upgrade: h2c", or negotiates h2
+// during the TLS handshake. Every stream is served as a request of its
+// own through the same route table, with proto "HTTP/2.0": the handler's
+// response is written in HTTP/1.1 form as always and translated into
+// HEADERS and DATA frames on its way out.
+// https://www.rfc-editor.org/rfc/rfc9113
+
+// http2Preface is what a client sends first on an HTTP/2 connection.
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	http2FrameData         = 0x0
+	http2FrameHeaders      = 0x1
+	http2FramePriority     = 0x2
+	http2FrameRSTStream    = 0x3
+	http2FrameSettings     = 0x4
+	http2FramePushPromise  = 0x5
+	http2FramePing         = 0x6
+	http2FrameGoAway       = 0x7
+	http2FrameWindowUpdate = 0x8
+	http2FrameContinuation = 0x9
+
+	http2FlagEndStream  = 0x1
+	http2FlagAck        = 0x1
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+
+	http2SettingHeaderTableSize      = 0x1
+	http2SettingEnablePush           = 0x2
+	http2SettingMaxConcurrentStreams = 0x3
+	http2SettingInitialWindowSize    = 0x4
+	http2SettingMaxFrameSize         = 0x5
+	http2SettingMaxHeaderListSize    = 0x6
+)
+
+// http2ErrCode is an error code carried by RST_STREAM and GOAWAY frames.
+type http2ErrCode uint32
+
+const (
+	http2NoError          http2ErrCode = 0x0
+	http2ProtocolError    http2ErrCode = 0x1
+	http2InternalError    http2ErrCode = 0x2
+	http2FlowControlError http2ErrCode = 0x3
+	http2StreamClosed     http2ErrCode = 0x5
+	http2FrameSizeError   http2ErrCode = 0x6
+	http2RefusedStream    http2ErrCode = 0x7
+	http2Cancel           http2ErrCode = 0x8
+	http2CompressionError http2ErrCode = 0x9
+	http2EnhanceYourCalm  http2ErrCode = 0xb
+	http2HTTP11Required   http2ErrCode = 0xd
+)
+
+const (
+	http2DefaultWindowSize   = 65535
+	http2DefaultMaxFrameSize = 16384
+	http2MaxWindowSize       = 1<<31 - 1
+)
+
+const (
+	// http2MaxConcurrentStreams is how many streams a client may have open
+	// at once.
+	http2MaxConcurrentStreams = 250
+	// http2StreamWindow is the receive window advertised for each stream,
+	// which bounds how much of a request body is buffered ahead of the
+	// handler reading it.
+	http2StreamWindow = 1 << 20
+	// http2HeaderTableSize is the HPACK dynamic table size clients may use.
+	http2HeaderTableSize = 4096
+)
+
+// http2ConnError is a connection error: the connection is ended with a
+// GOAWAY carrying code.
+type http2ConnError struct {
+	code   http2ErrCode
+	reason string
+}
+
+func (e *http2ConnError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.code, e.reason)
+}
+
+var errHTTP2StreamReset = errors.New("http2: stream reset")
+
+// isHTTP2Preface reports whether the client has opened the connection
+// with the HTTP/2 preface. Only a request line starting with "PRI" is
+// read ahead any further, so an HTTP/1.1 request is never waited on.
+func isHTTP2Preface(reader *bufio.Reader) bool {
+	if start, err := reader.Peek(3); err != nil || string(start) != "PRI" {
+		return false
+	}
+	preface, err := reader.Peek(len(http2Preface))
+	return err == nil && string(preface) == http2Preface
+}
+
+// wantsH2CUpgrade reports whether request asks to switch the connection to
+// HTTP/2. Only requests without a body are upgraded, as the body would
+// have to be read in full before the switch.
+func wantsH2CUpgrade(request *HTTPRequest) bool {
+	if request.Proto != "HTTP/1.1" || request.TLS != nil || request.body.err != io.EOF {
+		return false
+	}
+	connection := request.Headers.Get("Connection")
+	return headerHasToken(request.Headers.Get("Upgrade"), "h2c") &&
+		headerHasToken(connection, "upgrade") && headerHasToken(connection, "http2-settings") &&
+		len(request.Headers.Values("HTTP2-Settings")) == 1
+}
+
+// http2Conn is one HTTP/2 connection.
+type http2Conn struct {
+	server  *Server
+	conn    net.Conn
+	reader  *bufio.Reader
+	decoder *hpackDecoder
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	writeMu sync.Mutex
+
+	// mu guards everything below; cond is signalled whenever a send
+	// window grows or a stream is reset, for writers waiting on flow
+	// control.
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*http2Stream
+	lastStreamID      uint32
+	sendWindow        int64
+	peerInitialWindow int64
+	peerMaxFrameSize  int
+	closed            bool
+	goAwaySent        bool
+	wg                sync.WaitGroup
+}
+
+// http2Stream is one request and its response on an HTTP/2 connection.
+type http2Stream struct {
+	id     uint32
+	conn   *http2Conn
+	cancel context.CancelFunc
+	body   http2Body
+	// Guarded by conn.mu.
+	sendWindow   int64
+	recvWindow   int64
+	reset        bool
+	remoteClosed bool
+}
+
+// serveHTTP2 speaks HTTP/2 on conn until either side ends the connection.
+// upgraded is the HTTP/1.1 request an h2c upgrade was made on, which
+// becomes stream 1, or nil.
+func (s *Server) serveHTTP2(conn net.Conn, reader *bufio.Reader, upgraded *HTTPRequest) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &http2Conn{
+		server:            s,
+		conn:              conn,
+		reader:            reader,
+		decoder:           newHpackDecoder(http2HeaderTableSize, s.maxHeaderBytes()),
+		ctx:               ctx,
+		cancel:            cancel,
+		streams:           make(map[uint32]*http2Stream),
+		sendWindow:        http2DefaultWindowSize,
+		peerInitialWindow: http2DefaultWindowSize,
+		peerMaxFrameSize:  http2DefaultMaxFrameSize,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	defer c.shutdown()
+
+	if upgraded != nil {
+		settings, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(upgraded.Headers.Get("HTTP2-Settings"), "="))
+		if err == nil {
+			err = c.applySettings(settings)
+		}
+		if err != nil {
+			s.logger("http2").Warn("Invalid HTTP2-Settings", "err", err)
+			releaseRequest(upgraded)
+			return
+		}
+		if _, err := io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"); err != nil {
+			releaseRequest(upgraded)
+			return
+		}
+	}
+
+	c.writeFrame(http2FrameSettings, 0, 0, http2SettingsPayload(
+		http2SettingMaxConcurrentStreams, http2MaxConcurrentStreams,
+		http2SettingInitialWindowSize, http2StreamWindow,
+		http2SettingMaxHeaderListSize, uint32(s.maxHeaderBytes()),
+	))
+	if upgraded != nil {
+		// The upgraded request is stream 1, already half-closed by the
+		// client as it had no body.
+		upgraded.Proto = "HTTP/2.0"
+		c.mu.Lock()
+		c.lastStreamID = 1
+		c.mu.Unlock()
+		c.startStream(1, upgraded, true)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(c.idleTimeout()))
+	preface := make([]byte, len(http2Preface))
+	if _, err := io.ReadFull(reader, preface); err != nil || string(preface) != http2Preface {
+		return
+	}
+
+	err := c.readFrames()
+	var connErr *http2ConnError
+	if errors.As(err, &connErr) {
+		s.logger("http2").Warn("Connection error", "err", err)
+		c.goAway(connErr.code)
+	} else if err != nil && !isIdleClose(err) && !errors.Is(err, syscall.ECONNRESET) {
+		// Clients tend to reset a connection they are done with rather
+		// than close it, so that isn't worth logging.
+		s.logger("http2").Warn("Failed to read frame", "err", err)
+	}
+}
+
+func (c *http2Conn) idleTimeout() time.Duration {
+	if c.server.IdleTimeout > 0 {
+		return c.server.IdleTimeout
+	}
+	return defaultIdleTimeout
+}
+
+// shutdown cancels whatever is still in flight once the connection is
+// done and waits for its handlers to return.
+func (c *http2Conn) shutdown() {
+	c.mu.Lock()
+	c.closed = true
+	for _, stream := range c.streams {
+		stream.reset = true
+		stream.cancel()
+		stream.body.closeWithError(errHTTP2StreamReset)
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.cancel()
+	c.conn.Close()
+	c.wg.Wait()
+}
+
+// readFrames reads and handles frames until the connection fails or the
+// client closes it. The first frame must be the client's SETTINGS.
+func (c *http2Conn) readFrames() error {
+	var header [9]byte
+	var headerBlock []byte
+	var headerStream uint32
+	var headerFlags byte
+	first := true
+
+	for {
+		c.mu.Lock()
+		idle := len(c.streams) == 0
+		c.mu.Unlock()
+		c.server.setConnIdle(c.conn, idle && headerBlock == nil)
+		if idle {
+			if c.server.shuttingDown.Load() {
+				c.goAway(http2NoError)
+				return nil
+			}
+			c.conn.SetReadDeadline(time.Now().Add(c.idleTimeout()))
+		} else {
+			c.conn.SetReadDeadline(deadline(time.Now(), c.server.ReadTimeout))
+		}
+
+		if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+			return err
+		}
+		length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+		frameType, flags := header[3], header[4]
+		streamID := binary.BigEndian.Uint32(header[5:]) & 0x7fffffff
+		if length > http2DefaultMaxFrameSize {
+			return &http2ConnError{http2FrameSizeError, "frame too large"}
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return err
+		}
+
+		if first && frameType != http2FrameSettings {
+			return &http2ConnError{http2ProtocolError, "connection must start with SETTINGS"}
+		}
+		first = false
+		if headerBlock != nil && (frameType != http2FrameContinuation || streamID != headerStream) {
+			return &http2ConnError{http2ProtocolError, "expected CONTINUATION"}
+		}
+
+		switch frameType {
+		case http2FrameData:
+			if err := c.handleData(streamID, flags, payload); err != nil {
+				return err
+			}
+
+		case http2FrameHeaders:
+			if streamID == 0 {
+				return &http2ConnError{http2ProtocolError, "HEADERS on stream 0"}
+			}
+			fragment, err := http2StripPadding(flags, payload)
+			if err != nil {
+				return err
+			}
+			if flags&http2FlagPriority != 0 {
+				if len(fragment) < 5 {
+					return &http2ConnError{http2FrameSizeError, "short HEADERS priority"}
+				}
+				fragment = fragment[5:]
+			}
+			headerBlock = append([]byte{}, fragment...)
+			headerStream, headerFlags = streamID, flags
+			if flags&http2FlagEndHeaders != 0 {
+				err := c.handleHeaders(headerStream, headerFlags, headerBlock)
+				headerBlock = nil
+				if err != nil {
+					return err
+				}
+			}
+
+		case http2FrameContinuation:
+			if headerBlock == nil {
+				return &http2ConnError{http2ProtocolError, "unexpected CONTINUATION"}
+			}
+			headerBlock = append(headerBlock, payload...)
+			if len(headerBlock) > c.server.maxHeaderBytes() {
+				return &http2ConnError{http2EnhanceYourCalm, "header block too large"}
+			}
+			if flags&http2FlagEndHeaders != 0 {
+				err := c.handleHeaders(headerStream, headerFlags, headerBlock)
+				headerBlock = nil
+				if err != nil {
+					return err
+				}
+			}
+
+		case http2FramePriority:
+			if streamID == 0 {
+				return &http2ConnError{http2ProtocolError, "PRIORITY on stream 0"}
+			}
+			if length != 5 {
+				c.resetStream(streamID, http2FrameSizeError)
+			}
+
+		case http2FrameRSTStream:
+			if streamID == 0 || length != 4 {
+				return &http2ConnError{http2ProtocolError, "malformed RST_STREAM"}
+			}
+			c.mu.Lock()
+			if stream := c.streams[streamID]; stream != nil {
+				stream.reset = true
+				stream.cancel()
+				stream.body.closeWithError(errHTTP2StreamReset)
+				c.cond.Broadcast()
+			}
+			c.mu.Unlock()
+
+		case http2FrameSettings:
+			if streamID != 0 {
+				return &http2ConnError{http2ProtocolError, "SETTINGS on a stream"}
+			}
+			if flags&http2FlagAck != 0 {
+				if length != 0 {
+					return &http2ConnError{http2FrameSizeError, "SETTINGS ACK with a payload"}
+				}
+				continue
+			}
+			if err := c.applySettings(payload); err != nil {
+				return err
+			}
+			c.writeFrame(http2FrameSettings, http2FlagAck, 0, nil)
+
+		case http2FramePushPromise:
+			return &http2ConnError{http2ProtocolError, "clients can't push"}
+
+		case http2FramePing:
+			if streamID != 0 || length != 8 {
+				return &http2ConnError{http2ProtocolError, "malformed PING"}
+			}
+			if flags&http2FlagAck == 0 {
+				c.writeFrame(http2FramePing, http2FlagAck, 0, payload)
+			}
+
+		case http2FrameGoAway:
+			// The client won't open further streams; those open run to
+			// completion, after which it closes the connection.
+
+		case http2FrameWindowUpdate:
+			if length != 4 {
+				return &http2ConnError{http2FrameSizeError, "malformed WINDOW_UPDATE"}
+			}
+			if err := c.handleWindowUpdate(streamID, int64(binary.BigEndian.Uint32(payload)&0x7fffffff)); err != nil {
+				return err
+			}
+
+		default:
+			// Unknown frame types are ignored.
+		}
+	}
+}
+
+// http2StripPadding removes the padding of a DATA or HEADERS frame.
+func http2StripPadding(flags byte, payload []byte) ([]byte, error) {
+	if flags&http2FlagPadded == 0 {
+		return payload, nil
+	}
+	if len(payload) == 0 || int(payload[0]) >= len(payload) {
+		return nil, &http2ConnError{http2ProtocolError, "invalid padding"}
+	}
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
+
+// applySettings applies a SETTINGS payload from the client.
+func (c *http2Conn) applySettings(payload []byte) error {
+	if len(payload)%6 != 0 {
+		return &http2ConnError{http2FrameSizeError, "malformed SETTINGS"}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ; len(payload) > 0; payload = payload[6:] {
+		id := binary.BigEndian.Uint16(payload)
+		value := binary.BigEndian.Uint32(payload[2:])
+		switch id {
+		case http2SettingEnablePush:
+			if value > 1 {
+				return &http2ConnError{http2ProtocolError, "invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case http2SettingInitialWindowSize:
+			if value > http2MaxWindowSize {
+				return &http2ConnError{http2FlowControlError, "invalid SETTINGS_INITIAL_WINDOW_SIZE"}
+			}
+			delta := int64(value) - c.peerInitialWindow
+			c.peerInitialWindow = int64(value)
+			for _, stream := range c.streams {
+				stream.sendWindow += delta
+			}
+			c.cond.Broadcast()
+		case http2SettingMaxFrameSize:
+			if value < http2DefaultMaxFrameSize || value > 1<<24-1 {
+				return &http2ConnError{http2ProtocolError, "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+			c.peerMaxFrameSize = int(value)
+		}
+		// The header table size doesn't matter to an encoder that never
+		// indexes; the other settings only constrain the client.
+	}
+	return nil
+}
+
+func http2SettingsPayload(pairs ...uint32) []byte {
+	payload := make([]byte, 0, len(pairs)*3)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(pairs[i]))
+		payload = binary.BigEndian.AppendUint32(payload, pairs[i+1])
+	}
+	return payload
+}
+
+func (c *http2Conn) handleWindowUpdate(streamID uint32, increment int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if streamID == 0 {
+		if increment == 0 {
+			return &http2ConnError{http2ProtocolError, "zero WINDOW_UPDATE"}
+		}
+		if c.sendWindow += increment; c.sendWindow > http2MaxWindowSize {
+			return &http2ConnError{http2FlowControlError, "connection window overflow"}
+		}
+		c.cond.Broadcast()
+		return nil
+	}
+	stream := c.streams[streamID]
+	if stream == nil {
+		return nil
+	}
+	if increment == 0 || stream.sendWindow+increment > http2MaxWindowSize {
+		stream.reset = true
+		stream.cancel()
+		stream.body.closeWithError(errHTTP2StreamReset)
+		go c.resetStream(streamID, http2FlowControlError)
+	}
+	stream.sendWindow += increment
+	c.cond.Broadcast()
+	return nil
+}
+
+// handleHeaders opens a stream for a complete header block, or takes it as
+// the trailers of a stream already open.
+func (c *http2Conn) handleHeaders(streamID uint32, flags byte, block []byte) error {
+	fields, err := c.decoder.decode(block)
+	// The decoder state survives a list that is too large, so only the
+	// stream fails.
+	regular := 0
+	for _, field := range fields {
+		if !strings.HasPrefix(field.name, ":") {
+			regular++
+		}
+	}
+	tooLarge := errors.Is(err, errHeaderListTooLarge) || regular > c.server.maxHeaderCount()
+	if err != nil && !tooLarge {
+		return &http2ConnError{http2CompressionError, err.Error()}
+	}
+	endStream := flags&http2FlagEndStream != 0
+
+	c.mu.Lock()
+	stream := c.streams[streamID]
+	c.mu.Unlock()
+	if stream != nil {
+		// Trailers, which must end the stream. They aren't passed on.
+		if !endStream {
+			return &http2ConnError{http2ProtocolError, "trailers without END_STREAM"}
+		}
+		if tooLarge {
+			c.mu.Lock()
+			stream.reset = true
+			c.cond.Broadcast()
+			c.mu.Unlock()
+			stream.cancel()
+			stream.body.closeWithError(errHTTP2StreamReset)
+			c.resetStream(streamID, http2Cancel)
+			return nil
+		}
+		c.closeRemote(stream)
+		return nil
+	}
+	c.mu.Lock()
+	if streamID%2 == 0 || streamID <= c.lastStreamID {
+		c.mu.Unlock()
+		return &http2ConnError{http2ProtocolError, "invalid stream ID"}
+	}
+	c.lastStreamID = streamID
+	refused := len(c.streams) >= http2MaxConcurrentStreams || c.goAwaySent || c.server.shuttingDown.Load()
+	c.mu.Unlock()
+	if refused {
+		c.resetStream(streamID, http2RefusedStream)
+		return nil
+	}
+	if tooLarge {
+		block := hpackEncode(nil, []hpackField{{":status", strconv.Itoa(StatusRequestHeaderFieldsTooLarge.Code())}})
+		c.writeHeaders(streamID, block, true)
+		if !endStream {
+			c.resetStream(streamID, http2NoError)
+		}
+		return nil
+	}
+
+	request, err := c.server.http2Request(fields, c.conn)
+	if err != nil {
+		c.server.logger("http2").Warn("Malformed request", "stream", streamID, "err", err)
+		c.resetStream(streamID, http2ProtocolError)
+		return nil
+	}
+	c.startStream(streamID, request, endStream)
+	return nil
+}
+
+// http2Request builds a request from the fields of a HEADERS block.
+func (s *Server) http2Request(fields []hpackField, conn net.Conn) (*HTTPRequest, error) {
+	var method, target, authority, scheme string
+	request := acquireRequest()
+	var cookies []string
+	regular := false
+	for _, field := range fields {
+		// The fields may be forwarded as HTTP/1.1 lines, where a CR or
+		// LF would start a header of its own (RFC 9113, section 8.2.1).
+		if !validFieldValue(field.value) {
+			releaseRequest(request)
+			return nil, fmt.Errorf("invalid value for header %s", field.name)
+		}
+		if strings.HasPrefix(field.name, ":") {
+			if regular {
+				releaseRequest(request)
+				return nil, fmt.Errorf("pseudo-header %s after regular headers", field.name)
+			}
+			switch field.name {
+			case ":method":
+				method = field.value
+			case ":path":
+				target = field.value
+			case ":authority":
+				authority = field.value
+			case ":scheme":
+				scheme = field.value
+			default:
+				releaseRequest(request)
+				return nil, fmt.Errorf("unknown pseudo-header %s", field.name)
+			}
+			continue
+		}
+		regular = true
+		if !isToken(field.name) || field.name != strings.ToLower(field.name) {
+			releaseRequest(request)
+			return nil, fmt.Errorf("invalid header name %q", field.name)
+		}
+		switch field.name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			releaseRequest(request)
+			return nil, fmt.Errorf("connection-specific header %s", field.name)
+		case "te":
+			if field.value != "trailers" {
+				releaseRequest(request)
+				return nil, fmt.Errorf("invalid te: %q", field.value)
+			}
+		case "cookie":
+			// Cookies may be split across fields to compress better.
+			cookies = append(cookies, field.value)
+			continue
+		}
+		request.Headers.Add(field.name, field.value)
+	}
+	if method == "" || scheme == "" || !strings.HasPrefix(target, "/") && !(method == "OPTIONS" && target == "*") {
+		releaseRequest(request)
+		return nil, errors.New("missing pseudo-headers")
+	}
+	if len(cookies) > 0 {
+		request.Headers.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	if authority != "" && !request.Headers.Has("Host") {
+		request.Headers.Set("Host", authority)
+	}
+
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		releaseRequest(request)
+		return nil, err
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		releaseRequest(request)
+		return nil, err
+	}
+	request.Method = HTTPMethod(method)
+	request.Path = path
+	request.RawPath = rawPath
+	request.RequestURI = target
+	request.Proto = "HTTP/2.0"
+	request.Query = query
+	return request, nil
+}
+
+// startStream registers a stream for request and serves it in a goroutine
+// of its own. endStream is set when the request has no body.
+func (c *http2Conn) startStream(id uint32, request *HTTPRequest, endStream bool) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	stream := &http2Stream{
+		id:           id,
+		conn:         c,
+		cancel:       cancel,
+		recvWindow:   http2StreamWindow,
+		remoteClosed: endStream,
+	}
+	stream.body.stream = stream
+	stream.body.cond = sync.NewCond(&stream.body.mu)
+	if endStream {
+		stream.body.err = io.EOF
+	}
+
+	request.Body = &request.body
+	request.body = requestBody{r: &stream.body, length: -1}
+	if contentLength, ok := request.Headers.lookup("Content-Length"); ok {
+		if n, err := strconv.ParseInt(contentLength, 10, 64); err == nil && n >= 0 {
+			request.body = requestBody{r: &lengthReader{r: &stream.body, left: n}, length: n}
+		}
+	}
+	if endStream {
+		request.body.length, request.body.err = 0, io.EOF
+	}
+
+	c.mu.Lock()
+	stream.sendWindow = c.peerInitialWindow
+	c.streams[id] = stream
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer cancel()
+		out := &http2ResponseConn{stream: stream, head: &bytes.Buffer{}}
+		if tlsConn, ok := c.conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			request.TLS = &state
+		}
+		request.SetContext(ctx)
+		if err := c.server.decodeBody(request); err != nil {
+			var reqErr *requestError
+			if errors.As(err, &reqErr) {
+				c.server.rejectRequest(out, reqErr)
+			}
+		} else {
+			c.server.serveRequest(out, nil, request, requestTiming{})
+		}
+		releaseRequest(request)
+		out.finish()
+	}()
+}
+
+// handleData passes the payload of a DATA frame to its stream's body.
+func (c *http2Conn) handleData(streamID uint32, flags byte, payload []byte) error {
+	if streamID == 0 {
+		return &http2ConnError{http2ProtocolError, "DATA on stream 0"}
+	}
+	// The connection window is given back straight away: the streams'
+	// windows are what hold a client back.
+	if len(payload) > 0 {
+		c.writeWindowUpdate(0, len(payload))
+	}
+	data, err := http2StripPadding(flags, payload)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	stream := c.streams[streamID]
+	if stream == nil || stream.remoteClosed {
+		idle := streamID > c.lastStreamID
+		c.mu.Unlock()
+		if idle {
+			return &http2ConnError{http2ProtocolError, "DATA on an idle stream"}
+		}
+		c.resetStream(streamID, http2StreamClosed)
+		return nil
+	}
+	stream.recvWindow -= int64(len(payload))
+	overflow := stream.recvWindow < 0
+	c.mu.Unlock()
+	if overflow {
+		c.resetStream(streamID, http2FlowControlError)
+		return nil
+	}
+	if padding := len(payload) - len(data); padding > 0 {
+		c.giveBack(stream, padding)
+	}
+
+	stream.body.push(data)
+	if flags&http2FlagEndStream != 0 {
+		c.closeRemote(stream)
+	}
+	return nil
+}
+
+// closeRemote records that the client has finished sending on stream.
+func (c *http2Conn) closeRemote(stream *http2Stream) {
+	c.mu.Lock()
+	stream.remoteClosed = true
+	c.mu.Unlock()
+	stream.body.closeWithError(io.EOF)
+}
+
+// giveBack returns n bytes of receive window to the client once they have
+// been consumed.
+func (c *http2Conn) giveBack(stream *http2Stream, n int) {
+	c.mu.Lock()
+	open := c.streams[stream.id] == stream && !stream.remoteClosed
+	if open {
+		stream.recvWindow += int64(n)
+	}
+	c.mu.Unlock()
+	if open {
+		c.writeWindowUpdate(stream.id, n)
+	}
+}
+
+// removeStream forgets a finished stream.
+func (c *http2Conn) removeStream(stream *http2Stream) {
+	c.mu.Lock()
+	delete(c.streams, stream.id)
+	c.mu.Unlock()
+}
+
+func (c *http2Conn) resetStream(streamID uint32, code http2ErrCode) {
+	c.writeFrame(http2FrameRSTStream, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+func (c *http2Conn) writeWindowUpdate(streamID uint32, n int) {
+	c.writeFrame(http2FrameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(n)))
+}
+
+func (c *http2Conn) goAway(code http2ErrCode) {
+	c.mu.Lock()
+	c.goAwaySent = true
+	payload := binary.BigEndian.AppendUint32(nil, c.lastStreamID)
+	c.mu.Unlock()
+	c.writeFrame(http2FrameGoAway, 0, 0, binary.BigEndian.AppendUint32(payload, uint32(code)))
+}
+
+// writeFrame writes one frame. A failed write closes the connection, which
+// ends the read loop and with it every stream.
+func (c *http2Conn) writeFrame(frameType, flags byte, streamID uint32, payload []byte) error {
+	frame := make([]byte, 9, 9+len(payload))
+	frame[0], frame[1], frame[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	frame[3], frame[4] = frameType, flags
+	binary.BigEndian.PutUint32(frame[5:], streamID)
+	frame = append(frame, payload...)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(deadline(time.Now(), c.server.WriteTimeout))
+	if _, err := c.conn.Write(frame); err != nil {
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// writeHeaders writes a header block as a HEADERS frame followed by as
+// many CONTINUATION frames as it takes.
+func (c *http2Conn) writeHeaders(streamID uint32, block []byte, endStream bool) error {
+	c.mu.Lock()
+	maxFrame := c.peerMaxFrameSize
+	c.mu.Unlock()
+
+	// The frames of one block must not be interleaved with others.
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	frameType := byte(http2FrameHeaders)
+	for first := true; first || len(block) > 0; first = false {
+		fragment := block[:min(len(block), maxFrame)]
+		block = block[len(fragment):]
+		var flags byte
+		if first && endStream {
+			flags |= http2FlagEndStream
+		}
+		if len(block) == 0 {
+			flags |= http2FlagEndHeaders
+		}
+		frame := make([]byte, 9, 9+len(fragment))
+		frame[0], frame[1], frame[2] = byte(len(fragment)>>16), byte(len(fragment)>>8), byte(len(fragment))
+		frame[3], frame[4] = frameType, flags
+		binary.BigEndian.PutUint32(frame[5:], streamID)
+		if _, err := c.conn.Write(append(frame, fragment...)); err != nil {
+			c.conn.Close()
+			return err
+		}
+		frameType = http2FrameContinuation
+	}
+	return nil
+}
+
+// writeData sends data on stream as DATA frames, as fast as the client's
+// flow control windows allow.
+func (c *http2Conn) writeData(stream *http2Stream, data []byte) error {
+	for len(data) > 0 {
+		c.mu.Lock()
+		for !stream.reset && !c.closed && (stream.sendWindow <= 0 || c.sendWindow <= 0) {
+			c.cond.Wait()
+		}
+		if stream.reset || c.closed {
+			c.mu.Unlock()
+			return errHTTP2StreamReset
+		}
+		n := int64(len(data))
+		n = min(n, stream.sendWindow, c.sendWindow, int64(c.peerMaxFrameSize))
+		stream.sendWindow -= n
+		c.sendWindow -= n
+		c.mu.Unlock()
+
+		if err := c.writeFrame(http2FrameData, 0, stream.id, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// http2Body is the body of a request on a stream, filled from DATA frames
+// by the connection's read loop and read by the handler.
+type http2Body struct {
+	stream *http2Stream
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	err    error
+}
+
+func (b *http2Body) push(data []byte) {
+	b.mu.Lock()
+	if b.err == nil {
+		b.buf.Write(data)
+	}
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+func (b *http2Body) closeWithError(err error) {
+	b.mu.Lock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+func (b *http2Body) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	for b.buf.Len() == 0 && b.err == nil {
+		b.cond.Wait()
+	}
+	if b.buf.Len() == 0 {
+		err := b.err
+		b.mu.Unlock()
+		return 0, err
+	}
+	n, _ := b.buf.Read(p)
+	b.mu.Unlock()
+	b.stream.conn.giveBack(b.stream, n)
+	return n, nil
+}
+
+// http2ResponseConn is the connection a stream's handler writes its
+// response to. The response arrives in HTTP/1.1 form; its head becomes a
+// HEADERS frame, with the connection-specific fields dropped, and its
+// body DATA frames, de-chunked if it was sent chunked.
+type http2ResponseConn struct {
+	stream *http2Stream
+	head   *bytes.Buffer
+	// wroteHead is set once the final response head has gone out.
+	wroteHead bool
+	chunked   bool
+	chunks    http1Dechunker
+}
+
+func (r *http2ResponseConn) Write(p []byte) (int, error) {
+	if r.wroteHead {
+		if err := r.writeBody(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	r.head.Write(p)
+	end := bytes.Index(r.head.Bytes(), []byte("\r\n\r\n"))
+	if end < 0 {
+		return len(p), nil
+	}
+	head := string(r.head.Bytes()[:end])
+	rest := append([]byte{}, r.head.Bytes()[end+4:]...)
+	r.head.Reset()
+
+	interim, err := r.writeHead(head)
+	if err != nil {
+		return 0, err
+	}
+	if interim {
+		// A 1xx response; the final one follows.
+		if len(rest) > 0 {
+			return r.Write(rest)
+		}
+		return len(p), nil
+	}
+	r.wroteHead = true
+	if err := r.writeBody(rest); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeHead translates a response head into a HEADERS frame, reporting
+// whether it was an interim 1xx response.
+func (r *http2ResponseConn) writeHead(head string) (bool, error) {
+	lines := strings.Split(head, "\r\n")
+	status := strings.SplitN(lines[0], " ", 3)
+	if len(status) < 2 {
+		return false, fmt.Errorf("http2: malformed status line %q", lines[0])
+	}
+	fields := []hpackField{{":status", status[1]}}
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		switch name {
+		case "transfer-encoding":
+			r.chunked = headerHasToken(value, "chunked")
+			continue
+		case "connection", "keep-alive", "proxy-connection", "upgrade":
+			continue
+		}
+		fields = append(fields, hpackField{name, value})
+	}
+	block := hpackEncode(nil, fields)
+	return strings.HasPrefix(status[1], "1"), r.stream.conn.writeHeaders(r.stream.id, block, false)
+}
+
+func (r *http2ResponseConn) writeBody(p []byte) error {
+	if !r.chunked {
+		return r.stream.conn.writeData(r.stream, p)
+	}
+	return r.chunks.feed(p, func(data []byte) error {
+		return r.stream.conn.writeData(r.stream, data)
+	})
+}
+
+// finish ends the stream once its handler has returned: with an empty
+// DATA frame carrying END_STREAM if the response went out whole, or a
+// reset if it didn't. A client still sending a body it no longer needs to
+// is told to stop.
+func (r *http2ResponseConn) finish() {
+	stream, c := r.stream, r.stream.conn
+	defer c.removeStream(stream)
+
+	c.mu.Lock()
+	reset, remoteClosed := stream.reset, stream.remoteClosed
+	c.mu.Unlock()
+	if reset {
+		return
+	}
+	if !r.wroteHead {
+		c.resetStream(stream.id, http2InternalError)
+		return
+	}
+	c.writeFrame(http2FrameData, http2FlagEndStream, stream.id, nil)
+	if !remoteClosed {
+		c.resetStream(stream.id, http2NoError)
+	}
+}
+
+func (r *http2ResponseConn) Read([]byte) (int, error)         { return 0, io.EOF }
+func (r *http2ResponseConn) Close() error                     { return nil }
+func (r *http2ResponseConn) LocalAddr() net.Addr              { return r.stream.conn.conn.LocalAddr() }
+func (r *http2ResponseConn) RemoteAddr() net.Addr             { return r.stream.conn.conn.RemoteAddr() }
+func (r *http2ResponseConn) SetDeadline(time.Time) error      { return nil }
+func (r *http2ResponseConn) SetReadDeadline(time.Time) error  { return nil }
+func (r *http2ResponseConn) SetWriteDeadline(time.Time) error { return nil }
+
+// http1Dechunker strips HTTP/1.1 chunked framing from a body written in
+// arbitrary pieces, trailers included.
+type http1Dechunker struct {
+	line []byte
+	// left is how much of the current chunk remains, plus its CRLF.
+	left     int64
+	trailers bool
+	done     bool
+}
+
+func (d *http1Dechunker) feed(p []byte, emit func([]byte) error) error {
+	for len(p) > 0 && !d.done {
+		if d.left > 2 {
+			n := min(int64(len(p)), d.left-2)
+			if err := emit(p[:n]); err != nil {
+				return err
+			}
+			d.left -= n
+			p = p[n:]
+			continue
+		}
+		if d.left > 0 {
+			// The CRLF after a chunk's data.
+			d.left--
+			p = p[1:]
+			continue
+		}
+
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			d.line = append(d.line, p...)
+			return nil
+		}
+		line := strings.TrimSpace(string(append(d.line, p[:i]...)))
+		d.line = d.line[:0]
+		p = p[i+1:]
+		if d.trailers {
+			d.done = line == ""
+			continue
+		}
+		size, err := parseChunkSize(line)
+		if err != nil {
+			return fmt.Errorf("http2: %w", err)
+		}
+		if size == 0 {
+			d.trailers = true
+			continue
+		}
+		d.left = size + 2
+	}
+	return nil
+}
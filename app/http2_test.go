@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+func TestHTTP2Request(t *testing.T) {
+	get := []hpackField{
+		{":method", "GET"},
+		{":scheme", "https"},
+		{":authority", "example.com"},
+		{":path", "/files/a%20b?x=1"},
+	}
+	with := func(fields ...hpackField) []hpackField {
+		return append(append([]hpackField(nil), get...), fields...)
+	}
+
+	tests := []struct {
+		name   string
+		fields []hpackField
+		ok     bool
+	}{
+		{name: "request", fields: with(hpackField{"accept", "text/plain"}), ok: true},
+		{name: "te trailers", fields: with(hpackField{"te", "trailers"}), ok: true},
+		{name: "OPTIONS *", fields: []hpackField{{":method", "OPTIONS"}, {":scheme", "https"}, {":path", "*"}}, ok: true},
+		{name: "missing method", fields: get[1:]},
+		{name: "missing scheme", fields: []hpackField{{":method", "GET"}, {":path", "/"}}},
+		{name: "missing path", fields: get[:3]},
+		{name: "relative path", fields: []hpackField{{":method", "GET"}, {":scheme", "https"}, {":path", "files"}}},
+		{name: "unknown pseudo-header", fields: with(hpackField{":protocol", "websocket"})},
+		{name: "pseudo-header after regular", fields: append([]hpackField{{"accept", "*/*"}}, get...)},
+		{name: "upper-case name", fields: with(hpackField{"Accept", "text/plain"})},
+		{name: "non-token name", fields: with(hpackField{"x(a)", "b"})},
+		{name: "name with a space", fields: with(hpackField{"x a", "b"})},
+		{name: "CRLF in value", fields: with(hpackField{"x-a", "b\r\nx-injected: c"})},
+		{name: "LF in value", fields: with(hpackField{"x-a", "b\nc"})},
+		{name: "NUL in value", fields: with(hpackField{"x-a", "b\x00c"})},
+		{name: "CRLF in pseudo-header", fields: []hpackField{{":method", "GET"}, {":scheme", "https"}, {":path", "/\r\nx: y"}}},
+		{name: "connection header", fields: with(hpackField{"connection", "keep-alive"})},
+		{name: "transfer-encoding", fields: with(hpackField{"transfer-encoding", "chunked"})},
+		{name: "te other than trailers", fields: with(hpackField{"te", "gzip"})},
+		{name: "malformed escape", fields: []hpackField{{":method", "GET"}, {":scheme", "https"}, {":path", "/%zz"}}},
+	}
+	s := &Server{}
+	for _, tt := range tests {
+		request, err := s.http2Request(tt.fields, nil)
+		if tt.ok != (err == nil) {
+			t.Errorf("%s: http2Request = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+		if request != nil {
+			releaseRequest(request)
+		}
+	}
+}
+
+func TestHTTP2RequestFields(t *testing.T) {
+	fields := []hpackField{
+		{":method", "POST"},
+		{":scheme", "https"},
+		{":authority", "example.com"},
+		{":path", "/files/a%20b?x=1&y=2"},
+		{"cookie", "a=1"},
+		{"content-type", "text/plain"},
+		{"cookie", "b=2"},
+	}
+	request, err := (&Server{}).http2Request(fields, nil)
+	if err != nil {
+		t.Fatalf("http2Request: %v", err)
+	}
+	defer releaseRequest(request)
+
+	checks := []struct{ name, got, want string }{
+		{"Method", string(request.Method), "POST"},
+		{"Path", request.Path, "/files/a b"},
+		{"RawPath", request.RawPath, "/files/a%20b"},
+		{"RequestURI", request.RequestURI, "/files/a%20b?x=1&y=2"},
+		{"Proto", request.Proto, "HTTP/2.0"},
+		{"Query x", request.Query.Get("x"), "1"},
+		{"Host", request.Headers.Get("Host"), "example.com"},
+		{"Cookie", request.Headers.Get("Cookie"), "a=1; b=2"},
+		{"Content-Type", request.Headers.Get("Content-Type"), "text/plain"},
+	}
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s = %q, want %q", check.name, check.got, check.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// defaultMaxJSONBytes caps the body BindJSON will read when neither the
+// route nor the server sets a body size limit.
+const defaultMaxJSONBytes = 1 << 20
+
+// WriteJSON sends v encoded as JSON with the given status. If v can't be
+// encoded nothing is sent and the error is returned, for the handler to
+// pass on.
+func (s *Server) WriteJSON(w *ResponseWriter, status StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding JSON response: %w", err)
+	}
+	s.sendResponse(w, status, ContentTypeApplicationJSON, string(body))
+	return nil
+}
+
+// BindJSON decodes the request body, which must be a single JSON value
+// sent as application/json or a +json type, into dst. The body is read up
+// to the route's or server's size limit, or defaultMaxJSONBytes if neither
+// is set. Failures are returned as an *HTTPError: 415 for a body that
+// isn't JSON, 413 for one that is too large and 400 for one that doesn't
+// decode.
+func (r *HTTPRequest) BindJSON(dst any) error {
+	mediaType, _, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return &HTTPError{
+			Code:    StatusUnsupportedMediaType,
+			Message: fmt.Sprintf("unsupported content type %q", r.Headers.Get("Content-Type")),
+		}
+	}
+
+	limit := r.body.limit
+	if limit <= 0 {
+		limit = defaultMaxJSONBytes
+	}
+	content, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if errors.Is(err, errBodyTooLarge) || errors.Is(err, errChunkedBodyTooLarge) || int64(len(content)) > limit {
+		return &HTTPError{Code: StatusPayloadTooLarge, Err: errBodyTooLarge}
+	}
+	if err != nil {
+		return &HTTPError{Code: StatusBadRequest, Message: "incomplete body", Err: err}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	if err := decoder.Decode(dst); err != nil {
+		return &HTTPError{Code: StatusBadRequest, Message: "invalid JSON body: " + err.Error(), Err: err}
+	}
+	if decoder.More() {
+		return &HTTPError{Code: StatusBadRequest, Message: "invalid JSON body: unexpected data after the value"}
+	}
+	return nil
+}
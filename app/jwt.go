@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JWTOptions configures JWTAuth. Exactly one of Secret and PublicKey is
+// set, selecting the only algorithm tokens are accepted with: HS256 for a
+// shared secret, RS256 for an RSA key.
+type JWTOptions struct {
+	Secret    []byte
+	PublicKey *rsa.PublicKey
+
+	// Audience and Issuer, when set, must match the token's aud and iss
+	// claims.
+	Audience string
+	Issuer   string
+
+	// Scopes are required of every token, in its space-separated scope
+	// claim. Tokens lacking one are refused with 403.
+	Scopes []string
+
+	// Leeway allows for clock skew when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// JWTClaims are the claims of a validated token.
+type JWTClaims map[string]any
+
+// String returns the claim name as a string, or "" if it isn't one.
+func (c JWTClaims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Strings returns the claim name as a list of strings, which may have been
+// sent as a single string.
+func (c JWTClaims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []any:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// maxNumericDate bounds a NumericDate claim, in seconds either side of the
+// epoch, to the years 1678 to 2262 whose Unix nanoseconds fit an int64.
+// Larger values overflow when converted, which could turn a far-future
+// nbf into a past one.
+const maxNumericDate = math.MaxInt64 / float64(time.Second)
+
+// time returns a NumericDate claim, reporting false if it is absent.
+func (c JWTClaims) time(name string) (time.Time, bool, error) {
+	value, ok := c[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("claim %s is not a number", name)
+	}
+	if math.Abs(seconds) > maxNumericDate {
+		return time.Time{}, false, fmt.Errorf("claim %s is out of range", name)
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*float64(time.Second))), true, nil
+}
+
+type jwtClaimsKey struct{}
+
+// JWTClaimsFromContext returns the claims JWTAuth placed on a request's
+// context, or nil for a request that didn't pass through it.
+func JWTClaimsFromContext(ctx context.Context) JWTClaims {
+	claims, _ := ctx.Value(jwtClaimsKey{}).(JWTClaims)
+	return claims
+}
+
+// JWTAuth requires requests to carry a JSON Web Token in an Authorization
+// header using the Bearer scheme, signed with the key in options and
+// within its validity period. Requests without a valid token are refused
+// with 401, and those whose token lacks a required scope with 403, each
+// with a WWW-Authenticate challenge saying why. The token's claims are
+// placed on the request's context, see JWTClaimsFromContext, and unless
+// one was already established, its sub, roles and scope claims become the
+// request's Principal.
+// https://www.rfc-editor.org/rfc/rfc7519
+// https://www.rfc-editor.org/rfc/rfc6750
+func JWTAuth(options JWTOptions) Middleware {
+	if (options.Secret == nil) == (options.PublicKey == nil) {
+		panic("JWTAuth: exactly one of Secret and PublicKey must be set")
+	}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+			scheme, token, _ := strings.Cut(request.Headers.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || token == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				return &HTTPError{Code: StatusUnauthorized}
+			}
+			claims, err := options.validate(strings.TrimSpace(token), time.Now())
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", bearerChallenge("invalid_token", err.Error()))
+				return &HTTPError{Code: StatusUnauthorized, Err: err}
+			}
+			scopes := strings.Fields(claims.String("scope"))
+			for _, scope := range options.Scopes {
+				if !slices.Contains(scopes, scope) {
+					w.Header().Set("WWW-Authenticate", bearerChallenge("insufficient_scope", "missing scope "+scope))
+					return &HTTPError{Code: StatusForbidden}
+				}
+			}
+
+			request.SetContext(context.WithValue(request.Context(), jwtClaimsKey{}, claims))
+			if request.Principal == nil {
+				request.Principal = &Principal{Subject: claims.String("sub"), Roles: claims.Strings("roles"), Scopes: scopes}
+			}
+			return next(w, request, params)
+		}
+	}
+}
+
+func bearerChallenge(code, description string) string {
+	return "Bearer error=" + strconv.Quote(code) + ", error_description=" + strconv.Quote(description)
+}
+
+// validate checks token's signature and claims, returning the claims.
+func (o *JWTOptions) validate(token string, now time.Time) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	// The algorithm is fixed by the key, never taken from the token, so a
+	// token can't downgrade to "none" or pass an RSA public key off as an
+	// HMAC secret.
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case o.Secret != nil:
+		if header.Alg != "HS256" {
+			return nil, fmt.Errorf("unexpected algorithm %q", header.Alg)
+		}
+		mac := hmac.New(sha256.New, o.Secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, errors.New("invalid signature")
+		}
+	default:
+		if header.Alg != "RS256" {
+			return nil, fmt.Errorf("unexpected algorithm %q", header.Alg)
+		}
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(o.PublicKey, crypto.SHA256, digest[:], signature) != nil {
+			return nil, errors.New("invalid signature")
+		}
+	}
+
+	var claims JWTClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if expires, ok, err := claims.time("exp"); err != nil {
+		return nil, err
+	} else if ok && !now.Before(expires.Add(o.Leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if notBefore, ok, err := claims.time("nbf"); err != nil {
+		return nil, err
+	} else if ok && now.Add(o.Leeway).Before(notBefore) {
+		return nil, errors.New("token not valid yet")
+	}
+	if o.Audience != "" && !slices.Contains(claims.Strings("aud"), o.Audience) {
+		return nil, errors.New("token not meant for this audience")
+	}
+	if o.Issuer != "" && claims.String("iss") != o.Issuer {
+		return nil, errors.New("token from an unexpected issuer")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// signJWT returns a token with the given header algorithm and claims,
+// signed with HS256 under an HMAC secret or RS256 under an RSA key.
+func signJWT(t *testing.T, alg string, claims map[string]any, key any) string {
+	t.Helper()
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(map[string]string{"alg": alg, "typ": "JWT"}) + "." + encode(claims)
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTValidate(t *testing.T) {
+	secret := []byte("test secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER := x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)
+	now := time.Unix(1_700_000_000, 0)
+	at := func(d time.Duration) float64 { return float64(now.Add(d).Unix()) }
+
+	hs := JWTOptions{Secret: secret, Audience: "api", Issuer: "auth", Leeway: 30 * time.Second}
+	rs := JWTOptions{PublicKey: &rsaKey.PublicKey}
+	valid := map[string]any{"sub": "alice", "aud": "api", "iss": "auth", "exp": at(time.Hour)}
+	with := func(changes map[string]any) map[string]any {
+		claims := make(map[string]any)
+		for k, v := range valid {
+			claims[k] = v
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+
+	// Changing a character of the claims leaves the signature that of the
+	// original.
+	tampered := []byte(signJWT(t, "HS256", valid, secret))
+	tampered[40] ^= 1
+
+	tests := []struct {
+		name    string
+		options JWTOptions
+		token   string
+		ok      bool
+	}{
+		{name: "HS256", options: hs, token: signJWT(t, "HS256", valid, secret), ok: true},
+		{name: "RS256", options: rs, token: signJWT(t, "RS256", valid, rsaKey), ok: true},
+		{name: "audience in a list", options: hs, token: signJWT(t, "HS256", with(map[string]any{"aud": []string{"web", "api"}}), secret), ok: true},
+		{name: "no exp", options: hs, token: signJWT(t, "HS256", with(map[string]any{"exp": nil}), secret), ok: true},
+		{name: "expired within leeway", options: hs, token: signJWT(t, "HS256", with(map[string]any{"exp": at(-10 * time.Second)}), secret), ok: true},
+		{name: "expired", options: hs, token: signJWT(t, "HS256", with(map[string]any{"exp": at(-time.Minute)}), secret)},
+		{name: "nbf within leeway", options: hs, token: signJWT(t, "HS256", with(map[string]any{"nbf": at(10 * time.Second)}), secret), ok: true},
+		{name: "not valid yet", options: hs, token: signJWT(t, "HS256", with(map[string]any{"nbf": at(time.Minute)}), secret)},
+		{name: "fractional exp", options: hs, token: signJWT(t, "HS256", with(map[string]any{"exp": at(-30*time.Second) + 0.5}), secret), ok: true},
+		{name: "nbf far in the future", options: hs, token: signJWT(t, "HS256", with(map[string]any{"nbf": 1e19}), secret)},
+		{name: "exp far in the future", options: hs, token: signJWT(t, "HS256", with(map[string]any{"exp": 1e300}), secret)},
+		{name: "exp far in the past", options: hs, token: signJWT(t, "HS256", with(map[string]any{"exp": -1e19}), secret)},
+		{name: "exp not a number", options: hs, token: signJWT(t, "HS256", with(map[string]any{"exp": "tomorrow"}), secret)},
+		{name: "wrong audience", options: hs, token: signJWT(t, "HS256", with(map[string]any{"aud": "other"}), secret)},
+		{name: "no audience", options: hs, token: signJWT(t, "HS256", with(map[string]any{"aud": nil}), secret)},
+		{name: "wrong issuer", options: hs, token: signJWT(t, "HS256", with(map[string]any{"iss": "other"}), secret)},
+		{name: "wrong secret", options: hs, token: signJWT(t, "HS256", valid, []byte("other secret"))},
+		{name: "alg none", options: hs, token: signJWT(t, "none", valid, nil)},
+		{name: "RS256 token for a secret", options: hs, token: signJWT(t, "RS256", valid, rsaKey)},
+		{name: "HS256 token for a public key", options: rs, token: signJWT(t, "HS256", valid, publicDER)},
+		{name: "tampered claims", options: hs, token: string(tampered)},
+		{name: "two parts", options: hs, token: "a.b"},
+		{name: "malformed header", options: hs, token: "!!!.e30.c2ln"},
+		{name: "malformed signature", options: hs, token: signJWT(t, "HS256", valid, secret) + "!"},
+	}
+	for _, tt := range tests {
+		claims, err := tt.options.validate(tt.token, now)
+		if tt.ok != (err == nil) {
+			t.Errorf("%s: validate = %v, want ok = %v", tt.name, err, tt.ok)
+			continue
+		}
+		if tt.ok && claims.String("sub") != "alice" {
+			t.Errorf("%s: sub = %q, want alice", tt.name, claims.String("sub"))
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// dirEntry is one file or subdirectory in a directory listing.
+type dirEntry struct {
+	Name     string    `json:"name"`
+	Dir      bool      `json:"dir"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// URL is the entry's link relative to the listing.
+func (e dirEntry) URL() string {
+	if e.Dir {
+		return url.PathEscape(e.Name) + "/"
+	}
+	return url.PathEscape(e.Name)
+}
+
+var directoryListingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{.Path}}</title>
+<style>
+body { max-width: 50em; margin: 2em auto; padding: 0 1em; font-family: sans-serif; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.25em 1em 0.25em 0; }
+td.size { font-variant-numeric: tabular-nums; }
+</style>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{if .Parent}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.URL}}">{{.Name}}{{if .Dir}}/{{end}}</a></td><td class="size">{{if not .Dir}}{{.Size}}{{end}}</td><td>{{.Modified.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// sendDirectoryListing answers a files request for a directory with its
+// entries, as JSON or as an HTML index depending on the Accept header. The
+// version store is left out. Requests without a trailing slash are
+// redirected to one first, so that the relative links resolve.
+func (s *Server) sendDirectoryListing(w *ResponseWriter, request *HTTPRequest, filename, dirPath string) error {
+	if request.Path[len(request.Path)-1] != '/' {
+		s.Redirect(w, request, path.Base(request.Path)+"/", StatusMovedPermanently)
+		return nil
+	}
+
+	files, err := os.ReadDir(dirPath)
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", dirPath, err)
+	}
+	entries := make([]dirEntry, 0, len(files))
+	for _, file := range files {
+		if file.Name() == versionsDirName || isUploadTemp(file.Name()) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			// Removed since the directory was read.
+			continue
+		}
+		entry := dirEntry{Name: file.Name(), Dir: file.IsDir(), Modified: info.ModTime()}
+		if !entry.Dir {
+			entry.Size = info.Size()
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	addVary(w.Header(), "Accept")
+	if negotiateMediaType(request.Headers.Get("Accept"), "text/html", "application/json") == "application/json" {
+		return s.WriteJSON(w, StatusOK, entries)
+	}
+	var out bytes.Buffer
+	page := struct {
+		Path    string
+		Parent  bool
+		Entries []dirEntry
+	}{Path: request.Path, Parent: filename != "", Entries: entries}
+	if err := directoryListingTemplate.Execute(&out, page); err != nil {
+		return fmt.Errorf("rendering listing of %s: %w", dirPath, err)
+	}
+	s.sendResponse(w, StatusOK, ContentTypeHTML, out.String())
+	return nil
+}
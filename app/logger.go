@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Logger is what the server writes its log through. Each call takes a
+// message and alternating keys and values, as slog does, and *slog.Logger
+// satisfies it; every record carries a "component" field naming the part
+// of the server it came from, e.g. "http", "http2", "files" or "proxy".
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// LogOptions configures NewLogger.
+type LogOptions struct {
+	// JSON selects one JSON object per line instead of slog's key=value
+	// text format.
+	JSON bool
+	// Level is the least severe level logged, Info by default.
+	Level slog.Level
+	// Levels overrides Level for individual components, e.g. to turn on
+	// debug logging for "http2" alone.
+	Levels map[string]slog.Level
+}
+
+// NewLogger returns a slog logger writing to output, filtered by level per
+// component.
+func NewLogger(output io.Writer, options LogOptions) *slog.Logger {
+	// The inner handler logs everything the most verbose component
+	// wants; componentLevelHandler drops the rest.
+	lowest := options.Level
+	for _, level := range options.Levels {
+		lowest = min(lowest, level)
+	}
+	handlerOptions := &slog.HandlerOptions{Level: lowest}
+	var handler slog.Handler
+	if options.JSON {
+		handler = slog.NewJSONHandler(output, handlerOptions)
+	} else {
+		handler = slog.NewTextHandler(output, handlerOptions)
+	}
+	return slog.New(&componentLevelHandler{Handler: handler, levels: options.Levels, level: options.Level})
+}
+
+// ParseLogLevels parses per-component levels written as
+// component=level pairs separated by commas, e.g. "http2=debug,proxy=warn".
+func ParseLogLevels(s string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		component, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not component=level", pair)
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return nil, err
+		}
+		levels[component] = level
+	}
+	return levels, nil
+}
+
+// componentLevelHandler applies the level of the component a logger was
+// made for, which it learns from the "component" attribute it is given
+// With.
+type componentLevelHandler struct {
+	slog.Handler
+	levels map[string]slog.Level
+	level  slog.Level
+}
+
+func (h *componentLevelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.Handler.Enabled(ctx, level)
+}
+
+func (h *componentLevelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.Handler = h.Handler.WithAttrs(attrs)
+	for _, attr := range attrs {
+		if level, ok := h.levels[attr.Value.String()]; ok && attr.Key == "component" {
+			next.level = level
+		}
+	}
+	return &next
+}
+
+func (h *componentLevelHandler) WithGroup(name string) slog.Handler {
+	next := *h
+	next.Handler = h.Handler.WithGroup(name)
+	return &next
+}
+
+// logger returns the server's logger for component.
+func (s *Server) logger(component string) Logger {
+	if s.Logger == nil {
+		return defaultLogger(component)
+	}
+	return withComponent(s.Logger, component)
+}
+
+// defaultLogger returns slog's default logger for component, for the parts
+// of the package that log without a server at hand.
+func defaultLogger(component string) Logger {
+	return slog.Default().With("component", component)
+}
+
+func withComponent(logger Logger, component string) Logger {
+	if l, ok := logger.(*slog.Logger); ok {
+		return l.With("component", component)
+	}
+	return componentLogger{logger, component}
+}
+
+// componentLogger adds the component field to the records of a Logger
+// other than slog's.
+type componentLogger struct {
+	Logger
+	component string
+}
+
+func (l componentLogger) Debug(msg string, args ...any) {
+	l.Logger.Debug(msg, append([]any{"component", l.component}, args...)...)
+}
+
+func (l componentLogger) Info(msg string, args ...any) {
+	l.Logger.Info(msg, append([]any{"component", l.component}, args...)...)
+}
+
+func (l componentLogger) Warn(msg string, args ...any) {
+	l.Logger.Warn(msg, append([]any{"component", l.component}, args...)...)
+}
+
+func (l componentLogger) Error(msg string, args ...any) {
+	l.Logger.Error(msg, append([]any{"component", l.component}, args...)...)
+}
+
+// requestLogArgs are the fields identifying request in log records.
+func requestLogArgs(request *HTTPRequest, args ...any) []any {
+	fields := []any{"method", string(request.Method), "path", request.Path}
+	if id := RequestIDFromContext(request.Context()); id != "" {
+		fields = append(fields, "request_id", id)
+	}
+	return append(fields, args...)
+}
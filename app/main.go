@@ -1,25 +1,377 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var directoryFlag string
+var fileVersionsFlag int
+var templatesFlag string
+var docsFlag string
+var devFlag bool
+var slowLogFlag string
+var slowThresholdFlag time.Duration
+var metricsFlag bool
+var alertWebhookFlag string
+var mmapMinSizeFlag int64
+var lenientFlag bool
+var drainTimeoutFlag time.Duration
+var accessLogFlag string
+var maxBodyFlag int64
+var accessLogFormatFlag string
+var handlerTimeoutFlag time.Duration
+var sniffFlag bool
+var dirListingFlag bool
+var proxyFlag string
+var proxyStrategyFlag string
+var proxyHealthPathFlag string
+var proxyHealthIntervalFlag time.Duration
+var maxConnsFlag int
+var rejectOverloadFlag bool
+var nagleFlag bool
+var trustedProxiesFlag string
+var rateLimitFlag int
+var corsOriginsFlag string
+var adminPortFlag string
+var requestIDFlag bool
+var logFormatFlag string
+var logLevelFlag string
+var logLevelsFlag string
+var trailingSlashFlag string
+var methodOverrideFlag bool
+var configFlag string
+var encodingsFlag string
+var portFlag string
+var hostFlag string
+var tlsCertFlag string
+var tlsKeyFlag string
 
-func init() {
+// parseFlags defines the command-line flags and parses them, filling in
+// the ones not given from the environment.
+func parseFlags() {
+	flag.StringVar(&portFlag, "port", "4221", "port to listen on")
+	flag.StringVar(&hostFlag, "host", "", "address to listen on, e.g. 127.0.0.1 (empty means every interface)")
+	flag.StringVar(&tlsCertFlag, "tls-cert", "", "certificate file to serve HTTPS with, together with -tls-key")
+	flag.StringVar(&tlsKeyFlag, "tls-key", "", "private key file for -tls-cert")
 	flag.StringVar(&directoryFlag, "directory", "/tmp", "directory to create files in")
+	flag.IntVar(&fileVersionsFlag, "versions", 0, "number of previous versions to keep when a file is overwritten")
+	flag.StringVar(&templatesFlag, "templates", "", "glob of HTML templates to load, e.g. templates/*.html")
+	flag.StringVar(&docsFlag, "docs", "", "directory of Markdown documents to serve under /docs/")
+	flag.BoolVar(&devFlag, "dev", false, "development mode: reload templates on every request")
+	flag.StringVar(&slowLogFlag, "slow-log", "", "file to log slow requests to (\"-\" for stderr)")
+	flag.DurationVar(&slowThresholdFlag, "slow-threshold", time.Second, "latency above which a request is logged as slow")
+	flag.BoolVar(&metricsFlag, "metrics", false, "collect request metrics and serve them on /metrics and /debug/vars")
+	flag.StringVar(&alertWebhookFlag, "alert-webhook", "", "URL to POST alerts to when the 5xx rate or panic count spikes")
+	flag.Int64Var(&mmapMinSizeFlag, "mmap-min-size", 0, "serve files of at least this many bytes from memory mappings (0 disables)")
+	flag.BoolVar(&lenientFlag, "lenient", false, "accept bare LF line endings from clients that don't send CRLF")
+	flag.DurationVar(&drainTimeoutFlag, "drain-timeout", defaultDrainTimeout, "how long to wait for in-flight requests on shutdown")
+	flag.StringVar(&accessLogFlag, "access-log", "", "file to write the access log to (\"-\" for stdout)")
+	flag.StringVar(&accessLogFormatFlag, "access-log-format", "combined", "access log format: common or combined")
+	flag.Int64Var(&maxBodyFlag, "max-body", 0, "largest request body accepted, in bytes (0 means no limit)")
+	flag.DurationVar(&handlerTimeoutFlag, "handler-timeout", 0, "how long a request may take to serve before it is abandoned (0 means no limit)")
+	flag.BoolVar(&dirListingFlag, "dir-listing", false, "list the contents of directories requested under /files/")
+	flag.BoolVar(&sniffFlag, "sniff", false, "detect the Content-Type of files without a known extension from their content")
+	flag.StringVar(&proxyFlag, "proxy", "", "proxy a path prefix to upstreams, e.g. /api=http://10.0.0.1:8080,http://10.0.0.2:8080")
+	flag.StringVar(&proxyStrategyFlag, "proxy-strategy", "round-robin", "how to balance proxied requests: round-robin, least-conn or weighted")
+	flag.StringVar(&proxyHealthPathFlag, "proxy-health-path", "", "path to probe proxy upstreams on to take dead ones out of rotation (empty disables)")
+	flag.DurationVar(&proxyHealthIntervalFlag, "proxy-health-interval", defaultHealthCheckInterval, "how often to probe proxy upstreams")
+	flag.IntVar(&maxConnsFlag, "max-conns", 0, "most client connections to serve at once (0 means no limit)")
+	flag.BoolVar(&rejectOverloadFlag, "reject-overload", false, "answer connections beyond -max-conns with 503 instead of leaving them waiting")
+	flag.BoolVar(&nagleFlag, "nagle", false, "leave Nagle's algorithm on for client connections instead of setting TCP_NODELAY")
+	flag.StringVar(&trustedProxiesFlag, "trusted-proxies", "", "comma-separated CIDR ranges of proxies whose X-Forwarded-For and Forwarded headers to believe")
+	flag.IntVar(&rateLimitFlag, "rate-limit", 0, "requests per minute allowed from each client IP (0 means no limit)")
+	flag.StringVar(&corsOriginsFlag, "cors-origins", "", "comma-separated origins allowed to call the server from the browser, e.g. https://app.example.com or *")
+	flag.StringVar(&adminPortFlag, "admin-port", "", "port to serve the /debug/pprof/ profiles on, kept off the public port (empty disables)")
+	flag.BoolVar(&requestIDFlag, "request-id", false, "tag every request with an X-Request-ID, reusing the client's, and log it")
+	flag.StringVar(&logFormatFlag, "log-format", "text", "server log format: text or json")
+	flag.StringVar(&logLevelFlag, "log-level", "info", "least severe server log level: debug, info, warn or error")
+	flag.StringVar(&logLevelsFlag, "log-levels", "", "per-component log levels, e.g. http2=debug,files=warn")
+	flag.StringVar(&trailingSlashFlag, "trailing-slash", "strict", "what to do with paths that only match a route with their trailing slash toggled: strict, redirect or ignore")
+	flag.BoolVar(&methodOverrideFlag, "method-override", false, "let POST requests stand in for PUT, PATCH and DELETE through X-HTTP-Method-Override or a _method form field")
+	flag.StringVar(&encodingsFlag, "encodings", "", "comma-separated built-in content codings to compress responses with besides gzip: br, zstd")
+	flag.StringVar(&configFlag, "config", "", "configuration file to load; flags given on the command line override its settings")
+	flag.Usage = envUsage(flag.CommandLine)
 	flag.Parse()
+	if err := applyEnv(flag.CommandLine, os.LookupEnv); err != nil {
+		log.Fatalf("Invalid environment: %v", err)
+	}
 }
 
 func main() {
-	server := NewServer("4221")
+	parseFlags()
+	if flag.Arg(0) == "bench" {
+		os.Exit(runBench(flag.Args()[1:], os.Stdout))
+	}
+
+	config := &ConfigFile{}
+	if configFlag != "" {
+		var err error
+		if config, err = LoadConfig(configFlag); err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+	}
+	// Only the flags given on the command line or the environment override
+	// the file, so their defaults don't mask its settings.
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	logOptions := config.Log
+	if setFlags["log-format"] {
+		if logFormatFlag != "text" && logFormatFlag != "json" {
+			log.Fatalf("Unknown log format: %s", logFormatFlag)
+		}
+		logOptions.JSON = logFormatFlag == "json"
+	}
+	if setFlags["log-level"] {
+		if err := logOptions.Level.UnmarshalText([]byte(logLevelFlag)); err != nil {
+			log.Fatalf("Invalid -log-level: %v", err)
+		}
+	}
+	if setFlags["log-levels"] {
+		levels, err := ParseLogLevels(logLevelsFlag)
+		if err != nil {
+			log.Fatalf("Invalid -log-levels: %v", err)
+		}
+		logOptions.Levels = levels
+	}
+	// Made the default, the logger also takes over the log package's
+	// output and that of the parts of the server that log on their own.
+	logger := NewLogger(os.Stderr, logOptions)
+	slog.SetDefault(logger)
+
+	options := append([]Option{WithLogger(logger)}, config.Options()...)
+	if setFlags["host"] || setFlags["port"] {
+		options = append(options, WithAddr(net.JoinHostPort(hostFlag, portFlag)))
+	}
+	if setFlags["tls-cert"] || setFlags["tls-key"] {
+		if tlsCertFlag == "" || tlsKeyFlag == "" {
+			log.Fatalf("-tls-cert and -tls-key must be given together")
+		}
+		options = append(options, WithTLS(tlsCertFlag, tlsKeyFlag))
+	}
+	if setFlags["directory"] {
+		options = append(options, WithFilesDir(directoryFlag))
+	}
+	if setFlags["versions"] {
+		options = append(options, WithFileVersions(fileVersionsFlag))
+	}
+	if setFlags["handler-timeout"] {
+		options = append(options, func(s *Server) { s.HandlerTimeout = handlerTimeoutFlag })
+	}
+	if setFlags["drain-timeout"] {
+		options = append(options, func(s *Server) { s.DrainTimeout = drainTimeoutFlag })
+	}
+	if setFlags["max-body"] {
+		options = append(options, func(s *Server) { s.MaxBodyBytes = maxBodyFlag })
+	}
+	if setFlags["max-conns"] {
+		options = append(options, func(s *Server) { s.MaxConns = maxConnsFlag })
+	}
+	server := NewServer(options...)
+	if err := checkFilesDir(server.FilesDir); err != nil {
+		log.Fatalf("Unusable files directory: %v", err)
+	}
+	if templatesFlag != "" {
+		renderer, err := NewRenderer(templatesFlag)
+		if err != nil {
+			log.Fatalf("Failed to load templates: %v", err)
+		}
+		renderer.Reload = devFlag
+		renderer.URL = server.URL
+		server.Renderer = renderer
+	}
+	if slowLogFlag != "" {
+		output := os.Stderr
+		if slowLogFlag != "-" {
+			file, err := os.OpenFile(slowLogFlag, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				log.Fatalf("Failed to open slow log: %v", err)
+			}
+			defer file.Close()
+			output = file
+		}
+		server.SlowLog = NewSlowLog(output, slowThresholdFlag)
+	}
+	if accessLogFlag != "" {
+		format := FormatCombined
+		switch accessLogFormatFlag {
+		case "combined":
+		case "common":
+			format = FormatCommon
+		default:
+			log.Fatalf("Unknown access log format: %s", accessLogFormatFlag)
+		}
+		output := os.Stdout
+		if accessLogFlag != "-" {
+			file, err := os.OpenFile(accessLogFlag, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				log.Fatalf("Failed to open access log: %v", err)
+			}
+			defer file.Close()
+			output = file
+		}
+		server.AccessLog = NewAccessLog(output, format)
+	}
+	if metricsFlag {
+		server.Metrics = NewMetrics()
+	}
+	if alertWebhookFlag != "" {
+		server.Alerts = NewErrorAlert(0.1, 5)
+		server.Alerts.WebhookURL = alertWebhookFlag
+	}
+	server.LenientLineEndings = lenientFlag
+	if mmapMinSizeFlag > 0 {
+		server.Mmap = NewMmapCache(mmapMinSizeFlag)
+	}
+	server.SniffContentType = sniffFlag
+	server.DirectoryListing = dirListingFlag
+	server.MethodOverride = methodOverrideFlag
+	switch trailingSlashFlag {
+	case "strict":
+	case "redirect":
+		server.TrailingSlash = TrailingSlashRedirect
+	case "ignore":
+		server.TrailingSlash = TrailingSlashIgnore
+	default:
+		log.Fatalf("Unknown trailing slash policy: %s", trailingSlashFlag)
+	}
+	server.RejectOverload = rejectOverloadFlag
+	server.Nagle = nagleFlag
+	trustedProxies, err := ParseTrustedProxies(strings.Split(trustedProxiesFlag, ",")...)
+	if err != nil {
+		log.Fatalf("Invalid -trusted-proxies: %v", err)
+	}
+	server.TrustedProxies = trustedProxies
+	if corsOriginsFlag != "" {
+		server.CORS = &CORSPolicy{
+			AllowedOrigins: strings.Split(corsOriginsFlag, ","),
+			AllowedMethods: []HTTPMethod{MethodGet, MethodHead, MethodPost, MethodPut, MethodDelete},
+			MaxAge:         10 * time.Minute,
+		}
+	}
+	if encodingsFlag != "" {
+		for _, name := range strings.Split(encodingsFlag, ",") {
+			if err := EnableEncoding(strings.TrimSpace(name)); err != nil {
+				log.Fatalf("Invalid -encodings: %v", err)
+			}
+		}
+	}
 	server.setupRoutes()
+	if requestIDFlag {
+		server.Use(RequestID())
+	}
+	if rateLimitFlag > 0 {
+		server.Use(RateLimit(RateLimitOptions{Requests: rateLimitFlag}))
+	}
+	if proxyFlag != "" {
+		prefix, upstreams, ok := strings.Cut(proxyFlag, "=")
+		if !ok {
+			log.Fatalf("Invalid -proxy: %s", proxyFlag)
+		}
+		pool, err := NewUpstreamPool(strings.Split(upstreams, ",")...)
+		if err != nil {
+			log.Fatalf("Invalid -proxy: %v", err)
+		}
+		switch proxyStrategyFlag {
+		case "round-robin":
+		case "least-conn":
+			pool.Strategy = LeastConnections()
+		case "weighted":
+			pool.Strategy = WeightedRoundRobin()
+		default:
+			log.Fatalf("Unknown proxy strategy: %s", proxyStrategyFlag)
+		}
+		if proxyHealthPathFlag != "" {
+			pool.CheckHealth(context.Background(), HealthCheck{Path: proxyHealthPathFlag, Interval: proxyHealthIntervalFlag})
+		}
+		server.Proxy(prefix, pool)
+	}
+
+	if adminPortFlag != "" {
+		admin := NewServer(WithAddr(":"+adminPortFlag), WithLogger(logger))
+		admin.Pprof("/debug/pprof/")
+		go admin.ListenAndServe()
+	}
+
+	drained := make(chan struct{})
+	go shutdownOnSignal(server, drained)
 	server.ListenAndServe()
+	<-drained
+}
+
+// checkFilesDir verifies that dir exists, is a directory and can be
+// written to, so a typo in -directory stops the server at startup rather
+// than failing every upload.
+func checkFilesDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if err := DirWritableCheck(dir)(context.Background()); err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	return nil
+}
+
+// shutdownOnSignal shuts server down gracefully on SIGINT or SIGTERM. A
+// second signal cuts the drain short. drained is closed once the shutdown
+// has completed.
+func shutdownOnSignal(server *Server, drained chan<- struct{}) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	server.logger("http").Info("Shutting down", "signal", sig.String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-signals
+		cancel()
+	}()
+	if err := server.Shutdown(ctx); err != nil {
+		server.logger("http").Error("Shutdown failed", "err", err)
+	}
+	close(drained)
 }
 
 func (s *Server) setupRoutes() {
-	s.HandleFunc("/", s.handleIndex)
-	s.HandleFunc("/echo/:message", s.handleEchoMessage)
-	s.HandleFunc("/user-agent", s.handleUserAgent)
-	s.HandleFunc("/files/:filename", s.handleFiles)
+	s.GET("/", s.handleIndex)
+	s.GET("/echo/:message", s.handleEchoMessage, Compress(CompressOptions{}))
+	s.GET("/user-agent", s.handleUserAgent)
+	files := s.Group("/files")
+	files.GET("/*filepath", s.handleFiles).Name("file")
+	files.POST("/*filepath", s.handleFiles)
+	files.PUT("/*filepath", s.handleFiles)
+	files.DELETE("/*filepath", s.handleFiles)
+	s.GET("/files.zip", s.handleFilesArchive)
+	s.GET("/events/files", s.handleFileEvents)
+
+	s.GET("/healthz", s.handleHealthz)
+	s.GET("/livez", s.handleHealthz)
+	s.GET("/readyz", s.handleReadyz)
+	s.AddReadinessCheck("files-directory", DirWritableCheck(s.FilesDir))
+
+	if s.Metrics != nil {
+		s.GET("/metrics", s.handleMetrics)
+		s.GET("/debug/vars", s.handleDebugVars)
+	}
+
+	if docsFlag != "" {
+		docs := s.Markdown("/docs/", os.DirFS(docsFlag))
+		if s.Renderer != nil && s.Renderer.Has("markdown.html") {
+			docs.Template = "markdown.html"
+		}
+	}
 }
@@ -0,0 +1,755 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MarkdownMount serves the .md files of a directory tree rendered as HTML.
+// Clients that prefer text/markdown or text/plain over text/html get the raw
+// source instead. Other files under the mount, such as images referenced by
+// the documents, are served as-is.
+type MarkdownMount struct {
+	prefix string
+	root   fs.FS
+
+	// Template names the server Renderer template used as the page layout.
+	// It is executed with a markdownPage; when empty, or when the server has
+	// no renderer, a built-in layout is used.
+	Template string
+}
+
+// markdownPage is the data passed to Markdown page templates.
+type markdownPage struct {
+	Title   string
+	Path    string
+	Content template.HTML
+}
+
+var defaultMarkdownTemplate = template.Must(template.New("markdown").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { max-width: 50em; margin: 2em auto; padding: 0 1em; font-family: sans-serif; line-height: 1.5; }
+pre { background: #f6f8fa; padding: 1em; overflow: auto; }
+code { font-family: monospace; }
+blockquote { margin-left: 0; padding-left: 1em; border-left: 4px solid #ddd; color: #555; }
+.kw { color: #d73a49; } .str { color: #032f62; } .com { color: #6a737d; font-style: italic; } .num { color: #005cc5; }
+</style>
+</head>
+<body>
+{{.Content}}
+</body>
+</html>
+`))
+
+// Markdown serves the Markdown documents in root under prefix. Directories
+// resolve to their index.md or README.md.
+func (s *Server) Markdown(prefix string, root fs.FS) *MarkdownMount {
+	mount := &MarkdownMount{
+		prefix: strings.TrimSuffix(prefix, "/"),
+		root:   root,
+	}
+	s.HandleFunc(mount.prefix+"/*filepath", mount.handler(s))
+	return mount
+}
+
+func (m *MarkdownMount) handler(s *Server) HandlerFunc {
+	return func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+		if request.Method != MethodGet {
+			return &HTTPError{Code: StatusMethodNotAllowed}
+		}
+
+		name, err := m.resolve(params["filepath"])
+		var content []byte
+		if err == nil {
+			content, err = fs.ReadFile(m.root, name)
+		}
+		if err != nil {
+			return err
+		}
+
+		if path.Ext(name) != ".md" {
+			contentType := s.contentTypeFor(name, content)
+			s.sendResponse(w, StatusOK, contentType, string(content))
+			return nil
+		}
+
+		addVary(w.Header(), "Accept")
+		switch negotiateMediaType(request.Headers.Get("Accept"), "text/html", "text/markdown", "text/plain") {
+		case "text/markdown":
+			s.sendResponse(w, StatusOK, ContentTypeMarkdown, string(content))
+			return nil
+		case "text/plain":
+			s.sendResponse(w, StatusOK, "text/plain; charset=utf-8", string(content))
+			return nil
+		}
+
+		body, title := renderMarkdown(string(content))
+		if title == "" {
+			title = path.Base(name)
+		}
+		page := markdownPage{Title: title, Path: name, Content: template.HTML(body)}
+
+		if m.Template != "" && s.Renderer != nil {
+			s.Render(w, StatusOK, m.Template, page)
+			return nil
+		}
+		var out bytes.Buffer
+		if err := defaultMarkdownTemplate.Execute(&out, page); err != nil {
+			return fmt.Errorf("rendering %s: %w", name, err)
+		}
+		s.sendResponse(w, StatusOK, ContentTypeHTML, out.String())
+		return nil
+	}
+}
+
+// resolve maps a request path onto a file in the mount, resolving
+// directories to their index document.
+func (m *MarkdownMount) resolve(requestPath string) (string, error) {
+	name := strings.Trim(path.Clean("/"+requestPath), "/")
+	if name == "" {
+		name = "."
+	}
+
+	info, err := fs.Stat(m.root, name)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return name, nil
+	}
+	for _, index := range []string{"index.md", "README.md"} {
+		candidate := path.Join(name, index)
+		if _, err := fs.Stat(m.root, candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fs.ErrNotExist
+}
+
+// Markdown to HTML
+//
+// renderMarkdown supports the commonly used subset of CommonMark: ATX and
+// setext headings, paragraphs, emphasis, code spans, fenced and indented
+// code blocks, block quotes, nested lists, links, images and thematic
+// breaks. Raw HTML in the source is escaped rather than passed through.
+
+var (
+	atxHeading     = regexp.MustCompile(`^(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	listItemMarker = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])( +|$)`)
+	fenceOpen      = regexp.MustCompile("^ {0,3}(```+|~~~+)[ \t]*([^`\\s]*)")
+)
+
+// renderMarkdown converts a Markdown document to HTML and returns it with
+// the text of the first heading, for use as a page title.
+func renderMarkdown(source string) (string, string) {
+	lines := strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n")
+	r := &markdownRenderer{}
+	r.blocks(lines)
+	return r.out.String(), r.title
+}
+
+type markdownRenderer struct {
+	out   strings.Builder
+	title string
+}
+
+func (r *markdownRenderer) blocks(lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case fenceOpen.MatchString(line):
+			i = r.fencedCode(lines, i)
+
+		case strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t"):
+			i = r.indentedCode(lines, i)
+
+		case atxHeading.MatchString(trimmed):
+			m := atxHeading.FindStringSubmatch(trimmed)
+			r.heading(len(m[1]), m[2])
+			i++
+
+		case isThematicBreak(line):
+			r.out.WriteString("<hr>\n")
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			i = r.blockquote(lines, i)
+
+		case listItemMarker.MatchString(line):
+			i = r.list(lines, i)
+
+		default:
+			i = r.paragraph(lines, i)
+		}
+	}
+}
+
+func (r *markdownRenderer) heading(level int, text string) {
+	if r.title == "" {
+		r.title = text
+	}
+	tag := "h" + string(rune('0'+level))
+	r.out.WriteString("<" + tag + ">" + renderInline(text) + "</" + tag + ">\n")
+}
+
+func (r *markdownRenderer) fencedCode(lines []string, i int) int {
+	m := fenceOpen.FindStringSubmatch(lines[i])
+	fence, lang := m[1], strings.ToLower(m[2])
+
+	var code []string
+	i++
+	for ; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+			i++
+			break
+		}
+		code = append(code, lines[i])
+	}
+	r.codeBlock(strings.Join(code, "\n"), lang)
+	return i
+}
+
+func (r *markdownRenderer) indentedCode(lines []string, i int) int {
+	var code []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			code = append(code, "")
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			line = line[1:]
+		} else if strings.HasPrefix(line, "    ") {
+			line = line[4:]
+		} else {
+			break
+		}
+		code = append(code, line)
+	}
+	for len(code) > 0 && code[len(code)-1] == "" {
+		code = code[:len(code)-1]
+	}
+	r.codeBlock(strings.Join(code, "\n"), "")
+	return i
+}
+
+func (r *markdownRenderer) codeBlock(code, lang string) {
+	if lang != "" {
+		r.out.WriteString(`<pre><code class="language-` + html.EscapeString(lang) + `">`)
+	} else {
+		r.out.WriteString("<pre><code>")
+	}
+	r.out.WriteString(highlight(code, lang))
+	r.out.WriteString("</code></pre>\n")
+}
+
+func (r *markdownRenderer) blockquote(lines []string, i int) int {
+	var inner []string
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(trimmed, ">") {
+			break
+		}
+		trimmed = strings.TrimPrefix(trimmed, ">")
+		inner = append(inner, strings.TrimPrefix(trimmed, " "))
+	}
+	r.out.WriteString("<blockquote>\n")
+	r.blocks(inner)
+	r.out.WriteString("</blockquote>\n")
+	return i
+}
+
+// list renders a run of list items of the same kind. Each item's body is
+// the text after its marker plus any following lines indented past the
+// marker, which is rendered recursively so nested lists work.
+func (r *markdownRenderer) list(lines []string, i int) int {
+	first := listItemMarker.FindStringSubmatch(lines[i])
+	ordered := first[2][0] >= '0' && first[2][0] <= '9'
+	tag := "ul"
+	if ordered {
+		tag = "ol"
+	}
+	r.out.WriteString("<" + tag + ">\n")
+
+	for i < len(lines) {
+		m := listItemMarker.FindStringSubmatch(lines[i])
+		if m == nil || (m[2][0] >= '0' && m[2][0] <= '9') != ordered {
+			break
+		}
+		indent := len(m[0])
+		if len(m[3]) > 4 {
+			indent = len(m[1]) + len(m[2]) + 1
+		}
+
+		body := []string{lines[i][len(m[0]):]}
+		i++
+		for i < len(lines) {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				// A blank line continues the item only if indented content
+				// follows it.
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) >= indent {
+					body = append(body, "")
+					i++
+					continue
+				}
+				break
+			}
+			if leadingSpaces(line) >= indent {
+				body = append(body, line[indent:])
+			} else if listItemMarker.MatchString(line) || !isParagraphContinuation(line) {
+				break
+			} else {
+				body = append(body, strings.TrimSpace(line))
+			}
+			i++
+		}
+
+		r.out.WriteString("<li>")
+		if lead := leadingText(body); lead > 0 {
+			// Tight items keep their text inline, even when a nested
+			// block follows it.
+			r.out.WriteString(renderInline(strings.Join(body[:lead], "\n")))
+			if lead < len(body) {
+				r.out.WriteString("\n")
+				r.blocks(body[lead:])
+			}
+		} else {
+			r.out.WriteString("\n")
+			r.blocks(body)
+		}
+		r.out.WriteString("</li>\n")
+
+		if i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+			if i+1 < len(lines) && listItemMarker.MatchString(lines[i+1]) {
+				i++
+				continue
+			}
+			break
+		}
+	}
+
+	r.out.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// leadingText returns how many lines of a tight list item body are plain
+// text before any nested block, or 0 if the item is loose and needs
+// paragraphs.
+func leadingText(body []string) int {
+	lead := len(body)
+	for i, line := range body {
+		if strings.TrimSpace(line) == "" {
+			return 0
+		}
+		if lead == len(body) && (listItemMarker.MatchString(line) || fenceOpen.MatchString(line)) {
+			lead = i
+		}
+	}
+	return lead
+}
+
+func (r *markdownRenderer) paragraph(lines []string, i int) int {
+	var text []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if len(text) > 0 && isSetextUnderline(trimmed) {
+			level := 1
+			if trimmed[0] == '-' {
+				level = 2
+			}
+			r.heading(level, strings.Join(text, " "))
+			return i + 1
+		}
+		if trimmed == "" || (len(text) > 0 && !isParagraphContinuation(line)) {
+			break
+		}
+		text = append(text, strings.TrimLeft(line, " \t"))
+	}
+
+	r.out.WriteString("<p>" + renderInline(strings.Join(text, "\n")) + "</p>\n")
+	return i
+}
+
+// isThematicBreak reports whether line is three or more of the same -, *
+// or _ characters, optionally separated by spaces.
+func isThematicBreak(line string) bool {
+	if leadingSpaces(line) > 3 {
+		return false
+	}
+	stripped := strings.NewReplacer(" ", "", "\t", "").Replace(line)
+	return len(stripped) >= 3 && strings.IndexByte("-*_", stripped[0]) >= 0 && strings.Trim(stripped, stripped[:1]) == ""
+}
+
+func isSetextUnderline(trimmed string) bool {
+	return trimmed != "" && (strings.Trim(trimmed, "=") == "" || strings.Trim(trimmed, "-") == "")
+}
+
+// isParagraphContinuation reports whether line may continue a paragraph
+// rather than start a new block.
+func isParagraphContinuation(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return !(atxHeading.MatchString(trimmed) ||
+		isThematicBreak(line) ||
+		strings.HasPrefix(trimmed, ">") ||
+		fenceOpen.MatchString(line) ||
+		listItemMarker.MatchString(line))
+}
+
+func leadingSpaces(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// renderInline converts inline Markdown to HTML, escaping everything else.
+func renderInline(text string) string {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_{}[]()#+-.!<>|~", text[i+1]) >= 0:
+			out.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			if n, ok := codeSpan(text[i:], &out); ok {
+				i += n
+				continue
+			}
+
+		case c == '!' && strings.HasPrefix(text[i:], "!["):
+			if label, dest, n, ok := parseLink(text[i+1:]); ok {
+				out.WriteString(`<img src="` + html.EscapeString(safeURL(dest)) + `" alt="` + html.EscapeString(label) + `">`)
+				i += n + 1
+				continue
+			}
+
+		case c == '[':
+			if label, dest, n, ok := parseLink(text[i:]); ok {
+				out.WriteString(`<a href="` + html.EscapeString(safeURL(dest)) + `">` + renderInline(label) + `</a>`)
+				i += n
+				continue
+			}
+
+		case c == '<':
+			if end := strings.IndexByte(text[i:], '>'); end > 0 {
+				target := text[i+1 : i+end]
+				if (strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")) && !strings.ContainsAny(target, " \t\n") {
+					out.WriteString(`<a href="` + html.EscapeString(target) + `">` + html.EscapeString(target) + `</a>`)
+					i += end + 1
+					continue
+				}
+			}
+
+		case c == '*' || c == '_':
+			if n, ok := emphasis(text[i:], i > 0 && isWordByte(text[i-1]), &out); ok {
+				i += n
+				continue
+			}
+
+		case c == '\n':
+			// Two trailing spaces before a newline make a hard line break.
+			current := out.String()
+			if strings.HasSuffix(current, "  ") {
+				trimmed := strings.TrimRight(current, " ")
+				out.Reset()
+				out.WriteString(trimmed)
+				out.WriteString("<br>\n")
+			} else {
+				out.WriteByte('\n')
+			}
+			i++
+			continue
+		}
+
+		out.WriteString(html.EscapeString(text[i : i+1]))
+		i++
+	}
+	return out.String()
+}
+
+// codeSpan renders a code span starting at text[0] and returns how many
+// bytes it consumed.
+func codeSpan(text string, out *strings.Builder) (int, bool) {
+	ticks := len(text) - len(strings.TrimLeft(text, "`"))
+	fence := text[:ticks]
+	end := strings.Index(text[ticks:], fence)
+	if end < 0 {
+		return 0, false
+	}
+	code := strings.ReplaceAll(text[ticks:ticks+end], "\n", " ")
+	if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+		code = code[1 : len(code)-1]
+	}
+	out.WriteString("<code>" + html.EscapeString(code) + "</code>")
+	return ticks + end + ticks, true
+}
+
+// parseLink parses "[label](destination)" at the start of text.
+func parseLink(text string) (label, dest string, n int, ok bool) {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				if i+1 >= len(text) || text[i+1] != '(' {
+					return "", "", 0, false
+				}
+				end := closingParen(text[i+2:])
+				if end < 0 {
+					return "", "", 0, false
+				}
+				dest = strings.TrimSpace(text[i+2 : i+2+end])
+				// Drop an optional link title.
+				if space := strings.IndexAny(dest, " \t"); space >= 0 {
+					dest = dest[:space]
+				}
+				return text[1:i], strings.Trim(dest, "<>"), i + 3 + end, true
+			}
+		}
+	}
+	return "", "", 0, false
+}
+
+// closingParen finds the parenthesis closing a link destination, allowing
+// balanced parentheses inside it.
+func closingParen(text string) int {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// emphasis renders *em*, **strong** or their underscore forms starting at
+// text[0]. Underscores only count at word boundaries.
+func emphasis(text string, afterWord bool, out *strings.Builder) (int, bool) {
+	marker := text[0]
+	if marker == '_' && afterWord {
+		return 0, false
+	}
+	size := 1
+	if len(text) > 1 && text[1] == marker {
+		size = 2
+	}
+	delim := text[:size]
+	if len(text) <= size || text[size] == ' ' {
+		return 0, false
+	}
+
+	for start := size; ; {
+		end := strings.Index(text[start:], delim)
+		if end < 0 {
+			return 0, false
+		}
+		end += start
+		// The closer must follow text and, for underscores, end a word.
+		closes := text[end-1] != ' ' && (marker != '_' || end+size >= len(text) || !isWordByte(text[end+size]))
+		// A single marker must not be the start of a double one.
+		if size == 1 && end+1 < len(text) && text[end+1] == marker {
+			closes = false
+		}
+		if closes && end > size {
+			tag := "em"
+			if size == 2 {
+				tag = "strong"
+			}
+			out.WriteString("<" + tag + ">" + renderInline(text[size:end]) + "</" + tag + ">")
+			return end + size, true
+		}
+		start = end + size
+		if size == 1 && end+1 < len(text) && text[end+1] == marker {
+			start = end + 2
+		}
+	}
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// safeURL neutralizes link destinations with script-capable schemes.
+func safeURL(dest string) string {
+	scheme, _, hasScheme := strings.Cut(dest, ":")
+	if !hasScheme || strings.ContainsAny(scheme, "/?#") {
+		return dest
+	}
+	switch strings.ToLower(scheme) {
+	case "http", "https", "mailto":
+		return dest
+	}
+	return "#"
+}
+
+// Syntax highlighting
+//
+// highlight wraps keywords, strings, comments and numbers of a code block in
+// spans styled by the page template. It is a lexical approximation, not a
+// parser, which is all a documentation page needs.
+
+type highlightLanguage struct {
+	keywords     map[string]bool
+	lineComment  string
+	blockComment [2]string
+	stringQuotes string
+}
+
+func keywordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+var cLikeBlockComment = [2]string{"/*", "*/"}
+
+var highlightLanguages = map[string]*highlightLanguage{
+	"go": {
+		keywords:     keywordSet("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false"),
+		lineComment:  "//",
+		blockComment: cLikeBlockComment,
+		stringQuotes: "\"'`",
+	},
+	"javascript": {
+		keywords:     keywordSet("async await break case catch class const continue default delete do else export extends finally for function if import in instanceof let new null return super switch this throw try typeof undefined var void while yield true false"),
+		lineComment:  "//",
+		blockComment: cLikeBlockComment,
+		stringQuotes: "\"'`",
+	},
+	"python": {
+		keywords:     keywordSet("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False"),
+		lineComment:  "#",
+		stringQuotes: "\"'",
+	},
+	"sh": {
+		keywords:     keywordSet("if then else elif fi for while until do done case esac function in return export local"),
+		lineComment:  "#",
+		stringQuotes: "\"'",
+	},
+	"json": {
+		keywords:     keywordSet("true false null"),
+		stringQuotes: "\"",
+	},
+}
+
+func init() {
+	highlightLanguages["js"] = highlightLanguages["javascript"]
+	highlightLanguages["ts"] = highlightLanguages["javascript"]
+	highlightLanguages["typescript"] = highlightLanguages["javascript"]
+	highlightLanguages["py"] = highlightLanguages["python"]
+	highlightLanguages["bash"] = highlightLanguages["sh"]
+	highlightLanguages["shell"] = highlightLanguages["sh"]
+	highlightLanguages["golang"] = highlightLanguages["go"]
+}
+
+func highlight(code, lang string) string {
+	language, ok := highlightLanguages[lang]
+	if !ok {
+		return html.EscapeString(code)
+	}
+
+	var out strings.Builder
+	span := func(class, text string) {
+		out.WriteString(`<span class="` + class + `">` + html.EscapeString(text) + `</span>`)
+	}
+
+	for i := 0; i < len(code); {
+		rest := code[i:]
+		c := code[i]
+		switch {
+		case language.lineComment != "" && strings.HasPrefix(rest, language.lineComment):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			span("com", rest[:end])
+			i += end
+
+		case language.blockComment[0] != "" && strings.HasPrefix(rest, language.blockComment[0]):
+			end := strings.Index(rest[2:], language.blockComment[1])
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 2 + len(language.blockComment[1])
+			}
+			span("com", rest[:end])
+			i += end
+
+		case strings.IndexByte(language.stringQuotes, c) >= 0:
+			end := 1
+			for end < len(rest) && rest[end] != c {
+				if rest[end] == '\\' && c != '`' {
+					end++
+				} else if rest[end] == '\n' && c != '`' {
+					break
+				}
+				end++
+			}
+			if end < len(rest) && rest[end] == c {
+				end++
+			}
+			if end > len(rest) {
+				end = len(rest)
+			}
+			span("str", rest[:end])
+			i += end
+
+		case c >= '0' && c <= '9' && (i == 0 || !isWordByte(code[i-1])):
+			end := 1
+			for end < len(rest) && (isWordByte(rest[end]) || rest[end] == '.') {
+				end++
+			}
+			span("num", rest[:end])
+			i += end
+
+		case isWordByte(c):
+			end := 1
+			for end < len(rest) && isWordByte(rest[end]) {
+				end++
+			}
+			if language.keywords[rest[:end]] {
+				span("kw", rest[:end])
+			} else {
+				out.WriteString(html.EscapeString(rest[:end]))
+			}
+			i += end
+
+		default:
+			out.WriteString(html.EscapeString(rest[:1]))
+			i++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"mime"
+	"strings"
+)
+
+// methodOverrideHeader and methodOverrideField carry the method a POST
+// request stands in for, from scripts and from HTML forms respectively.
+const (
+	methodOverrideHeader = "X-HTTP-Method-Override"
+	methodOverrideField  = "_method"
+)
+
+// overridableMethods are the methods a POST can be turned into. GET and
+// HEAD requests are never overridden, as a link or image could then
+// trigger a DELETE.
+var overridableMethods = map[HTTPMethod]bool{
+	MethodPut:    true,
+	MethodPatch:  true,
+	MethodDelete: true,
+}
+
+// applyMethodOverride turns a POST into the PUT, PATCH or DELETE named by
+// its X-HTTP-Method-Override header or, for an urlencoded form, its
+// _method field, for clients behind proxies or in browsers that can only
+// send GET and POST. It runs before routing, so the request is matched
+// and authorized under the method it stands for. Multipart forms aren't
+// looked into, as that would read a whole upload before its route's body
+// limit applies.
+func (s *Server) applyMethodOverride(request *HTTPRequest) {
+	if !s.MethodOverride || request.Method != MethodPost {
+		return
+	}
+	method := request.Headers.Get(methodOverrideHeader)
+	if method == "" {
+		mediaType, _, _ := mime.ParseMediaType(request.Headers.Get("Content-Type"))
+		if mediaType != "application/x-www-form-urlencoded" {
+			return
+		}
+		method = request.FormValue(methodOverrideField)
+	}
+	if override := HTTPMethod(strings.ToUpper(method)); overridableMethods[override] {
+		request.Method = override
+	}
+}
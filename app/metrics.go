@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLatencyBuckets are the histogram upper bounds, in seconds.
+var defaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// defaultSizeBuckets are the response size histogram upper bounds, in
+// bytes.
+var defaultSizeBuckets = []float64{100, 1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20, 100 << 20}
+
+// unmatchedRoute labels requests that matched no route, so arbitrary paths
+// from scanners can't blow up the number of series.
+const unmatchedRoute = "<unmatched>"
+
+// Metrics is the server's metrics registry. Requests are keyed by the route
+// pattern that served them, not the raw path, which keeps the number of
+// series bounded by the route table.
+type Metrics struct {
+	buckets     []float64
+	sizeBuckets []float64
+
+	inFlight   atomic.Int64
+	openConns  atomic.Int64
+	totalConns atomic.Uint64
+
+	mu     sync.Mutex
+	routes map[routeKey]*routeMetrics
+}
+
+type routeKey struct {
+	route  string
+	method HTTPMethod
+}
+
+type routeMetrics struct {
+	count     uint64
+	errors4xx uint64
+	errors5xx uint64
+	sum       float64
+	buckets   []uint64 // cumulative counts per upper bound
+	codes     map[int]uint64
+
+	sizeSum     int64
+	sizeBuckets []uint64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buckets:     defaultLatencyBuckets,
+		sizeBuckets: defaultSizeBuckets,
+		routes:      make(map[routeKey]*routeMetrics),
+	}
+}
+
+// connOpened and connClosed track the client connections being served.
+func (m *Metrics) connOpened() {
+	m.openConns.Add(1)
+	m.totalConns.Add(1)
+}
+
+func (m *Metrics) connClosed() {
+	m.openConns.Add(-1)
+}
+
+func (m *Metrics) observe(route string, method HTTPMethod, status StatusCode, latency time.Duration, size int64) {
+	if route == "" {
+		route = unmatchedRoute
+	}
+	key := routeKey{route: route, method: method}
+	seconds := latency.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rm, ok := m.routes[key]
+	if !ok {
+		rm = &routeMetrics{
+			buckets:     make([]uint64, len(m.buckets)),
+			codes:       make(map[int]uint64),
+			sizeBuckets: make([]uint64, len(m.sizeBuckets)),
+		}
+		m.routes[key] = rm
+	}
+	rm.count++
+	rm.sum += seconds
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			rm.buckets[i]++
+		}
+	}
+	rm.sizeSum += size
+	for i, bound := range m.sizeBuckets {
+		if float64(size) <= bound {
+			rm.sizeBuckets[i]++
+		}
+	}
+	code := status.Code()
+	rm.codes[code]++
+	switch {
+	case code >= 500:
+		rm.errors5xx++
+	case code >= 400:
+		rm.errors4xx++
+	}
+}
+
+// sortedKeys returns the registry keys in a stable order. The caller must
+// hold m.mu.
+func (m *Metrics) sortedKeys() []routeKey {
+	keys := make([]routeKey, 0, len(m.routes))
+	for key := range m.routes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+	return keys
+}
+
+// prometheus renders the registry in the Prometheus text exposition format.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+func (m *Metrics) prometheus() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := m.sortedKeys()
+
+	var b strings.Builder
+	b.WriteString("# HELP nethttp_requests_total Requests served by route pattern, method and status code.\n")
+	b.WriteString("# TYPE nethttp_requests_total counter\n")
+	for _, key := range keys {
+		rm := m.routes[key]
+		codes := make([]int, 0, len(rm.codes))
+		for code := range rm.codes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(&b, "nethttp_requests_total{%s,code=\"%d\"} %d\n", key.labels(), code, rm.codes[code])
+		}
+	}
+
+	b.WriteString("# HELP nethttp_request_duration_seconds Request latency by route pattern.\n")
+	b.WriteString("# TYPE nethttp_request_duration_seconds histogram\n")
+	for _, key := range keys {
+		rm := m.routes[key]
+		writeHistogram(&b, "nethttp_request_duration_seconds", key.labels(), m.buckets, rm.buckets, rm.sum, rm.count)
+	}
+
+	b.WriteString("# HELP nethttp_response_size_bytes Response body size by route pattern.\n")
+	b.WriteString("# TYPE nethttp_response_size_bytes histogram\n")
+	for _, key := range keys {
+		rm := m.routes[key]
+		writeHistogram(&b, "nethttp_response_size_bytes", key.labels(), m.sizeBuckets, rm.sizeBuckets, float64(rm.sizeSum), rm.count)
+	}
+
+	b.WriteString("# HELP nethttp_request_errors_total Responses with a 4xx or 5xx status by route pattern.\n")
+	b.WriteString("# TYPE nethttp_request_errors_total counter\n")
+	for _, key := range keys {
+		rm := m.routes[key]
+		fmt.Fprintf(&b, "nethttp_request_errors_total{%s,class=\"4xx\"} %d\n", key.labels(), rm.errors4xx)
+		fmt.Fprintf(&b, "nethttp_request_errors_total{%s,class=\"5xx\"} %d\n", key.labels(), rm.errors5xx)
+	}
+
+	b.WriteString("# HELP nethttp_requests_in_flight Requests currently being served.\n")
+	b.WriteString("# TYPE nethttp_requests_in_flight gauge\n")
+	fmt.Fprintf(&b, "nethttp_requests_in_flight %d\n", m.inFlight.Load())
+	b.WriteString("# HELP nethttp_open_connections Client connections currently open.\n")
+	b.WriteString("# TYPE nethttp_open_connections gauge\n")
+	fmt.Fprintf(&b, "nethttp_open_connections %d\n", m.openConns.Load())
+	b.WriteString("# HELP nethttp_connections_total Client connections accepted.\n")
+	b.WriteString("# TYPE nethttp_connections_total counter\n")
+	fmt.Fprintf(&b, "nethttp_connections_total %d\n", m.totalConns.Load())
+	return b.String()
+}
+
+func (k routeKey) labels() string {
+	return fmt.Sprintf(`route="%s",method="%s"`, escapeLabel(k.route), escapeLabel(string(k.method)))
+}
+
+// writeHistogram writes one series of a histogram, given the cumulative
+// counts for each of its upper bounds.
+func writeHistogram(b *strings.Builder, name, labels string, bounds []float64, counts []uint64, sum float64, count uint64) {
+	for i, bound := range bounds {
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bound, counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, count)
+	fmt.Fprintf(b, "%s_sum{%s} %g\n", name, labels, sum)
+	fmt.Fprintf(b, "%s_count{%s} %d\n", name, labels, count)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// routeVars is the /debug/vars view of one route.
+type routeVars struct {
+	Route         string  `json:"route"`
+	Method        string  `json:"method"`
+	Count         uint64  `json:"count"`
+	Errors4xx     uint64  `json:"errors_4xx"`
+	Errors5xx     uint64  `json:"errors_5xx"`
+	ErrorRate     float64 `json:"error_rate"`
+	MeanLatencyMs float64 `json:"mean_latency_ms"`
+}
+
+func (m *Metrics) vars() []routeVars {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	vars := []routeVars{}
+	for _, key := range m.sortedKeys() {
+		rm := m.routes[key]
+		v := routeVars{
+			Route:     key.route,
+			Method:    string(key.method),
+			Count:     rm.count,
+			Errors4xx: rm.errors4xx,
+			Errors5xx: rm.errors5xx,
+		}
+		if rm.count > 0 {
+			v.ErrorRate = float64(rm.errors4xx+rm.errors5xx) / float64(rm.count)
+			v.MeanLatencyMs = rm.sum / float64(rm.count) * 1000
+		}
+		vars = append(vars, v)
+	}
+	return vars
+}
+
+func (s *Server) handleMetrics(w *ResponseWriter, _ *HTTPRequest, _ map[string]string) error {
+	s.sendResponse(w, StatusOK, ContentTypePrometheus, s.Metrics.prometheus())
+	return nil
+}
+
+// handleDebugVars serves the expvar variables, as net/http's expvar handler
+// would, with the per-route metrics added under "routes".
+func (s *Server) handleDebugVars(w *ResponseWriter, _ *HTTPRequest, _ map[string]string) error {
+	var b strings.Builder
+	b.WriteString("{\n")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(&b, "%q: %s,\n", kv.Key, kv.Value)
+	})
+	routes, err := json.Marshal(s.Metrics.vars())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&b, "%q: %s\n}\n", "routes", routes)
+	s.sendResponse(w, StatusOK, ContentTypeApplicationJSON, b.String())
+	return nil
+}
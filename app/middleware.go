@@ -0,0 +1,30 @@
+package main
+
+import "slices"
+
+// Middleware wraps a HandlerFunc with behaviour that runs around it.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Chain wraps handler in middleware so that the first one listed runs
+// first, i.e. Chain(h, a, b) calls a, then b, then h.
+func Chain(handler HandlerFunc, middleware ...Middleware) HandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// OnMethods applies mw only to requests using one of methods and passes
+// every other request straight through, e.g. to guard writes to a route
+// while leaving reads open.
+func OnMethods(mw Middleware, methods ...HTTPMethod) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		guarded := mw(next)
+		return func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+			if slices.Contains(methods, request.Method) {
+				return guarded(w, request, params)
+			}
+			return next(w, request, params)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a file sniffContentType looks at.
+const sniffLen = 512
+
+// contentTypes maps file extensions, lower-cased and with their leading
+// dot, to the Content-Type files are served with. It is consulted before
+// the system's MIME table and filled in further by RegisterContentType.
+var contentTypes = map[string]ContentType{
+	".txt":   "text/plain; charset=utf-8",
+	".md":    ContentTypeMarkdown,
+	".js":    "text/javascript; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".map":   ContentTypeApplicationJSON,
+	".wasm":  "application/wasm",
+	".ico":   "image/vnd.microsoft.icon",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+}
+
+// RegisterContentType sets the Content-Type files with extension ext, e.g.
+// ".webmanifest", are served with. It must be called before the server
+// starts.
+func RegisterContentType(ext string, contentType ContentType) {
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	contentTypes[strings.ToLower(ext)] = contentType
+}
+
+// contentTypeFor picks the Content-Type of a file called name from its
+// extension. Files with no known extension are sniffed from head, the start
+// of their content, when the server has SniffContentType set, and are
+// application/octet-stream otherwise.
+func (s *Server) contentTypeFor(name string, head []byte) ContentType {
+	ext := strings.ToLower(path.Ext(name))
+	if contentType, ok := contentTypes[ext]; ok {
+		return contentType
+	}
+	if byExtension := mime.TypeByExtension(ext); byExtension != "" {
+		return ContentType(byExtension)
+	}
+	if s.SniffContentType && head != nil {
+		return sniffContentType(head)
+	}
+	return ContentTypeOctetStream
+}
+
+// readHead reads up to sniffLen bytes from the start of r, for
+// contentTypeFor.
+func readHead(r io.ReaderAt) []byte {
+	head := make([]byte, sniffLen)
+	n, _ := r.ReadAt(head, 0)
+	return head[:n]
+}
+
+// magicNumbers are the signatures sniffContentType recognises binary
+// formats by.
+var magicNumbers = []struct {
+	prefix      string
+	contentType ContentType
+}{
+	{"\x89PNG\r\n\x1a\n", "image/png"},
+	{"\xff\xd8\xff", "image/jpeg"},
+	{"GIF87a", "image/gif"},
+	{"GIF89a", "image/gif"},
+	{"%PDF-", "application/pdf"},
+	{"PK\x03\x04", ContentTypeZip},
+	{"\x1f\x8b\x08", "application/gzip"},
+	{"\x00asm", "application/wasm"},
+	{"OggS\x00", "application/ogg"},
+	{"ID3", "audio/mpeg"},
+	{"wOF2", "font/woff2"},
+	{"wOFF", "font/woff"},
+}
+
+// htmlPrefixes are the openings, after whitespace and ignoring case, that
+// mark a document as HTML.
+var htmlPrefixes = []string{"<!doctype html", "<html", "<head", "<body", "<script", "<title", "<!--"}
+
+// sniffContentType guesses the Content-Type of content from its first
+// bytes: well-known binary signatures, HTML and XML openings, and valid
+// UTF-8 without control characters as plain text. Anything else is
+// application/octet-stream. It is a small subset of the WHATWG MIME
+// sniffing algorithm.
+func sniffContentType(content []byte) ContentType {
+	if len(content) > sniffLen {
+		content = content[:sniffLen]
+	}
+	for _, magic := range magicNumbers {
+		if bytes.HasPrefix(content, []byte(magic.prefix)) {
+			return magic.contentType
+		}
+	}
+	if len(content) >= 12 && string(content[:4]) == "RIFF" && string(content[8:12]) == "WEBP" {
+		return "image/webp"
+	}
+
+	text := bytes.ToLower(bytes.TrimLeft(content, "\t\n\f\r "))
+	for _, prefix := range htmlPrefixes {
+		if bytes.HasPrefix(text, []byte(prefix)) {
+			return ContentTypeHTML
+		}
+	}
+	if bytes.HasPrefix(text, []byte("<?xml")) {
+		return "text/xml; charset=utf-8"
+	}
+
+	// The cut at sniffLen may have split the last character.
+	for i := 0; i < utf8.UTFMax-1 && len(content) > 0 && !utf8.Valid(content); i++ {
+		content = content[:len(content)-1]
+	}
+	if !utf8.Valid(content) {
+		return ContentTypeOctetStream
+	}
+	for _, b := range content {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' {
+			return ContentTypeOctetStream
+		}
+	}
+	return "text/plain; charset=utf-8"
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// MmapCache serves large read-only files straight from shared memory
+// mappings instead of reading them into a fresh buffer for every request.
+// Mappings are reference-counted and cached until the file changes: a
+// mapping whose file no longer matches its size and modification time, or
+// that has been invalidated, is unmapped once the last response using it
+// has been written.
+type MmapCache struct {
+	// MinSize is the smallest file, in bytes, that is mapped. Smaller
+	// files are cheaper to read than to map.
+	MinSize int64
+
+	mu    sync.Mutex
+	files map[string]*mappedFile
+}
+
+type mappedFile struct {
+	data    []byte
+	size    int64
+	modTime time.Time
+	refs    int
+	stale   bool
+}
+
+// NewMmapCache returns a cache that maps files of at least minSize bytes.
+func NewMmapCache(minSize int64) *MmapCache {
+	return &MmapCache{MinSize: minSize, files: make(map[string]*mappedFile)}
+}
+
+// acquire returns the contents of the file at path, which info describes,
+// from a shared mapping, along with a func that must be called once the
+// contents are no longer used. It reports false for files that are too
+// small to map or when mapping fails, in which case the caller reads the
+// file as usual.
+func (c *MmapCache) acquire(path string, info os.FileInfo) ([]byte, func(), bool) {
+	if info.Size() < c.MinSize || info.Size() == 0 || !info.Mode().IsRegular() {
+		return nil, nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mapped := c.files[path]
+	if mapped != nil && (mapped.size != info.Size() || !mapped.modTime.Equal(info.ModTime())) {
+		c.evictLocked(path, mapped)
+		mapped = nil
+	}
+	if mapped == nil {
+		data, err := mmapFile(path, info.Size())
+		if err != nil {
+			return nil, nil, false
+		}
+		mapped = &mappedFile{data: data, size: info.Size(), modTime: info.ModTime()}
+		c.files[path] = mapped
+	}
+
+	mapped.refs++
+	var once sync.Once
+	return mapped.data, func() { once.Do(func() { c.release(mapped) }) }, true
+}
+
+func (c *MmapCache) release(mapped *mappedFile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	mapped.refs--
+	if mapped.stale && mapped.refs == 0 {
+		munmapFile(mapped.data)
+	}
+}
+
+// Invalidate drops the cached mapping of path, if any. It is called before
+// the server rewrites a file so that later requests map the new contents.
+func (c *MmapCache) Invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if mapped := c.files[path]; mapped != nil {
+		c.evictLocked(path, mapped)
+	}
+}
+
+func (c *MmapCache) evictLocked(path string, mapped *mappedFile) {
+	delete(c.files, path)
+	mapped.stale = true
+	if mapped.refs == 0 {
+		munmapFile(mapped.data)
+	}
+}
+
+// sendMapped writes a response whose body is a mapped file. A file
+// truncated behind the server's back makes reads from its mapping fault;
+// the fault is turned into a panic so it fails this request rather than
+// the process.
+func (s *Server) sendMapped(w *ResponseWriter, request *HTTPRequest, data []byte, contentType ContentType) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+
+	s.ServeContent(w, request, contentType, bytes.NewReader(data), int64(len(data)))
+}
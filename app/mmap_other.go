@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func mmapFile(string, int64) ([]byte, error) {
+	return nil, errors.New("mmap is not supported on this platform")
+}
+
+func munmapFile([]byte) {}
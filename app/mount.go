@@ -0,0 +1,90 @@
+package main
+
+import "strings"
+
+// Router is a route table built apart from any Server, e.g. by another
+// package, and attached to one under a prefix with Mount. Routes, groups
+// and middleware are registered on it as on a Server, and it can have
+// handlers of its own for the requests under its prefix that match none
+// of its routes or fail.
+type Router struct {
+	router
+
+	// NotFoundHandler, when set, answers requests under the prefix that
+	// match no route, inside the router's middleware.
+	NotFoundHandler HandlerFunc
+
+	// MethodNotAllowedHandler, when set, answers requests whose route has
+	// no handler for their method. The Allow header is already set.
+	MethodNotAllowedHandler HandlerFunc
+
+	// ErrorRenderer, when set, answers requests whose handler returned an
+	// error in place of the Server's error handling.
+	ErrorRenderer func(w *ResponseWriter, request *HTTPRequest, err error)
+}
+
+func NewRouter() *Router {
+	return &Router{router: newRouter()}
+}
+
+// Mount attaches sub under prefix, so that sub's route "/users/:id"
+// serves prefix+"/users/:id". Requests under prefix are matched against
+// sub's routes with the prefix removed, and served inside the middleware
+// of the router mounted on, then sub's own. The request's Path is left
+// as the client sent it.
+func (r *router) Mount(prefix string, sub *Router) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	handler := sub.mounted(prefix)
+	r.HandleFunc(prefix, handler)
+	r.HandleFunc(prefix+"/*mountpath", handler)
+}
+
+func (m *Router) mounted(prefix string) HandlerFunc {
+	return func(w *ResponseWriter, request *HTTPRequest, _ map[string]string) error {
+		rest := strings.TrimPrefix(request.RawPath, prefix)
+		if rest == "" {
+			rest = "/"
+		}
+		params := make(map[string]string)
+		rt := m.match(rest, params)
+		if rt == nil {
+			return m.serve(w, request, orStatus(m.NotFoundHandler, StatusNotFound), params)
+		}
+		request.Route = prefix + rt.pattern
+
+		handler, ok := rt.handler(request.Method)
+		if !ok {
+			w.Header().Set("Allow", rt.allow())
+			if request.Method == MethodOptions {
+				w.server.sendEmpty(w, StatusNoContent)
+				return nil
+			}
+			return m.serve(w, request, orStatus(m.MethodNotAllowedHandler, StatusMethodNotAllowed), params)
+		}
+		if rt.maxBody != 0 && !w.server.limitBody(w, request, rt) {
+			return nil
+		}
+		return m.serve(w, request, handler, params)
+	}
+}
+
+// serve calls handler wrapped in the router's middleware, leaving any
+// error to ErrorRenderer if one is set and the response hasn't started.
+func (m *Router) serve(w *ResponseWriter, request *HTTPRequest, handler HandlerFunc, params map[string]string) error {
+	err := Chain(handler, m.middleware...)(w, request, params)
+	if err != nil && m.ErrorRenderer != nil && !w.wroteHeader {
+		m.ErrorRenderer(w, request, err)
+		return nil
+	}
+	return err
+}
+
+// orStatus returns handler, or if it is nil one failing with status.
+func orStatus(handler HandlerFunc, status StatusCode) HandlerFunc {
+	if handler != nil {
+		return handler
+	}
+	return func(*ResponseWriter, *HTTPRequest, map[string]string) error {
+		return &HTTPError{Code: status}
+	}
+}
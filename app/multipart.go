@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultMultipartMemory is how much of a multipart form is held in memory
+// when MultipartForm is called with a maxMemory of zero or less.
+const defaultMultipartMemory = 32 << 20
+
+// MultipartForm parses a multipart/form-data body into its fields and
+// files. File contents beyond maxMemory in total are streamed to temporary
+// files, which are removed once the request has been served. Later calls
+// return the same form. Failures are returned as an *HTTPError: 415 for a
+// body that isn't multipart/form-data, 413 for one that is too large and
+// 400 for one that doesn't parse.
+func (r *HTTPRequest) MultipartForm(maxMemory int64) (*multipart.Form, error) {
+	if r.multipartForm != nil {
+		return r.multipartForm, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, &HTTPError{
+			Code:    StatusUnsupportedMediaType,
+			Message: fmt.Sprintf("unsupported content type %q", r.Headers.Get("Content-Type")),
+		}
+	}
+	if params["boundary"] == "" {
+		return nil, &HTTPError{Code: StatusBadRequest, Message: "multipart body has no boundary"}
+	}
+	if maxMemory <= 0 {
+		maxMemory = defaultMultipartMemory
+	}
+
+	form, err := multipart.NewReader(r.Body, params["boundary"]).ReadForm(maxMemory)
+	switch {
+	case errors.Is(err, errBodyTooLarge), errors.Is(err, errChunkedBodyTooLarge), errors.Is(err, multipart.ErrMessageTooLarge):
+		return nil, &HTTPError{Code: StatusPayloadTooLarge, Err: err}
+	case err != nil:
+		return nil, &HTTPError{Code: StatusBadRequest, Message: "invalid multipart body: " + err.Error(), Err: err}
+	}
+	r.multipartForm = form
+	return form, nil
+}
+
+// removeFormFiles deletes the temporary files of a parsed multipart form.
+func (r *HTTPRequest) removeFormFiles() {
+	if r.multipartForm == nil {
+		return
+	}
+	if err := r.multipartForm.RemoveAll(); err != nil {
+		defaultLogger("http").Error("Failed to remove multipart files", "err", err)
+	}
+}
+
+// isMultipartForm reports whether request carries a multipart/form-data
+// body.
+func isMultipartForm(request *HTTPRequest) bool {
+	mediaType, _, _ := mime.ParseMediaType(request.Headers.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+type savedFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	ETag string `json:"etag"`
+}
+
+// saveFormFiles handles a browser form upload to /files/. Every file in the
+// form is stored under its own name in the directory the URL names; a
+// form with a single file posted to a file's URL is stored as that file.
+// The names saved are listed in the 201 response.
+func (s *Server) saveFormFiles(w *ResponseWriter, request *HTTPRequest, filename, filePath string) error {
+	form, err := request.MultipartForm(0)
+	if err != nil {
+		return err
+	}
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	var files []*multipart.FileHeader
+	for _, field := range fields {
+		files = append(files, form.File[field]...)
+	}
+	if len(files) == 0 {
+		return &HTTPError{Code: StatusBadRequest, Message: "form has no files"}
+	}
+
+	intoDir := filename == "" || strings.HasSuffix(request.Path, "/")
+	if info, err := os.Stat(filePath); err == nil && info.IsDir() {
+		intoDir = true
+	}
+	if !intoDir && len(files) > 1 {
+		return &HTTPError{Code: StatusBadRequest, Message: "several files can only be posted to a directory"}
+	}
+
+	saved := make([]savedFile, 0, len(files))
+	for _, header := range files {
+		name, target := filename, filePath
+		if intoDir {
+			// The name is joined unclean so that safeJoin sees a ".." base
+			// and refuses it, rather than path.Join resolving it into the
+			// directory's parent.
+			base := path.Base(strings.ReplaceAll(header.Filename, `\`, "/"))
+			var ok bool
+			if name, target, ok = s.resolveFilePath(filename + "/" + base); !ok || name == filename {
+				return &HTTPError{Code: StatusForbidden, Message: fmt.Sprintf("invalid file name %q", header.Filename)}
+			}
+		}
+		s.logger("files").Info("Writing file", "path", target)
+
+		info, err := s.saveFormFile(request, header, name, target)
+		if err != nil {
+			return err
+		}
+		saved = append(saved, savedFile{Name: name, Size: info.Size(), ETag: fileETag(info)})
+	}
+	return s.WriteJSON(w, StatusCreated, saved)
+}
+
+// saveFormFile stores one file of a form upload as filePath, the same way
+// as a plain upload.
+func (s *Server) saveFormFile(request *HTTPRequest, header *multipart.FileHeader, filename, filePath string) (os.FileInfo, error) {
+	src, err := header.Open()
+	if err != nil {
+		return nil, fmt.Errorf("opening form file: %w", err)
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, fmt.Errorf("creating directories: %w", err)
+	}
+	tempPath, info, err := writeTempFileContext(request.Context(), filepath.Dir(filePath), src, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("writing file: %w", err)
+	}
+	defer os.Remove(tempPath)
+	if _, err := s.replaceFile(request, filename, filePath, tempPath); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveFormFilesNames(t *testing.T) {
+	dir := t.TempDir()
+	filesDir := filepath.Join(dir, "files")
+	if err := os.MkdirAll(filepath.Join(filesDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	s.FilesDir = filesDir
+	s.setupRoutes()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serve(listener)
+	defer listener.Close()
+
+	tests := []struct {
+		filename string
+		status   int
+		saved    string
+	}{
+		{"a.txt", 201, "sub/a.txt"},
+		{`dir\b.txt`, 201, "sub/b.txt"},
+		{"../c.txt", 201, "sub/c.txt"},
+		{"..", 403, ""},
+		{"../..", 403, ""},
+		{".", 403, ""},
+		{"/", 403, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			var form bytes.Buffer
+			mw := multipart.NewWriter(&form)
+			part, err := mw.CreateFormFile("file", tt.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(part, "content")
+			mw.Close()
+
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			fmt.Fprintf(conn, "POST /files/sub/ HTTP/1.1\r\nHost: t\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", mw.FormDataContentType(), form.Len())
+			conn.Write(form.Bytes())
+			response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", response.StatusCode, tt.status)
+			}
+			if tt.saved != "" {
+				if _, err := os.Stat(filepath.Join(filesDir, filepath.FromSlash(tt.saved))); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(dir, "files")); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("files written outside the files directory: %v", entries)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// qualityValue is one entry of a header like Accept or Accept-Encoding.
+type qualityValue struct {
+	value   string
+	quality float64
+}
+
+// parseQualityList splits a comma-separated header into its values and
+// their q-values. Entries without a q parameter default to 1.
+// https://developer.mozilla.org/en-US/docs/Glossary/Quality_values
+func parseQualityList(header string) []qualityValue {
+	var values []qualityValue
+	for _, entry := range strings.Split(header, ",") {
+		value, params, _ := strings.Cut(entry, ";")
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, raw, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(raw), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		values = append(values, qualityValue{value: value, quality: quality})
+	}
+	return values
+}
+
+// negotiateMediaType picks the offer the Accept header rates highest,
+// preferring earlier offers on ties. A missing Accept header accepts
+// anything, so the first offer wins; it returns "" if nothing is acceptable.
+func negotiateMediaType(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	accepted := parseQualityList(accept)
+	best, bestQuality := "", 0.0
+	for _, offer := range offers {
+		quality, specificity := 0.0, -1
+		for _, a := range accepted {
+			// More specific ranges override broader ones: text/html beats
+			// text/* beats */*.
+			s := mediaRangeSpecificity(a.value, offer)
+			if s > specificity {
+				quality, specificity = a.quality, s
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	return best
+}
+
+// mediaRangeSpecificity reports how specifically mediaRange matches
+// mediaType: 2 for an exact match, 1 for type/*, 0 for */*, and -1 for no
+// match.
+func mediaRangeSpecificity(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+	return -1
+}
+
+// negotiateEncoding picks the content coding of offers the Accept-Encoding
+// header rates highest, preferring earlier offers on ties. "*" rates every
+// coding the header doesn't name. It returns "identity" when no offer beats
+// sending the body as is, and "" when not even that is acceptable, i.e.
+// the header sets identity;q=0, or *;q=0 without naming identity.
+// https://www.rfc-editor.org/rfc/rfc9110#field.accept-encoding
+func negotiateEncoding(acceptEncoding string, offers ...string) string {
+	accepted := parseQualityList(acceptEncoding)
+	rate := func(coding string) (float64, bool) {
+		wildcard, hasWildcard := 0.0, false
+		for _, a := range accepted {
+			switch a.value {
+			case coding:
+				return a.quality, true
+			case "*":
+				wildcard, hasWildcard = a.quality, true
+			}
+		}
+		return wildcard, hasWildcard
+	}
+
+	best, bestQuality := "", 0.0
+	for _, offer := range offers {
+		if quality, ok := rate(offer); ok && quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+
+	identity, ok := rate("identity")
+	if !ok {
+		// Identity is acceptable unless refused outright.
+		identity = 0.001
+	}
+	if best != "" && bestQuality >= identity {
+		return best
+	}
+	if identity > 0 {
+		return "identity"
+	}
+	return ""
+}
+
+// negotiateContentEncoding picks the coding to send request's response in,
+// as negotiateEncoding does, and adds Accept-Encoding to the response's
+// Vary header since the choice depends on it.
+func negotiateContentEncoding(w *ResponseWriter, request *HTTPRequest, offers ...string) string {
+	addVary(w.Header(), "Accept-Encoding")
+	return negotiateEncoding(strings.Join(request.Headers.Values("Accept-Encoding"), ","), offers...)
+}
+
+// addVary adds field to the Vary header of h, unless it is already listed.
+func addVary(h Header, field string) {
+	vary := h.Get("Vary")
+	if headerHasToken(vary, field) || headerHasToken(vary, "*") {
+		return
+	}
+	if vary != "" {
+		vary += ", "
+	}
+	h.Set("Vary", vary+field)
+}
@@ -0,0 +1,110 @@
+package main
+
+import "time"
+
+// Option configures a Server in NewServer.
+type Option func(*Server)
+
+// Timeouts groups the server's timeouts. Zero values keep the defaults
+// documented on the Server fields of the same names.
+type Timeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	Handler    time.Duration
+	Drain      time.Duration
+}
+
+// Limits groups the server's size and count limits. Zero values keep the
+// defaults documented on the Server fields of the same names.
+type Limits struct {
+	MaxBodyBytes   int64
+	MaxHeaderBytes int
+	MaxHeaderCount int
+	MaxConns       int
+}
+
+// Config is the server's core configuration in one struct, for callers
+// that assemble it from flags or a file. Zero fields keep their defaults.
+type Config struct {
+	Addr         string
+	FilesDir     string
+	FileVersions int
+	TLSCertFile  string
+	TLSKeyFile   string
+	Timeouts     Timeouts
+	Limits       Limits
+}
+
+// WithAddr sets the address to listen on, e.g. ":8080" or
+// "127.0.0.1:4221".
+func WithAddr(addr string) Option {
+	return func(s *Server) { s.Addr = addr }
+}
+
+// WithFilesDir sets the directory the /files API serves.
+func WithFilesDir(dir string) Option {
+	return func(s *Server) { s.FilesDir = dir }
+}
+
+// WithFileVersions sets how many previous versions of each file to keep.
+func WithFileVersions(n int) Option {
+	return func(s *Server) { s.FileVersions = n }
+}
+
+// WithTLS serves HTTPS with certFile and keyFile as the default
+// certificate.
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *Server) {
+		s.TLSCertFile = certFile
+		s.TLSKeyFile = keyFile
+	}
+}
+
+// WithLogger sets the logger the server writes its records to.
+func WithLogger(logger Logger) Option {
+	return func(s *Server) { s.Logger = logger }
+}
+
+// WithTimeouts sets the non-zero timeouts of t.
+func WithTimeouts(t Timeouts) Option {
+	return func(s *Server) {
+		setIfNonZero(&s.ReadHeaderTimeout, t.ReadHeader)
+		setIfNonZero(&s.ReadTimeout, t.Read)
+		setIfNonZero(&s.WriteTimeout, t.Write)
+		setIfNonZero(&s.IdleTimeout, t.Idle)
+		setIfNonZero(&s.HandlerTimeout, t.Handler)
+		setIfNonZero(&s.DrainTimeout, t.Drain)
+	}
+}
+
+// WithLimits sets the non-zero limits of l.
+func WithLimits(l Limits) Option {
+	return func(s *Server) {
+		setIfNonZero(&s.MaxBodyBytes, l.MaxBodyBytes)
+		setIfNonZero(&s.MaxHeaderBytes, l.MaxHeaderBytes)
+		setIfNonZero(&s.MaxHeaderCount, l.MaxHeaderCount)
+		setIfNonZero(&s.MaxConns, l.MaxConns)
+	}
+}
+
+// WithConfig applies the non-zero fields of config.
+func WithConfig(config Config) Option {
+	return func(s *Server) {
+		setIfNonZero(&s.Addr, config.Addr)
+		setIfNonZero(&s.FilesDir, config.FilesDir)
+		setIfNonZero(&s.FileVersions, config.FileVersions)
+		setIfNonZero(&s.TLSCertFile, config.TLSCertFile)
+		setIfNonZero(&s.TLSKeyFile, config.TLSKeyFile)
+		WithTimeouts(config.Timeouts)(s)
+		WithLimits(config.Limits)(s)
+	}
+}
+
+func setIfNonZero[T comparable](field *T, value T) {
+	var zero T
+	if value != zero {
+		*field = value
+	}
+}
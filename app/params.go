@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Params are the values a route's params matched, by name. Handlers take
+// them as a plain map; converting it gives access to typed values:
+//
+//	id, err := Params(params).Int("id")
+type Params map[string]string
+
+// Int returns the named param as an int. A param that is missing or isn't
+// an integer fails with 404, as the path names nothing the route serves.
+func (p Params) Int(name string) (int, error) {
+	n, err := strconv.Atoi(p[name])
+	if err != nil {
+		return 0, &HTTPError{Code: StatusNotFound, Message: fmt.Sprintf("%s must be an integer", name), Err: err}
+	}
+	return n, nil
+}
+
+// Int64 is Int for 64-bit values.
+func (p Params) Int64(name string) (int64, error) {
+	n, err := strconv.ParseInt(p[name], 10, 64)
+	if err != nil {
+		return 0, &HTTPError{Code: StatusNotFound, Message: fmt.Sprintf("%s must be an integer", name), Err: err}
+	}
+	return n, nil
+}
+
+// paramTypes are the named constraints a param can be given as
+// ":name<type>".
+var paramTypes = map[string]*regexp.Regexp{
+	"int":   regexp.MustCompile(`^-?[0-9]+$`),
+	"uint":  regexp.MustCompile(`^[0-9]+$`),
+	"alpha": regexp.MustCompile(`^[A-Za-z]+$`),
+	"alnum": regexp.MustCompile(`^[A-Za-z0-9]+$`),
+	"uuid":  regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+}
+
+// parseParamSegment splits a ":param" route segment into the param's name
+// and the constraint its value must match, if any: a regular expression
+// written as ":id(\d+)" or one of paramTypes written as ":id<int>". The
+// expression must match the whole decoded segment, and can't contain a
+// "/" as it would split the segment. It panics on a malformed constraint,
+// a mistake in the route setup.
+func parseParamSegment(pattern, segment string) (string, *regexp.Regexp) {
+	name := segment[1:]
+	switch i := strings.IndexAny(name, "(<"); {
+	case i < 0:
+		return name, nil
+	case name[i] == '(' && strings.HasSuffix(name, ")"):
+		re, err := regexp.Compile(`^(?:` + name[i+1:len(name)-1] + `)$`)
+		if err != nil {
+			panic(fmt.Sprintf("route %q: %v", pattern, err))
+		}
+		return name[:i], re
+	case name[i] == '<' && strings.HasSuffix(name, ">"):
+		re, ok := paramTypes[name[i+1:len(name)-1]]
+		if !ok {
+			panic(fmt.Sprintf("route %q: unknown param type %q", pattern, name[i+1:len(name)-1]))
+		}
+		return name[:i], re
+	default:
+		panic(fmt.Sprintf("route %q: malformed param %q", pattern, segment))
+	}
+}
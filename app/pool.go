@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Request objects, params maps and the buffers requests are read and
+// responses assembled in are pooled and reused across requests.
+// They belong to the server: a handler may use them until it returns, but
+// must not retain the request, its Headers, or the params map afterwards.
+// Anything needed beyond the handler's lifetime has to be copied out.
+var (
+	requestPool = sync.Pool{New: func() any {
+		return &HTTPRequest{Headers: make(Header)}
+	}}
+	paramsPool = sync.Pool{New: func() any {
+		return make(map[string]string)
+	}}
+	readerPool sync.Pool
+	bufferPool = sync.Pool{New: func() any {
+		return new(bytes.Buffer)
+	}}
+)
+
+// maxPooledBufferSize is the largest buffer put back in the pool. Larger
+// ones, grown for an unusually big response, are left to the garbage
+// collector rather than pinned in memory.
+const maxPooledBufferSize = 64 << 10
+
+func acquireRequest() *HTTPRequest {
+	return requestPool.Get().(*HTTPRequest)
+}
+
+// releaseRequest resets request, keeping its headers map for reuse, and
+// returns it to the pool.
+func releaseRequest(request *HTTPRequest) {
+	headers := request.Headers
+	clear(headers)
+	*request = HTTPRequest{Headers: headers}
+	requestPool.Put(request)
+}
+
+// acquireReader returns a buffered reader over r, the one every request on
+// a connection is read through.
+func acquireReader(r io.Reader) *bufio.Reader {
+	if reader, ok := readerPool.Get().(*bufio.Reader); ok {
+		reader.Reset(r)
+		return reader
+	}
+	return bufio.NewReader(r)
+}
+
+// releaseReader returns reader to the pool once its connection is done.
+func releaseReader(reader *bufio.Reader) {
+	reader.Reset(nil)
+	readerPool.Put(reader)
+}
+
+// acquireBuffer returns an empty buffer to assemble a response in.
+func acquireBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// releaseBuffer returns buf to the pool. Nothing written to it may be used
+// afterwards.
+func releaseBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+func acquireParams() map[string]string {
+	return paramsPool.Get().(map[string]string)
+}
+
+func releaseParams(params map[string]string) {
+	clear(params)
+	paramsPool.Put(params)
+}
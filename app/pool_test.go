@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// BenchmarkServeRequest measures requests served one after another on a
+// kept-alive connection, allocations of the client and server together.
+// Run with -benchmem to see what the pools save per request.
+func BenchmarkServeRequest(b *testing.B) {
+	benchmarks := []struct {
+		name    string
+		request string
+	}{
+		{"index", "GET / HTTP/1.1\r\nHost: bench\r\n\r\n"},
+		{"echo", "GET /echo/hello HTTP/1.1\r\nHost: bench\r\n\r\n"},
+		{"echo gzip", "GET /echo/" + strings.Repeat("hello", 40) + " HTTP/1.1\r\nHost: bench\r\nAccept-Encoding: gzip\r\n\r\n"},
+		{"user-agent", "GET /user-agent HTTP/1.1\r\nHost: bench\r\nUser-Agent: bench/1.0\r\n\r\n"},
+	}
+	s := NewServer(WithLogger(slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError}))))
+	s.setupRoutes()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	go s.serve(listener)
+	defer listener.Close()
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			responses := textproto.NewReader(bufio.NewReader(conn))
+			request := []byte(bm.request)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.Write(request); err != nil {
+					b.Fatal(err)
+				}
+				if err := readBenchResponse(responses); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// readBenchResponse reads a 200 response with a Content-Length body.
+func readBenchResponse(r *textproto.Reader) error {
+	status, err := r.ReadLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(status, "HTTP/1.1 200 ") {
+		return &HTTPError{Code: StatusCode(status)}
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil {
+		return err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return err
+	}
+	_, err = r.R.Discard(length)
+	return err
+}
+
+// BenchmarkResponseBuffer compares assembling a response head in a pooled
+// buffer with allocating a fresh one for every response.
+func BenchmarkResponseBuffer(b *testing.B) {
+	head := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nServer: NetHttp\r\nDate: Wed, 14 Oct 2026 12:00:00 GMT\r\n"
+	write := func(buf *bytes.Buffer) {
+		buf.WriteString(head)
+		buf.WriteString("Content-Length: ")
+		buf.Write(strconv.AppendInt(buf.AvailableBuffer(), 1234, 10))
+		buf.WriteString("\r\n\r\n")
+	}
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := acquireBuffer()
+			write(buf)
+			releaseBuffer(buf)
+		}
+	})
+	b.Run("fresh", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			write(new(bytes.Buffer))
+		}
+	})
+}
+
+// BenchmarkCompressWriter compares compressing a small body with a pooled
+// writer of each built-in encoding against setting up a fresh one.
+func BenchmarkCompressWriter(b *testing.B) {
+	body := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 40))
+	for _, encoding := range []string{"gzip", "br", "zstd"} {
+		// br and zstd are off by default.
+		if encoder, ok := builtinEncoders[encoding]; ok {
+			RegisterEncoding(encoding, encoder)
+		}
+		c := &compressor{encoding: encoding, options: CompressOptions{Level: gzip.DefaultCompression}}
+		b.Run(encoding+"/pooled", func(b *testing.B) {
+			var dst bytes.Buffer
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				dst.Reset()
+				if err := c.compressBytes(&dst, body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(encoding+"/fresh", func(b *testing.B) {
+			var dst bytes.Buffer
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				dst.Reset()
+				var zw io.WriteCloser
+				if encoder, ok := builtinEncoders[encoding]; ok {
+					zw = encoder(&dst)
+				} else {
+					zw, _ = gzip.NewWriterLevel(&dst, gzip.DefaultCompression)
+				}
+				zw.Write(body)
+				if err := zw.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
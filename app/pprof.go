@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxProfileDuration caps the ?seconds of CPU profiles and traces, so that
+// a mistyped request doesn't keep the profiler busy for hours.
+const maxProfileDuration = 5 * time.Minute
+
+var pprofIndexTemplate = template.Must(template.New("pprof").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Prefix}}/</title>
+</head>
+<body>
+<h1>{{.Prefix}}/</h1>
+<table>
+<tr><th>Count</th><th>Profile</th></tr>
+{{range .Profiles}}<tr><td>{{.Count}}</td><td><a href="{{.Name}}?debug=1">{{.Name}}</a></td></tr>
+{{end}}<tr><td></td><td><a href="profile">profile</a> (CPU, ?seconds=30)</td></tr>
+<tr><td></td><td><a href="trace">trace</a> (?seconds=1)</td></tr>
+</table>
+</body>
+</html>
+`))
+
+// Pprof serves the runtime profiles under prefix, in the format `go tool
+// pprof` reads, as net/http/pprof does for net/http servers: prefix/profile
+// samples the CPU for ?seconds, prefix/trace records an execution trace,
+// and prefix/heap, prefix/goroutine, prefix/block and the rest return the
+// named profile, as text with ?debug=1. It turns on block and mutex
+// profiling, which are off by default, at rates cheap enough to leave on
+// under production load.
+//
+// The profiles expose the server's internals, so they belong on a separate
+// admin server that only operators can reach, not the public one.
+func (s *Server) Pprof(prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	runtime.SetBlockProfileRate(int(10 * time.Microsecond))
+	runtime.SetMutexProfileFraction(100)
+
+	s.GET(prefix+"/*profile", func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+		switch name := params["profile"]; name {
+		case "":
+			return s.sendPprofIndex(w, prefix)
+		case "profile":
+			return s.sendCPUProfile(w, request)
+		case "trace":
+			return s.sendTrace(w, request)
+		default:
+			return s.sendProfile(w, request, name)
+		}
+	})
+}
+
+func (s *Server) sendPprofIndex(w *ResponseWriter, prefix string) error {
+	var out bytes.Buffer
+	page := struct {
+		Prefix   string
+		Profiles []*pprof.Profile
+	}{Prefix: prefix, Profiles: pprof.Profiles()}
+	if err := pprofIndexTemplate.Execute(&out, page); err != nil {
+		return fmt.Errorf("rendering profile index: %w", err)
+	}
+	s.sendResponse(w, StatusOK, ContentTypeHTML, out.String())
+	return nil
+}
+
+func (s *Server) sendProfile(w *ResponseWriter, request *HTTPRequest, name string) error {
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		return &HTTPError{Code: StatusNotFound, Message: "unknown profile"}
+	}
+	debug, _ := strconv.Atoi(request.Query.Get("debug"))
+	if name == "heap" && request.Query.Get("gc") != "" {
+		runtime.GC()
+	}
+
+	var out bytes.Buffer
+	if err := profile.WriteTo(&out, debug); err != nil {
+		return fmt.Errorf("writing %s profile: %w", name, err)
+	}
+	contentType := ContentTypeOctetStream
+	if debug > 0 {
+		contentType = ContentTypePlainText
+	}
+	s.sendResponse(w, StatusOK, contentType, out.String())
+	return nil
+}
+
+// sendCPUProfile profiles the CPU for ?seconds, 30 by default, and sends
+// the result.
+func (s *Server) sendCPUProfile(w *ResponseWriter, request *HTTPRequest) error {
+	duration, err := profileDuration(request, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := pprof.StartCPUProfile(&out); err != nil {
+		return &HTTPError{Code: StatusServiceUnavailable, Message: "CPU profiling is already in progress", Err: err}
+	}
+	if !sleepContext(request, duration) {
+		pprof.StopCPUProfile()
+		return request.Context().Err()
+	}
+	pprof.StopCPUProfile()
+	s.sendResponse(w, StatusOK, ContentTypeOctetStream, out.String())
+	return nil
+}
+
+// sendTrace records an execution trace for ?seconds, 1 by default, and
+// sends it for `go tool trace`.
+func (s *Server) sendTrace(w *ResponseWriter, request *HTTPRequest) error {
+	duration, err := profileDuration(request, time.Second)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := trace.Start(&out); err != nil {
+		return &HTTPError{Code: StatusServiceUnavailable, Message: "tracing is already in progress", Err: err}
+	}
+	if !sleepContext(request, duration) {
+		trace.Stop()
+		return request.Context().Err()
+	}
+	trace.Stop()
+	s.sendResponse(w, StatusOK, ContentTypeOctetStream, out.String())
+	return nil
+}
+
+func profileDuration(request *HTTPRequest, fallback time.Duration) (time.Duration, error) {
+	value := request.Query.Get("seconds")
+	if value == "" {
+		return fallback, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		return 0, &HTTPError{Code: StatusBadRequest, Message: "invalid seconds", Err: err}
+	}
+	return min(time.Duration(seconds*float64(time.Second)), maxProfileDuration), nil
+}
+
+// sleepContext waits for duration, reporting false if the request is
+// canceled first.
+func sleepContext(request *HTTPRequest, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-request.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultProxyTimeout bounds a proxied request when the server has no
+// HandlerTimeout of its own.
+const defaultProxyTimeout = 30 * time.Second
+
+// defaultMaxProxyBodyBytes caps the request body Proxy buffers when
+// neither the route nor the server sets a body size limit.
+const defaultMaxProxyBodyBytes = 10 << 20
+
+// hopByHopHeaders describe a single connection rather than the message, so
+// they are not forwarded in either direction.
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "TE", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// Proxy forwards requests under prefix to the upstreams of pool, e.g.
+// s.Proxy("/api", pool) sends /api/users?page=2 to <upstream>/users?page=2.
+// The request is buffered in full before it goes out, as is the response
+// before it comes back; request bodies over the route's size limit, or
+// defaultMaxProxyBodyBytes without one, get 413. Requests no upstream
+// answers get 502, and those that find every upstream ejected 503.
+func (s *Server) Proxy(prefix string, pool *UpstreamPool) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	client := &Client{Timeout: defaultProxyTimeout}
+	if s.HandlerTimeout > 0 {
+		client.Timeout = s.HandlerTimeout
+	}
+	handler := func(w *ResponseWriter, request *HTTPRequest, _ map[string]string) error {
+		return s.proxyRequest(w, request, client, pool, prefix)
+	}
+	s.HandleFunc(prefix+"/*path", handler)
+	if prefix != "" {
+		s.HandleFunc(prefix, handler)
+	}
+}
+
+func (s *Server) proxyRequest(w *ResponseWriter, request *HTTPRequest, client *Client, pool *UpstreamPool, prefix string) error {
+	limit := request.body.limit
+	if limit <= 0 {
+		limit = defaultMaxProxyBodyBytes
+	}
+	if request.body.length > limit {
+		return &HTTPError{Code: StatusPayloadTooLarge, Err: errBodyTooLarge}
+	}
+	body, err := io.ReadAll(io.LimitReader(request.Body, limit+1))
+	if errors.Is(err, errBodyTooLarge) || errors.Is(err, errChunkedBodyTooLarge) || int64(len(body)) > limit {
+		return &HTTPError{Code: StatusPayloadTooLarge, Err: errBodyTooLarge}
+	}
+	if err != nil {
+		return &HTTPError{Code: StatusBadRequest, Message: "incomplete body", Err: err}
+	}
+	upstream, err := pool.pick()
+	if err != nil {
+		return &HTTPError{Code: StatusServiceUnavailable, Err: err}
+	}
+
+	// The target is built from the path rather than RequestURI, which for
+	// an absolute-form request still holds the scheme and authority.
+	rest := strings.TrimPrefix(request.RawPath, prefix)
+	if rest == "" {
+		rest = "/"
+	}
+	if _, query, ok := strings.Cut(request.RequestURI, "?"); ok {
+		rest += "?" + query
+	}
+	targetURL := strings.TrimSuffix(upstream.URL.String(), "/") + rest
+
+	response, err := client.Do(request.Method, targetURL, forwardedHeaders(request), body)
+	pool.done(upstream, err != nil || response.StatusCode >= 500)
+	if err != nil {
+		s.logger("proxy").Error("Proxying failed", requestLogArgs(request, "upstream", upstream.URL.String(), "err", err)...)
+		return &HTTPError{Code: StatusBadGateway, Err: err}
+	}
+
+	header := w.Header()
+	for name, values := range response.Headers {
+		header[name] = values
+	}
+	removeHopByHop(header)
+	contentType := ContentType(header.Get("Content-Type"))
+	header.Del("Content-Type")
+
+	status := StatusCode(fmt.Sprintf("HTTP/1.1 %d %s", response.StatusCode, response.Status))
+	if request.Method == MethodHead || response.StatusCode == 304 {
+		// No body follows, so the upstream's Content-Length, that of the
+		// body a GET would get, is passed on rather than replaced with 0.
+		w.writeHeader(status, []byte(w.formatHeaders(status, contentType)+"\r\n"))
+		return nil
+	}
+	header.Del("Content-Length")
+	s.writeResponse(w, status, w.formatHeaders(status, contentType), response.Body)
+	return nil
+}
+
+// forwardedHeaders returns the headers to send upstream for request: its
+// own, less the hop-by-hop ones, plus the X-Forwarded-* headers telling the
+// upstream who the client is. Those the client sent are only built on when
+// the peer is one of Server.TrustedProxies, as ClientIP does; anyone else
+// could use them, or Forwarded and X-Real-IP, to pose as another client,
+// host or scheme, so from other peers all of them are dropped.
+func forwardedHeaders(request *HTTPRequest) Header {
+	headers := make(Header, len(request.Headers)+3)
+	for name, values := range request.Headers {
+		headers[name] = values
+	}
+	removeHopByHop(headers)
+	// The client sets its own Host and Content-Length.
+	headers.Del("Host")
+	headers.Del("Content-Length")
+
+	if !request.trustedProxy(stripPort(request.RemoteAddr)) {
+		headers.Del("X-Forwarded-For")
+		headers.Del("X-Forwarded-Host")
+		headers.Del("X-Forwarded-Proto")
+		headers.Del("Forwarded")
+		headers.Del("X-Real-IP")
+	}
+	if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		if prior := headers.Get("X-Forwarded-For"); prior != "" {
+			host = prior + ", " + host
+		}
+		headers.Set("X-Forwarded-For", host)
+	}
+	if !headers.Has("X-Forwarded-Host") {
+		headers.Set("X-Forwarded-Host", request.Headers.Get("Host"))
+	}
+	if !headers.Has("X-Forwarded-Proto") {
+		proto := "http"
+		if request.TLS != nil {
+			proto = "https"
+		}
+		headers.Set("X-Forwarded-Proto", proto)
+	}
+	return headers
+}
+
+// removeHopByHop deletes the hop-by-hop headers from h, including any the
+// Connection header names.
+func removeHopByHop(h Header) {
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
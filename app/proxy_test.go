@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	spoofed := Header{}
+	spoofed.Set("Host", "example.com")
+	spoofed.Set("Connection", "keep-alive, X-Hop")
+	spoofed.Set("X-Hop", "1")
+	spoofed.Set("Content-Length", "5")
+	spoofed.Set("Accept", "text/plain")
+	spoofed.Set("X-Forwarded-For", "198.51.100.1")
+	spoofed.Set("X-Forwarded-Host", "evil.example")
+	spoofed.Set("X-Forwarded-Proto", "https")
+	spoofed.Set("Forwarded", "for=198.51.100.1")
+	spoofed.Set("X-Real-IP", "198.51.100.1")
+
+	trusted, err := ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		remote  string
+		proxies []*net.IPNet
+		want    map[string]string
+	}{
+		{"untrusted peer", "203.0.113.9:4000", nil, map[string]string{
+			"Accept":            "text/plain",
+			"X-Forwarded-For":   "203.0.113.9",
+			"X-Forwarded-Host":  "example.com",
+			"X-Forwarded-Proto": "http",
+			"Forwarded":         "",
+			"X-Real-IP":         "",
+			"Host":              "",
+			"Content-Length":    "",
+			"Connection":        "",
+			"X-Hop":             "",
+		}},
+		{"trusted peer", "10.1.2.3:4000", trusted, map[string]string{
+			"X-Forwarded-For":   "198.51.100.1, 10.1.2.3",
+			"X-Forwarded-Host":  "evil.example",
+			"X-Forwarded-Proto": "https",
+			"Forwarded":         "for=198.51.100.1",
+			"X-Real-IP":         "198.51.100.1",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := &HTTPRequest{Headers: spoofed, RemoteAddr: tt.remote, trustedProxies: tt.proxies}
+			headers := forwardedHeaders(request)
+			for name, want := range tt.want {
+				if got := headers.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cached":
+			// net/http drops Content-Length from a 304, so write it raw.
+			conn, buf, _ := w.(http.Hijacker).Hijack()
+			buf.WriteString("HTTP/1.1 304 Not Modified\r\nContent-Length: 42\r\n\r\n")
+			buf.Flush()
+			conn.Close()
+		default:
+			body, _ := io.ReadAll(r.Body)
+			echo := r.Method + " " + r.URL.RequestURI() + " " + string(body)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", strconv.Itoa(len(echo)))
+			io.WriteString(w, echo)
+		}
+	}))
+	defer upstream.Close()
+	pool, err := NewUpstreamPool(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	s.Proxy("/api", pool)
+	s.Proxy("/small", pool)
+	s.LimitBody("/small/*path", 4)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serve(listener)
+	defer listener.Close()
+
+	tests := []struct {
+		name    string
+		request string
+		status  int
+		length  string
+		body    string
+	}{
+		{"post", "POST /api/echo?x=1 HTTP/1.1\r\nHost: t\r\nContent-Length: 5\r\n\r\nhello", 200, "20", "POST /echo?x=1 hello"},
+		{"head keeps length", "HEAD /api/page HTTP/1.1\r\nHost: t\r\n\r\n", 200, "11", ""},
+		{"304 keeps length", "GET /api/cached HTTP/1.1\r\nHost: t\r\n\r\n", 304, "42", ""},
+		{"route limit", "POST /small/echo HTTP/1.1\r\nHost: t\r\nContent-Length: 5\r\n\r\nhello", 413, "0", ""},
+		{"forged length", "POST /api/echo HTTP/1.1\r\nHost: t\r\nContent-Length: 70368744177664\r\n\r\n", 413, "0", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := io.WriteString(conn, tt.request); err != nil {
+				t.Fatal(err)
+			}
+			method, _, _ := strings.Cut(tt.request, " ")
+			response, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: method})
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(response.Body)
+			if response.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", response.StatusCode, tt.status)
+			}
+			if got := response.Header.Get("Content-Length"); got != tt.length {
+				t.Errorf("Content-Length = %q, want %q", got, tt.length)
+			}
+			if tt.body != "" && string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
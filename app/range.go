@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// maxRanges bounds how many ranges a single Range header may ask for, so a
+// client cannot make the server assemble thousands of tiny parts.
+const maxRanges = 32
+
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// byteRange is a resolved, inclusive-exclusive span of a resource.
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange resolves a Range header against a resource of the given size.
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Range
+func parseRange(header string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, fmt.Errorf("unsupported range unit: %s", header)
+	}
+
+	var ranges []byteRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("malformed range: %s", part)
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		var r byteRange
+		if first == "" {
+			// Suffix range: the final N bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("malformed range: %s", part)
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("malformed range: %s", part)
+			}
+			if start >= size {
+				continue
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, fmt.Errorf("malformed range: %s", part)
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	if len(ranges) > maxRanges {
+		return nil, fmt.Errorf("too many ranges: %d", len(ranges))
+	}
+	return ranges, nil
+}
+
+// ServeContent sends size bytes of content, advertising byte ranges and
+// answering a Range request with only the parts asked for: a single range
+// as a plain 206 response, several as a multipart/byteranges body with one
+// part per range. An If-Range precondition that doesn't match the
+// response's ETag or Last-Modified header turns a Range request back into
+// one for the whole content, so a resumed download never splices two
+// versions of a file together. A GET or HEAD whose If-None-Match or
+// If-Modified-Since shows the client's copy is current gets a 304 instead.
+func (s *Server) ServeContent(w *ResponseWriter, request *HTTPRequest, contentType ContentType, content io.ReaderAt, size int64) {
+	if notModified(w, request) {
+		s.sendNotModified(w)
+		return
+	}
+	ctx := request.Context()
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	rangeHeader, ok := request.Headers.lookup("Range")
+	if !ok || !ifRangeMatches(w, request) {
+		s.sendContent(w, ctx, StatusOK, contentType, io.NewSectionReader(content, 0, size), size)
+		return
+	}
+
+	ranges, err := parseRange(rangeHeader, size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		s.sendResponse(w, StatusRequestedRangeNotSatisfiable, ContentTypePlainText, "")
+		return
+	}
+
+	// Overlapping ranges that add up to more than the resource are cheaper to
+	// answer with the whole thing.
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	if total > size {
+		s.sendContent(w, ctx, StatusOK, contentType, io.NewSectionReader(content, 0, size), size)
+		return
+	}
+
+	if len(ranges) == 1 {
+		r := ranges[0]
+		w.Header().Set("Content-Range", r.contentRange(size))
+		s.sendContent(w, ctx, StatusPartialContent, contentType, io.NewSectionReader(content, r.start, r.length), r.length)
+		return
+	}
+
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	multipartType := ContentType("multipart/byteranges; boundary=" + boundary)
+	s.SendStream(w, StatusPartialContent, multipartType, func(out io.Writer) error {
+		mw := multipart.NewWriter(out)
+		if err := mw.SetBoundary(boundary); err != nil {
+			return err
+		}
+		for _, r := range ranges {
+			part, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":  {string(contentType)},
+				"Content-Range": {r.contentRange(size)},
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := copyContext(ctx, part, io.NewSectionReader(content, r.start, r.length)); err != nil {
+				return err
+			}
+		}
+		return mw.Close()
+	})
+}
+
+// ifRangeMatches reports whether a Range request may be answered with
+// partial content: either it has no If-Range header, or the header names
+// the current ETag or Last-Modified date of the response. Weak ETags never
+// match.
+func ifRangeMatches(w *ResponseWriter, request *HTTPRequest) bool {
+	ifRange, ok := request.Headers.lookup("If-Range")
+	if !ok {
+		return true
+	}
+	ifRange = strings.TrimSpace(ifRange)
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == w.Header().Get("ETag")
+	}
+	if strings.HasPrefix(ifRange, "W/") {
+		return false
+	}
+	lastModified, ok := w.Header().lookup("Last-Modified")
+	return ok && ifRange == lastModified
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitOptions configures RateLimit.
+type RateLimitOptions struct {
+	// Requests is how many requests a client may make per Period. Period
+	// defaults to a minute.
+	Requests int
+	Period   time.Duration
+
+	// Burst is how many requests a client may make back to back after it
+	// has been idle. Zero means Requests.
+	Burst int
+
+	// Key identifies the client a request counts against. Nil means the
+	// request's ClientIP, so configure Server.TrustedProxies behind a load
+	// balancer. An empty key exempts the request from the limit.
+	Key func(request *HTTPRequest) string
+}
+
+// requestBucket is one client's token bucket: it refills at the limit's
+// rate up to its burst, and each request takes a token.
+type requestBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter holds the buckets of every client RateLimit has seen.
+type rateLimiter struct {
+	rate    float64 // tokens per second
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*requestBucket
+	swept   time.Time
+}
+
+// RateLimit refuses requests beyond options' limit with 429 Too Many
+// Requests and a Retry-After header saying when the client may try again.
+// Every response carries the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers of the IETF draft, so that well-behaved clients
+// can pace themselves. Limits are kept per client in a token bucket.
+// Install it with Use for a server-wide limit, or on a route for a limit
+// of that route's own.
+// https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+func RateLimit(options RateLimitOptions) Middleware {
+	if options.Requests <= 0 {
+		panic("RateLimit: Requests must be positive")
+	}
+	if options.Period <= 0 {
+		options.Period = time.Minute
+	}
+	if options.Burst <= 0 {
+		options.Burst = options.Requests
+	}
+	if options.Key == nil {
+		options.Key = (*HTTPRequest).ClientIP
+	}
+	limiter := &rateLimiter{
+		rate:    float64(options.Requests) / options.Period.Seconds(),
+		burst:   float64(options.Burst),
+		buckets: make(map[string]*requestBucket),
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+			key := options.Key(request)
+			if key == "" {
+				return next(w, request, params)
+			}
+			allowed, remaining, retryAfter, reset := limiter.take(key, time.Now())
+			header := w.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(options.Burst))
+			header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+			if !allowed {
+				header.Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+				return &HTTPError{Code: StatusTooManyRequests}
+			}
+			return next(w, request, params)
+		}
+	}
+}
+
+// take spends a token from key's bucket if there is one. It returns the
+// whole tokens left, how long until the next token is due, and how long
+// until the bucket is full again.
+func (l *rateLimiter) take(key string, now time.Time) (allowed bool, remaining int, retryAfter, reset time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	bucket := l.buckets[key]
+	if bucket == nil {
+		bucket = &requestBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		allowed = true
+	} else {
+		retryAfter = l.after(1 - bucket.tokens)
+	}
+	return allowed, int(bucket.tokens), retryAfter, l.after(l.burst - bucket.tokens)
+}
+
+// after is how long the bucket takes to gain tokens.
+func (l *rateLimiter) after(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// sweep forgets buckets that have refilled completely, which are no
+// different from new ones, so that a stream of one-off clients can't grow
+// the map without bound. It runs at most once per refill period.
+func (l *rateLimiter) sweep(now time.Time) {
+	fill := l.after(l.burst)
+	if now.Sub(l.swept) < fill {
+		return
+	}
+	l.swept = now
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= fill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// ceilSeconds rounds d up to whole seconds, as the headers carry them.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
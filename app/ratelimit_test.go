@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	l := &rateLimiter{rate: 1, burst: 3, buckets: make(map[string]*requestBucket)}
+	start := time.Unix(1_700_000_000, 0)
+
+	steps := []struct {
+		key        string
+		after      time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+		reset      time.Duration
+	}{
+		{key: "a", allowed: true, remaining: 2, reset: time.Second},
+		{key: "a", allowed: true, remaining: 1, reset: 2 * time.Second},
+		{key: "a", allowed: true, remaining: 0, reset: 3 * time.Second},
+		{key: "a", allowed: false, remaining: 0, retryAfter: time.Second, reset: 3 * time.Second},
+		{key: "b", allowed: true, remaining: 2, reset: time.Second},
+		{key: "a", after: 500 * time.Millisecond, allowed: false, remaining: 0, retryAfter: 500 * time.Millisecond, reset: 2500 * time.Millisecond},
+		{key: "a", after: time.Second, allowed: true, remaining: 0, reset: 3 * time.Second},
+		{key: "a", after: time.Hour, allowed: true, remaining: 2, reset: time.Second},
+	}
+	for i, step := range steps {
+		allowed, remaining, retryAfter, reset := l.take(step.key, start.Add(step.after))
+		if allowed != step.allowed || remaining != step.remaining || retryAfter != step.retryAfter || reset != step.reset {
+			t.Errorf("step %d: take(%q) = %v, %d, %v, %v; want %v, %d, %v, %v", i, step.key,
+				allowed, remaining, retryAfter, reset, step.allowed, step.remaining, step.retryAfter, step.reset)
+		}
+	}
+	// By the last step b had refilled, so the sweep forgot it.
+	if _, ok := l.buckets["b"]; ok {
+		t.Error("refilled bucket was not swept")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := NewServer()
+	limit := RateLimit(RateLimitOptions{
+		Requests: 2,
+		Period:   time.Hour,
+		Key: func(request *HTTPRequest) string {
+			if request.Path == "/exempt" {
+				return ""
+			}
+			return request.ClientIP()
+		},
+	})
+	handler := limit(func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+		return nil
+	})
+
+	tests := []struct {
+		remote     string
+		path       string
+		status     StatusCode
+		remaining  string
+		retryAfter string
+	}{
+		{remote: "192.0.2.1:1000", path: "/", remaining: "1"},
+		{remote: "192.0.2.1:1001", path: "/", remaining: "0"},
+		{remote: "192.0.2.1:1002", path: "/", status: StatusTooManyRequests, remaining: "0", retryAfter: "1800"},
+		{remote: "192.0.2.2:1000", path: "/", remaining: "1"},
+		{remote: "192.0.2.1:1003", path: "/exempt"},
+	}
+	for i, tt := range tests {
+		w := s.newResponseWriter(nil)
+		err := handler(w, &HTTPRequest{RemoteAddr: tt.remote, Path: tt.path}, nil)
+		var httpErr *HTTPError
+		var status StatusCode
+		if errors.As(err, &httpErr) {
+			status = httpErr.Code
+		} else if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		header := w.Header()
+		if status != tt.status {
+			t.Errorf("request %d: status = %q, want %q", i, status, tt.status)
+		}
+		if got := header.Get("RateLimit-Remaining"); got != tt.remaining {
+			t.Errorf("request %d: RateLimit-Remaining = %q, want %q", i, got, tt.remaining)
+		}
+		if got := header.Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("request %d: Retry-After = %q, want %q", i, got, tt.retryAfter)
+		}
+		if tt.remaining != "" && header.Get("RateLimit-Limit") != "2" {
+			t.Errorf("request %d: RateLimit-Limit = %q, want 2", i, header.Get("RateLimit-Limit"))
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import "slices"
+
+// Permission names an action a role may perform, e.g. "files:write".
+type Permission string
+
+// RoleAssignments looks up the roles assigned to a subject, for deployments
+// that keep assignments outside the credentials themselves.
+type RoleAssignments interface {
+	RolesFor(subject string) []string
+}
+
+// StaticAssignments is a fixed subject to roles table.
+type StaticAssignments map[string][]string
+
+func (a StaticAssignments) RolesFor(subject string) []string {
+	return a[subject]
+}
+
+// RBAC maps roles to permissions. A principal's roles are the ones carried
+// on the Principal itself plus any found through Assignments.
+type RBAC struct {
+	Assignments RoleAssignments
+
+	permissions map[string][]Permission
+}
+
+func NewRBAC(assignments RoleAssignments) *RBAC {
+	return &RBAC{
+		Assignments: assignments,
+		permissions: make(map[string][]Permission),
+	}
+}
+
+// Grant gives role the listed permissions, in addition to any it already
+// has.
+func (r *RBAC) Grant(role string, permissions ...Permission) {
+	r.permissions[role] = append(r.permissions[role], permissions...)
+}
+
+// Roles returns every role held by principal.
+func (r *RBAC) Roles(principal *Principal) []string {
+	if principal == nil {
+		return nil
+	}
+	roles := slices.Clone(principal.Roles)
+	if r.Assignments != nil {
+		roles = append(roles, r.Assignments.RolesFor(principal.Subject)...)
+	}
+	return roles
+}
+
+func (r *RBAC) HasRole(principal *Principal, role string) bool {
+	return slices.Contains(r.Roles(principal), role)
+}
+
+func (r *RBAC) HasPermission(principal *Principal, permission Permission) bool {
+	for _, role := range r.Roles(principal) {
+		if slices.Contains(r.permissions[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole admits requests whose principal holds at least one of roles.
+// Anonymous requests get 401 and authenticated ones without the role 403.
+func (r *RBAC) RequireRole(roles ...string) Middleware {
+	return r.require(func(principal *Principal) bool {
+		return slices.ContainsFunc(roles, func(role string) bool { return r.HasRole(principal, role) })
+	})
+}
+
+// RequirePermission admits requests whose principal has a role granted
+// permission.
+func (r *RBAC) RequirePermission(permission Permission) Middleware {
+	return r.require(func(principal *Principal) bool {
+		return r.HasPermission(principal, permission)
+	})
+}
+
+func (r *RBAC) require(allowed func(*Principal) bool) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+			switch {
+			case request.Principal == nil:
+				return &HTTPError{Code: StatusUnauthorized}
+			case !allowed(request.Principal):
+				return &HTTPError{Code: StatusForbidden}
+			}
+			return next(w, request, params)
+		}
+	}
+}
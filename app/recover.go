@@ -0,0 +1,25 @@
+package main
+
+import (
+	"runtime/debug"
+)
+
+// handlePanic deals with a panic that escaped a handler: it logs the stack,
+// answers 500 if the handler hadn't started its response, and reports the
+// panic to OnPanic and the alerts. The connection is closed afterwards, as
+// whatever the handler left behind on it can't be trusted.
+func (s *Server) handlePanic(w *ResponseWriter, request *HTTPRequest, v any) {
+	stack := debug.Stack()
+	s.logger("http").Error("Handler panic", requestLogArgs(request, "panic", v, "stack", string(stack))...)
+
+	if w.status == "" {
+		w.header.Set("Connection", "close")
+		s.sendResponse(w, StatusInternalServerError, ContentTypePlainText, "")
+	}
+	if s.OnPanic != nil {
+		s.OnPanic(request, v, stack)
+	}
+	if s.Alerts != nil {
+		s.Alerts.record(request, w.status, v)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Redirect sends the client to target with one of the redirect statuses:
+// 301 and 308 for permanent moves, 302 and 307 for temporary ones, and 303
+// to point at a result with a GET. 307 and 308 tell the client to repeat
+// the request with the same method and body. A relative target is resolved
+// against the request path. Responses to GET and HEAD carry a short HTML
+// body linking to the new location for clients that don't follow it.
+func (s *Server) Redirect(w *ResponseWriter, request *HTTPRequest, target string, status StatusCode) {
+	switch status {
+	case StatusMovedPermanently, StatusFound, StatusSeeOther, StatusTemporaryRedirect, StatusPermanentRedirect:
+	default:
+		panic(fmt.Sprintf("Redirect: %q is not a redirect status", status))
+	}
+
+	target = resolveRedirect(request.Path, target)
+	w.Header().Set("Location", target)
+
+	body := ""
+	contentType := ContentTypePlainText
+	if request.Method == MethodGet || request.Method == MethodHead {
+		contentType = ContentTypeHTML
+		body = fmt.Sprintf("<a href=\"%s\">%s</a>.\n", html.EscapeString(target), status.Text())
+	}
+	s.sendResponse(w, status, contentType, body)
+}
+
+// resolveRedirect makes a relative redirect target absolute-path, relative
+// to the directory of requestPath, as browsers would resolve it. Targets
+// with a scheme or host, or already starting with "/", are left alone.
+func resolveRedirect(requestPath, target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(target, "/") {
+		return target
+	}
+
+	relative, query, _ := strings.Cut(target, "?")
+	if relative == "" {
+		relative = path.Base(requestPath)
+		if strings.HasSuffix(requestPath, "/") {
+			relative = ""
+		}
+	}
+	dir, _ := path.Split(requestPath)
+	if dir == "" {
+		dir = "/"
+	}
+	resolved := path.Clean(dir + relative)
+	if strings.HasSuffix(relative, "/") && resolved != "/" {
+		resolved += "/"
+	}
+	if query != "" {
+		resolved += "?" + query
+	}
+	return resolved
+}
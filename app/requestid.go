@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RequestIDHeader carries a request's ID between clients, this server and
+// the upstreams it proxies to.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the incoming IDs that are reused, so that a
+// client can't stuff arbitrary data into every log line.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID gives every request an ID for correlating it across logs and
+// services. An ID sent by the client or a proxy in front in X-Request-ID
+// is kept, provided it is a plausible one; otherwise a random one is
+// generated. The ID is set on the request's headers, so that proxied
+// requests carry it upstream, echoed in the response's, and stored on the
+// context for RequestIDFromContext and the access and slow logs.
+//
+// Install it with Server.Use ahead of other middleware, so that requests
+// those refuse are logged with an ID too.
+func RequestID() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+			id := request.Headers.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+				request.Headers.Set(RequestIDHeader, id)
+			}
+			w.Header().Set(RequestIDHeader, id)
+			request.SetContext(context.WithValue(request.Context(), requestIDKey{}, id))
+			return next(w, request, params)
+		}
+	}
+}
+
+// RequestIDFromContext returns the ID RequestID gave the request ctx
+// belongs to, or "" if it has none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether id is short and made of characters that
+// are safe to copy into log lines and headers unquoted.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':' || c == '/' || c == '+' || c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Resumable uploads send a file in pieces, each a PUT to its /files URL
+// with a Content-Range header saying where the piece goes:
+//
+//	PUT /files/big.iso
+//	Content-Range: bytes 0-1048575/*
+//
+// Pieces must arrive in order, each starting where the upload so far ends;
+// the total size may stay "*" until the last one. The bytes received are
+// kept in a hidden file next to the target, so an upload cut off by a
+// dropped connection resumes from whatever arrived. Once the last byte is
+// in, the file is moved into place like any other upload. A HEAD to the
+// URL with ?upload reports how far an upload has got in Upload-Offset.
+
+// uploadOffsetHeader reports how many bytes of a resumable upload have been
+// received.
+const uploadOffsetHeader = "Upload-Offset"
+
+// contentRange is a parsed Content-Range header of a request. total is -1
+// when it is given as "*".
+type contentRange struct {
+	start, end, total int64
+}
+
+// parseContentRange parses a "bytes start-end/total" Content-Range header.
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Range
+func parseContentRange(header string) (contentRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return contentRange{}, fmt.Errorf("unsupported Content-Range unit: %q", header)
+	}
+	span, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return contentRange{}, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+	first, last, ok := strings.Cut(span, "-")
+	if !ok {
+		return contentRange{}, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+
+	r := contentRange{total: -1}
+	var err error
+	if r.start, err = strconv.ParseInt(first, 10, 64); err != nil || r.start < 0 {
+		return contentRange{}, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+	if r.end, err = strconv.ParseInt(last, 10, 64); err != nil || r.end < r.start {
+		return contentRange{}, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+	if total != "*" {
+		if r.total, err = strconv.ParseInt(total, 10, 64); err != nil || r.total <= r.end {
+			return contentRange{}, fmt.Errorf("malformed Content-Range: %q", header)
+		}
+	}
+	return r, nil
+}
+
+func (r contentRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+// partialUploadPath is where the bytes of a resumable upload to filePath
+// collect until it completes.
+func partialUploadPath(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), uploadTempPrefix+"partial-"+filepath.Base(filePath))
+}
+
+// putFileRange stores one piece of a resumable upload to filePath. A piece
+// that doesn't start where the upload so far ends is refused with 409 and
+// the current offset, for the client to carry on from there.
+func (s *Server) putFileRange(w *ResponseWriter, request *HTTPRequest, filename, filePath string) error {
+	r, err := parseContentRange(request.Headers.Get("Content-Range"))
+	if err != nil {
+		return &HTTPError{Code: StatusBadRequest, Message: err.Error()}
+	}
+	if contentLength, ok := request.Headers.lookup("Content-Length"); ok && contentLength != strconv.FormatInt(r.length(), 10) {
+		return &HTTPError{Code: StatusBadRequest, Message: "Content-Length doesn't match Content-Range"}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("creating directories: %w", err)
+	}
+	partialPath := partialUploadPath(filePath)
+	unlock := s.fileLocks.Lock(partialPath)
+	defer unlock()
+
+	file, err := os.OpenFile(partialPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening partial upload: %w", err)
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("opening partial upload: %w", err)
+	}
+	if r.start != offset {
+		w.Header().Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+		return &HTTPError{Code: StatusConflict, Message: fmt.Sprintf("upload is at offset %d, not %d", offset, r.start)}
+	}
+
+	s.logger("files").Info("Writing file range", "path", filePath, "start", r.start, "end", r.end)
+	// Whatever arrives is kept, even if the connection drops midway, so
+	// the next piece can pick up from there.
+	written, err := copyContext(request.Context(), file, io.LimitReader(request.Body, r.length()))
+	if err == nil {
+		err = file.Sync()
+	}
+	offset += written
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+	if err != nil {
+		return fmt.Errorf("writing partial upload: %w", err)
+	}
+	if written < r.length() {
+		return &HTTPError{Code: StatusBadRequest, Message: "body is shorter than its Content-Range"}
+	}
+
+	if r.total < 0 || offset < r.total {
+		s.sendEmpty(w, StatusNoContent)
+		return nil
+	}
+
+	info, err := file.Stat()
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("finishing upload: %w", err)
+	}
+	created, err := s.replaceFile(request, filename, filePath, partialPath)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", fileETag(info))
+	status := StatusCreated
+	if !created {
+		status = StatusOK
+	}
+	s.sendResponse(w, status, ContentTypePlainText, "")
+	return nil
+}
+
+// sendUploadProgress answers a HEAD for the progress of a resumable upload
+// to filePath, with an Upload-Offset of 0 if none is under way.
+func (s *Server) sendUploadProgress(w *ResponseWriter, filePath string) error {
+	var offset int64
+	info, err := os.Stat(partialUploadPath(filePath))
+	if err == nil {
+		offset = info.Size()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("checking partial upload: %w", err)
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	s.sendEmpty(w, StatusOK)
+	return nil
+}
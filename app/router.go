@@ -0,0 +1,232 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// router holds a route table and the middleware wrapped around every route
+// in it. It is embedded in Server and VirtualHost, so routes are registered
+// the same way on both.
+type router struct {
+	routes     map[string]*route
+	tree       routeNode
+	middleware []Middleware
+	// names maps route names to their patterns, for URL.
+	names map[string]string
+}
+
+// route is the set of handlers registered under one path pattern.
+type route struct {
+	pattern string
+	// params names the route's ":param" and "*catchall" segments in order.
+	params  []string
+	methods map[HTTPMethod]HandlerFunc
+	// any serves every method without a handler of its own.
+	any HandlerFunc
+	// maxBody overrides Server.MaxBodyBytes when non-zero.
+	maxBody int64
+}
+
+func newRouter() router {
+	return router{routes: make(map[string]*route)}
+}
+
+// Use appends middleware that wraps every route, in the order given,
+// including routes registered before the call. Middleware used on the
+// Server also wraps the routes of its virtual hosts, outside their own.
+func (r *router) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// HandleFunc registers handlerFunc for path regardless of the request
+// method, leaving the handler to tell methods apart.
+//
+// A path segment starting with ":" matches any single segment and passes
+// it to the handler as a param under the rest of its name. A final segment
+// starting with "*" matches the remainder of the path, slashes included,
+// so "/static/*filepath" serves "/static/css/site.css" with filepath set to
+// "css/site.css". Any middleware given wraps this route only, inside the
+// middleware installed with Use.
+func (r *router) HandleFunc(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	r.route(path).any = Chain(handlerFunc, middleware...)
+	return RouteName{r, path}
+}
+
+// Handle registers handlerFunc for requests to path with the given method.
+// Requests to a path that has method handlers but none for their method
+// are answered with 405 Method Not Allowed and an Allow header. Any
+// middleware given wraps this route only, inside the middleware installed
+// with Use.
+func (r *router) Handle(method HTTPMethod, path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	r.route(path).methods[method] = Chain(handlerFunc, middleware...)
+	return RouteName{r, path}
+}
+
+// LimitBody overrides Server.MaxBodyBytes for requests to path, e.g. to
+// allow large uploads on one route while keeping every other one tight. A
+// negative n lifts the limit for the route.
+func (r *router) LimitBody(path string, n int64) {
+	r.route(path).maxBody = n
+}
+
+func (r *router) GET(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return r.Handle(MethodGet, path, handlerFunc, middleware...)
+}
+
+func (r *router) POST(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return r.Handle(MethodPost, path, handlerFunc, middleware...)
+}
+
+func (r *router) PUT(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return r.Handle(MethodPut, path, handlerFunc, middleware...)
+}
+
+func (r *router) DELETE(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return r.Handle(MethodDelete, path, handlerFunc, middleware...)
+}
+
+func (r *router) PATCH(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return r.Handle(MethodPatch, path, handlerFunc, middleware...)
+}
+
+func (r *router) HEAD(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return r.Handle(MethodHead, path, handlerFunc, middleware...)
+}
+
+// OPTIONS registers a handler for OPTIONS requests to path, in place of
+// the automatic answer listing the route's methods.
+func (r *router) OPTIONS(path string, handlerFunc HandlerFunc, middleware ...Middleware) RouteName {
+	return r.Handle(MethodOptions, path, handlerFunc, middleware...)
+}
+
+func (r *router) route(path string) *route {
+	rt, ok := r.routes[path]
+	if !ok {
+		rt = &route{pattern: path, methods: make(map[HTTPMethod]HandlerFunc)}
+		r.routes[path] = rt
+		r.tree.insert(rt)
+	}
+	return rt
+}
+
+// match finds the route for a raw request path and fills params with its
+// decoded param values.
+func (r *router) match(rawPath string, params map[string]string) *route {
+	var buf [8]string
+	rt, values := r.tree.lookup(strings.Split(rawPath, "/"), buf[:0])
+	if rt == nil {
+		return nil
+	}
+	for i, name := range rt.params {
+		params[name] = values[i]
+	}
+	return rt
+}
+
+// handler returns the handler serving method on this route, if any. HEAD
+// requests fall back to the GET handler, whose body is then suppressed.
+func (rt *route) handler(method HTTPMethod) (HandlerFunc, bool) {
+	if handler, ok := rt.methods[method]; ok {
+		return handler, true
+	}
+	if method == MethodHead {
+		if handler, ok := rt.methods[MethodGet]; ok {
+			return handler, true
+		}
+	}
+	return rt.any, rt.any != nil
+}
+
+// allow lists the methods the route serves, for the Allow header. OPTIONS
+// is always among them, as it is answered for every route that has no
+// handler of its own for it.
+func (rt *route) allow() string {
+	methods := make([]string, 0, len(rt.methods)+2)
+	for method := range rt.methods {
+		methods = append(methods, string(method))
+	}
+	if _, ok := rt.methods[MethodGet]; ok {
+		if _, ok := rt.methods[MethodHead]; !ok {
+			methods = append(methods, string(MethodHead))
+		}
+	}
+	if _, ok := rt.methods[MethodOptions]; !ok {
+		methods = append(methods, string(MethodOptions))
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// serveRoute calls the route's handler for the request's method, wrapped in
+// the server's middleware and then that of host, if any. When the route
+// has no such handler, OPTIONS requests are answered with the methods it
+// does serve, and others with 405 through MethodNotAllowedHandler if one
+// is set.
+func (s *Server) serveRoute(w *ResponseWriter, request *HTTPRequest, host *VirtualHost, rt *route, params map[string]string) {
+	handler, ok := rt.handler(request.Method)
+	if !ok {
+		w.Header().Set("Allow", rt.allow())
+		if request.Method == MethodOptions {
+			s.sendEmpty(w, StatusNoContent)
+			return
+		}
+		if s.MethodNotAllowedHandler != nil {
+			s.serveHandler(w, request, host, s.MethodNotAllowedHandler, params)
+			return
+		}
+		s.sendResponse(w, StatusMethodNotAllowed, ContentTypePlainText, "")
+		return
+	}
+	if !s.limitBody(w, request, rt) {
+		return
+	}
+	s.serveHandler(w, request, host, handler, params)
+}
+
+// serveHandler calls handler wrapped in the middleware of host, if any,
+// and then the server's, so that the server's runs first, and answers any
+// error it returns.
+func (s *Server) serveHandler(w *ResponseWriter, request *HTTPRequest, host *VirtualHost, handler HandlerFunc, params map[string]string) {
+	if host != nil {
+		handler = Chain(handler, host.middleware...)
+	}
+	if err := Chain(handler, s.middleware...)(w, request, params); err != nil {
+		s.handleError(w, request, err)
+	}
+}
+
+// serverOptions answers "OPTIONS *", which asks about the server rather
+// than any resource of it, with every method the server accepts.
+// https://www.rfc-editor.org/rfc/rfc9110#section-9.3.7
+func (s *Server) serverOptions(w *ResponseWriter) {
+	methods := make([]string, 0, len(knownMethods))
+	for method := range knownMethods {
+		methods = append(methods, string(method))
+	}
+	sort.Strings(methods)
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	s.sendEmpty(w, StatusNoContent)
+}
+
+// limitBody applies the body size limit of rt, or the server's, to the
+// request. A body whose Content-Length already exceeds it is refused with
+// 413 before any of it is read, and the connection is closed rather than
+// drained; other bodies fail with errBodyTooLarge once they outgrow it.
+func (s *Server) limitBody(w *ResponseWriter, request *HTTPRequest, rt *route) bool {
+	limit := s.MaxBodyBytes
+	if rt.maxBody != 0 {
+		limit = rt.maxBody
+	}
+	if limit <= 0 {
+		return true
+	}
+	if request.body.length > limit {
+		request.body.err = errBodyTooLarge
+		w.Header().Set("Connection", "close")
+		s.sendResponse(w, StatusPayloadTooLarge, ContentTypePlainText, "")
+		return false
+	}
+	request.body.limit = limit
+	return true
+}
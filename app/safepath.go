@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errPathEscapesRoot = errors.New("path escapes the root directory")
+
+// safeJoin resolves name, a slash-separated path taken from a URL, under
+// root. The name must already be percent-decoded, as route params are, so
+// that "..%2f" arrives as "../" and is checked as such. It returns the
+// cleaned relative name and the path on disk, or an error if the name is
+// malformed or would resolve outside root, whether through ".." segments or
+// through a symlink.
+func safeJoin(root, name string) (string, string, error) {
+	if strings.ContainsAny(name, "\\\x00") {
+		return "", "", errors.New("path contains a backslash or NUL byte")
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(name, "/") {
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			return "", "", errPathEscapesRoot
+		}
+		segments = append(segments, segment)
+	}
+	cleaned := strings.Join(segments, "/")
+	joined := filepath.Join(root, filepath.FromSlash(cleaned))
+
+	if err := checkWithinRoot(root, joined); err != nil {
+		return "", "", err
+	}
+	return cleaned, joined, nil
+}
+
+// checkWithinRoot follows symlinks in the longest existing prefix of path
+// and verifies that it still lies under root.
+func checkWithinRoot(root, path string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		// Nothing under a missing root can be a symlink out of it.
+		return nil
+	}
+
+	existing := path
+	for {
+		real, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			rel, err := filepath.Rel(realRoot, real)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return errPathEscapesRoot
+			}
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return nil
+		}
+		existing = parent
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "sub"), filepath.Join(root, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cleaned string // empty when the name is refused
+	}{
+		{name: "a.txt", cleaned: "a.txt"},
+		{name: "sub/a.txt", cleaned: "sub/a.txt"},
+		{name: "/sub//./a.txt", cleaned: "sub/a.txt"},
+		{name: "sub/missing/a.txt", cleaned: "sub/missing/a.txt"},
+		{name: "inside/a.txt", cleaned: "inside/a.txt"},
+		{name: "", cleaned: ""},
+		{name: "../a.txt"},
+		{name: "sub/../../a.txt"},
+		{name: "sub/.."},
+		{name: "..\\a.txt"},
+		{name: "a\x00.txt"},
+		{name: "escape/a.txt"},
+		{name: "escape"},
+	}
+	for _, tt := range tests {
+		cleaned, joined, err := safeJoin(root, tt.name)
+		refused := tt.cleaned == "" && tt.name != ""
+		switch {
+		case refused && err == nil:
+			t.Errorf("safeJoin(%q) = %q, want an error", tt.name, joined)
+		case !refused && err != nil:
+			t.Errorf("safeJoin(%q): %v", tt.name, err)
+		case !refused && (cleaned != tt.cleaned || joined != filepath.Join(root, filepath.FromSlash(tt.cleaned))):
+			t.Errorf("safeJoin(%q) = %q, %q, want %q under the root", tt.name, cleaned, joined, tt.cleaned)
+		}
+	}
+}
@@ -3,11 +3,20 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime/multipart"
 	"net"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Types and Constants Definitions
@@ -17,100 +26,786 @@ type StatusCode string
 type ContentType string
 
 const (
-	MethodGet  HTTPMethod = "GET"
-	MethodPost HTTPMethod = "POST"
+	MethodGet     HTTPMethod = "GET"
+	MethodHead    HTTPMethod = "HEAD"
+	MethodPost    HTTPMethod = "POST"
+	MethodPut     HTTPMethod = "PUT"
+	MethodDelete  HTTPMethod = "DELETE"
+	MethodPatch   HTTPMethod = "PATCH"
+	MethodOptions HTTPMethod = "OPTIONS"
 
-	StatusOK                  StatusCode = "HTTP/1.1 200 OK"
-	StatusNotFound            StatusCode = "HTTP/1.1 404 Not Found"
-	StatusInternalServerError StatusCode = "HTTP/1.1 500 Internal Server Error"
-	StatusCreated             StatusCode = "HTTP/1.1 201 Created"
-	StatusMethodNotAllowed    StatusCode = "HTTP/1.1 405 Method Not Allowed"
+	StatusOK                   StatusCode = "HTTP/1.1 200 OK"
+	StatusBadRequest           StatusCode = "HTTP/1.1 400 Bad Request"
+	StatusNotFound             StatusCode = "HTTP/1.1 404 Not Found"
+	StatusInternalServerError  StatusCode = "HTTP/1.1 500 Internal Server Error"
+	StatusCreated              StatusCode = "HTTP/1.1 201 Created"
+	StatusNoContent            StatusCode = "HTTP/1.1 204 No Content"
+	StatusMethodNotAllowed     StatusCode = "HTTP/1.1 405 Method Not Allowed"
+	StatusForbidden            StatusCode = "HTTP/1.1 403 Forbidden"
+	StatusUnauthorized         StatusCode = "HTTP/1.1 401 Unauthorized"
+	StatusBadGateway           StatusCode = "HTTP/1.1 502 Bad Gateway"
+	StatusServiceUnavailable   StatusCode = "HTTP/1.1 503 Service Unavailable"
+	StatusNotImplemented       StatusCode = "HTTP/1.1 501 Not Implemented"
+	StatusMisdirectedRequest   StatusCode = "HTTP/1.1 421 Misdirected Request"
+	StatusPartialContent       StatusCode = "HTTP/1.1 206 Partial Content"
+	StatusConflict             StatusCode = "HTTP/1.1 409 Conflict"
+	StatusPreconditionFailed   StatusCode = "HTTP/1.1 412 Precondition Failed"
+	StatusPayloadTooLarge      StatusCode = "HTTP/1.1 413 Payload Too Large"
+	StatusUnsupportedMediaType StatusCode = "HTTP/1.1 415 Unsupported Media Type"
+
+	StatusRequestedRangeNotSatisfiable StatusCode = "HTTP/1.1 416 Range Not Satisfiable"
+	StatusRequestHeaderFieldsTooLarge  StatusCode = "HTTP/1.1 431 Request Header Fields Too Large"
+	StatusTooManyRequests              StatusCode = "HTTP/1.1 429 Too Many Requests"
+	StatusHTTPVersionNotSupported      StatusCode = "HTTP/1.1 505 HTTP Version Not Supported"
+
+	StatusNotModified       StatusCode = "HTTP/1.1 304 Not Modified"
+	StatusMovedPermanently  StatusCode = "HTTP/1.1 301 Moved Permanently"
+	StatusFound             StatusCode = "HTTP/1.1 302 Found"
+	StatusSeeOther          StatusCode = "HTTP/1.1 303 See Other"
+	StatusTemporaryRedirect StatusCode = "HTTP/1.1 307 Temporary Redirect"
+	StatusPermanentRedirect StatusCode = "HTTP/1.1 308 Permanent Redirect"
 
 	ContentTypePlainText       ContentType = "text/plain"
 	ContentTypeOctetStream     ContentType = "application/octet-stream"
 	ContentTypeApplicationJSON ContentType = "application/json"
+	ContentTypeZip             ContentType = "application/zip"
+	ContentTypeEventStream     ContentType = "text/event-stream"
+	ContentTypeHTML            ContentType = "text/html; charset=utf-8"
+	ContentTypeMarkdown        ContentType = "text/markdown; charset=utf-8"
+	ContentTypePrometheus      ContentType = "text/plain; version=0.0.4; charset=utf-8"
 )
 
+// Code returns the numeric part of a status line, e.g. 404, or 0 if the
+// status is unset.
+func (c StatusCode) Code() int {
+	code := 0
+	fmt.Sscanf(string(c), "HTTP/1.1 %d", &code)
+	return code
+}
+
+// Text returns the reason phrase of a status line, e.g. "Not Found".
+func (c StatusCode) Text() string {
+	parts := strings.SplitN(string(c), " ", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[2]
+}
+
+// knownMethods are the request methods the server accepts.
+var knownMethods = map[HTTPMethod]bool{
+	MethodGet:     true,
+	MethodHead:    true,
+	MethodPost:    true,
+	MethodPut:     true,
+	MethodDelete:  true,
+	MethodPatch:   true,
+	MethodOptions: true,
+}
+
 // Route Handler
 
-type HandlerFunc func(conn net.Conn, request *HTTPRequest, params map[string]string)
+// HandlerFunc serves a request. An error it returns is answered by the
+// server's error renderer, unless the response has already been started.
+type HandlerFunc func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error
 
 type Server struct {
-	port   string
-	routes map[string]HandlerFunc
-}
+	router
+
+	hosts []*VirtualHost
+
+	fileLocks       *pathLocker
+	fileWatcherOnce sync.Once
+	fileWatcher     *dirWatcher
+
+	readinessMu     sync.Mutex
+	readinessChecks map[string]ReadinessCheck
+	livenessChecks  map[string]LivenessCheck
+	shuttingDown    atomic.Bool
+
+	connMu    sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]bool // value reports whether the conn is idle
+	connSlots chan struct{}
+	// rejecting counts the rejectOverload goroutines running.
+	rejecting atomic.Int32
+
+	// Addr is the address to listen on, ":4221" by default.
+	Addr string
+
+	// TLSCertFile and TLSKeyFile, when set, make ListenAndServe serve
+	// HTTPS with them as the default certificate.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// FilesDir is the directory the /files API serves, "/tmp" by default.
+	FilesDir string
+
+	// FileVersions is how many previous versions of a file to keep when
+	// it is overwritten or deleted. Zero keeps none.
+	FileVersions int
+
+	// DefaultHost, when set, serves the requests whose Host header names
+	// none of the virtual hosts, or that come without one, in place of
+	// the routes registered on the Server itself.
+	DefaultHost *VirtualHost
+
+	// Renderer, when set, provides the HTML templates used by Render and as
+	// page layouts for Markdown mounts.
+	Renderer *Renderer
+
+	// Authenticate, when set, identifies the principal behind each request
+	// before it is authorized and dispatched. It returns nil for anonymous
+	// requests.
+	Authenticate func(request *HTTPRequest) *Principal
+
+	// Policy, when set, authorizes every request before it reaches a
+	// handler, denying anything its rules don't explicitly allow.
+	Policy *AuthPolicy
+
+	// CORS, when set, lets pages from the origins it allows call the
+	// server from the browser, and answers their preflight requests.
+	CORS *CORSPolicy
+
+	// Metrics, when set, collects per-route request metrics along with
+	// in-flight request and connection counts.
+	Metrics *Metrics
+
+	// Alerts, when set, raises alerts on elevated 5xx or panic rates.
+	Alerts *ErrorAlert
+
+	// OnPanic, when set, is called with the value and stack trace of every
+	// panic recovered from a handler, after the client has been answered.
+	OnPanic func(request *HTTPRequest, v any, stack []byte)
+
+	// Mmap, when set, serves large files from memory mappings.
+	Mmap *MmapCache
+
+	// Logger, when set, receives the server's log records in place of
+	// slog's default logger.
+	Logger Logger
+
+	// AccessLog, when set, records every request served.
+	AccessLog *AccessLog
+
+	// SlowLog, when set, records requests that take longer than its
+	// threshold.
+	SlowLog *SlowLog
+
+	// DrainTimeout bounds how long Shutdown waits for in-flight requests
+	// before closing their connections. Zero means 30 seconds.
+	DrainTimeout time.Duration
+
+	// ConnReadLimit and ConnWriteLimit cap the bandwidth of each client
+	// connection in bytes per second. Zero means unlimited.
+	ConnReadLimit  int64
+	ConnWriteLimit int64
+
+	// LenientLineEndings accepts request and header lines terminated by a
+	// bare LF, and an empty line before the request line, for clients that
+	// don't send CRLF. By default such requests are rejected.
+	LenientLineEndings bool
+
+	// DisableHTTP2 turns off HTTP/2, leaving clients that open with the
+	// HTTP/2 preface unanswered and h2c upgrade requests served as plain
+	// HTTP/1.1.
+	DisableHTTP2 bool
+
+	// IdleTimeout is how long a connection may wait for the first byte of
+	// its next request before it is closed. Zero means two minutes.
+	IdleTimeout time.Duration
+
+	// ReadHeaderTimeout bounds reading a request's head, from its first
+	// byte on. Zero means ReadTimeout is used instead, or IdleTimeout if
+	// that is zero too.
+	ReadHeaderTimeout time.Duration
+
+	// ReadTimeout bounds reading a whole request, body included, from its
+	// first byte on. Zero means no limit.
+	ReadTimeout time.Duration
 
-func (s *Server) HandleFunc(path string, handlerFunc HandlerFunc) {
-	s.routes[path] = handlerFunc
+	// WriteTimeout bounds writing a response, from the end of the request
+	// head on. It cuts long-lived streams short, so it is best left zero,
+	// meaning no limit, for servers with event streams.
+	WriteTimeout time.Duration
+
+	// MaxBodyBytes caps the size of request bodies; requests declaring a
+	// larger Content-Length are refused with 413 Payload Too Large. Routes
+	// can override it with LimitBody. Zero means no limit.
+	MaxBodyBytes int64
+
+	// MaxChunkedBodyBytes caps the decoded size of request bodies sent with
+	// Transfer-Encoding: chunked. Zero means 32 MiB; a negative value
+	// removes the limit.
+	MaxChunkedBodyBytes int64
+
+	// MaxHeaderBytes caps the size of a request's head, request line
+	// included, and MaxHeaderCount the number of header fields in it.
+	// Requests beyond either are refused with 431 Request Header Fields
+	// Too Large. Zero means 1 MiB and 100 fields respectively.
+	MaxHeaderBytes int
+	MaxHeaderCount int
+
+	// MaxConns caps the number of client connections served at once, each
+	// of which takes a goroutine and buffers of its own. Once it is
+	// reached, new connections wait in the listen backlog until one
+	// closes, or, with RejectOverload set, are answered 503 Service
+	// Unavailable and closed straight away; while too many are being
+	// answered already, they are closed without one. Zero means no limit.
+	MaxConns       int
+	RejectOverload bool
+
+	// TrustedProxies lists the load balancers and proxies whose forwarding
+	// headers HTTPRequest.ClientIP believes. See ParseTrustedProxies.
+	TrustedProxies []*net.IPNet
+
+	// Nagle turns Nagle's algorithm back on for client connections, which
+	// are otherwise set TCP_NODELAY so that each write is sent straight
+	// away. Responses are written in as few writes as possible, so this
+	// rarely saves segments, and delays the last part of a response.
+	Nagle bool
+
+	// NotFoundHandler, when set, answers requests that match no route, in
+	// place of an empty 404.
+	NotFoundHandler HandlerFunc
+
+	// MethodNotAllowedHandler, when set, answers requests whose route has
+	// no handler for their method, in place of an empty 405. The Allow
+	// header has already been set when it is called.
+	MethodNotAllowedHandler HandlerFunc
+
+	// HandlerTimeout bounds how long a request may be served before its
+	// context is canceled, so that slow file I/O or upstream calls give up.
+	// Handlers that notice are answered with 503. Zero means no limit.
+	HandlerTimeout time.Duration
+
+	// ErrorRenderer, when set, answers requests whose handler returned an
+	// error, in place of a plain-text response with the status and message
+	// given by ErrorStatus.
+	ErrorRenderer func(w *ResponseWriter, request *HTTPRequest, err error)
+
+	// ServerName is sent as the Server header of every response. NewServer
+	// sets it to "NetHttp"; empty leaves the header out.
+	ServerName string
+
+	// DirectoryListing makes files requests for a directory list its
+	// entries, as an HTML index or as JSON if the client asks for it, in
+	// place of a 404.
+	DirectoryListing bool
+
+	// MethodOverride lets POST requests stand in for PUT, PATCH and DELETE
+	// through an X-HTTP-Method-Override header or a _method form field.
+	MethodOverride bool
+
+	// TrailingSlash decides whether a path that matches a route only with
+	// its trailing slash added or removed is redirected, served as if it
+	// matched, or left to 404 as by default.
+	TrailingSlash TrailingSlashPolicy
+
+	// SniffContentType makes files served without a recognised extension
+	// take their Content-Type from their first bytes, rather than being
+	// sent as application/octet-stream.
+	SniffContentType bool
+
+	// MaxDecompressedBodyBytes caps the decompressed size of request bodies
+	// sent with Content-Encoding: gzip, which are decompressed before they
+	// reach handlers. Zero means 32 MiB; a negative value removes the limit.
+	MaxDecompressedBodyBytes int64
 }
 
+// defaultMaxChunkedBodyBytes is the decoded size limit for chunked request
+// bodies when Server.MaxChunkedBodyBytes is zero.
+const defaultMaxChunkedBodyBytes = 32 << 20
+
 type HTTPRequest struct {
-	Method  HTTPMethod
+	Method HTTPMethod
+	// Path is the percent-decoded request path; RawPath is the path as the
+	// client sent it.
 	Path    string
-	Headers map[string]string
-	Body    string
+	RawPath string
+	// RequestURI is the request target exactly as sent, query included.
+	RequestURI string
+	// RemoteAddr is the network address of the peer, which behind a load
+	// balancer is the balancer's; ClientIP resolves the client's.
+	RemoteAddr string
+	Proto      string
+	Query      url.Values
+	Headers    Header
+	TLS        *tls.ConnectionState
+
+	// Body streams the request body from the connection. It is never nil;
+	// requests without a body read as empty. Unread parts of the body are
+	// discarded once the handler returns.
+	Body io.Reader
+
+	// Trailers holds the trailer fields sent after a chunked body, once
+	// Body has been read to the end, or nil.
+	Trailers Header
+
+	// Principal is the authenticated identity of the client, or nil.
+	Principal *Principal
+
+	// Route is the pattern of the route serving the request, once matched.
+	Route string
+
+	ctx            context.Context
+	trustedProxies []*net.IPNet
+	body           requestBody
+	bodyBytes      []byte
+	bodyRead       bool
+	form           url.Values
+	multipartForm  *multipart.Form
+}
+
+// Context returns the request's context. It is canceled when the client
+// disconnects, when Server.HandlerTimeout runs out, or once the request has
+// been served.
+func (r *HTTPRequest) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// SetContext replaces the request's context, e.g. for middleware to attach
+// values for the handlers after it. ctx should be derived from Context so
+// that it is still canceled along with the request.
+func (r *HTTPRequest) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// ResponseWriter carries the client connection together with any headers
+// that should be added to the response sent on it.
+type ResponseWriter struct {
+	server *Server
+	conn   net.Conn
+	header Header
+	proto  string
+	// omitBody is set for HEAD requests, whose responses carry the headers
+	// a GET would, Content-Length included, but no body.
+	omitBody bool
+	// keepAlive is set when the client will send further requests on the
+	// connection, and body is the request body that has to be drained
+	// before it can; together they decide the Connection header.
+	keepAlive bool
+	body      *requestBody
+	// compress is set by the Compress middleware when the client accepts
+	// a compressed response.
+	compress *compressor
+
+	status      StatusCode
+	wroteHeader bool
+	bodySize    int64
+	writeTime   time.Duration
+}
+
+func (s *Server) newResponseWriter(conn net.Conn) *ResponseWriter {
+	return &ResponseWriter{
+		server: s,
+		conn:   conn,
+		header: make(Header),
+	}
+}
+
+// Header returns the extra headers to send with the response. Changes
+// made after the response head has been written have no effect.
+func (w *ResponseWriter) Header() Header {
+	return w.header
+}
+
+// Write sends raw bytes to the client, keeping track of the time spent
+// blocked on the connection.
+func (w *ResponseWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := w.conn.Write(p)
+	w.writeTime += time.Since(start)
+	if w.wroteHeader {
+		w.bodySize += int64(n)
+	}
+	return n, err
 }
 
 // Server Handler
 
-func NewServer(port string) *Server {
-	return &Server{
-		port:   port,
-		routes: make(map[string]HandlerFunc),
+// NewServer returns a server configured by options, which are applied in
+// order. Any field can still be set on the result before it is started.
+func NewServer(options ...Option) *Server {
+	s := &Server{
+		router:     newRouter(),
+		fileLocks:  newPathLocker(),
+		Addr:       ":4221",
+		FilesDir:   "/tmp",
+		ServerName: "NetHttp",
+	}
+	for _, option := range options {
+		option(s)
 	}
+	return s
 }
 
+// ListenAndServe serves on Addr, over TLS when TLSCertFile is set.
 func (s *Server) ListenAndServe() {
-	listener, err := net.Listen("tcp", "[::]:"+s.port)
+	if s.TLSCertFile != "" {
+		s.ListenAndServeTLS(s.TLSCertFile, s.TLSKeyFile)
+		return
+	}
+	listener, err := net.Listen("tcp", s.Addr)
 	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	s.logger("http").Info("Server started", "addr", s.Addr)
+	s.serve(s.throttleListener(listener))
+}
+
+func (s *Server) serve(listener net.Listener) {
 	defer listener.Close()
-	log.Printf("Server started on :%s", s.port)
+	s.trackListener(listener, true)
+	defer s.trackListener(listener, false)
 
 	for {
+		waitForSlot := !s.RejectOverload
+		if waitForSlot {
+			s.acquireConnSlot()
+		}
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Printf("Failed to accept connection: %v", err)
+			if waitForSlot {
+				s.releaseConnSlot()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger("http").Error("Failed to accept connection", "err", err)
 			continue
 		}
-		go s.handleConnection(conn)
+		if !waitForSlot && !s.acquireConnSlot() {
+			if s.rejecting.Add(1) > maxOverloadRejections {
+				// Answering takes a goroutine per connection too, so past
+				// the cap the connection is dropped without one.
+				s.rejecting.Add(-1)
+				conn.Close()
+				continue
+			}
+			go s.rejectOverload(conn)
+			continue
+		}
+		s.trackConn(conn, true)
+		accepted := time.Now()
+		go func() {
+			defer s.releaseConnSlot()
+			s.handleConnection(conn, accepted)
+		}()
 	}
 }
 
-func (s *Server) handleConnection(conn net.Conn) {
+// defaultIdleTimeout is how long a kept-alive connection may wait for its
+// next request when Server.IdleTimeout is zero.
+const defaultIdleTimeout = 2 * time.Minute
+
+// handleConnection serves requests on conn until the client or a response
+// asks for the connection to be closed, or it sits idle for longer than the
+// idle timeout.
+func (s *Server) handleConnection(conn net.Conn, accepted time.Time) {
+	defer s.trackConn(conn, false)
 	defer conn.Close()
+	if s.Metrics != nil {
+		s.Metrics.connOpened()
+		defer s.Metrics.connClosed()
+	}
+	if tcpConn, ok := tcpConnOf(conn); ok {
+		tcpConn.SetNoDelay(!s.Nagle)
+	}
 
-	request, err := s.parseRequest(conn)
-	if err != nil {
-		log.Printf("Failed to parse request: %v", err)
+	idleTimeout := s.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	// A head is never read without a deadline: one left open would let a
+	// client trickle bytes in and hold the connection forever.
+	headerTimeout := s.ReadHeaderTimeout
+	if headerTimeout <= 0 {
+		headerTimeout = s.ReadTimeout
+	}
+	if headerTimeout <= 0 {
+		headerTimeout = idleTimeout
+	}
+
+	queue := time.Since(accepted)
+	var handshake time.Duration
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		start := time.Now()
+		conn.SetDeadline(start.Add(headerTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			s.logger("tls").Warn("TLS handshake failed", "remote", conn.RemoteAddr().String(), "err", err)
+			return
+		}
+		conn.SetDeadline(time.Time{})
+		handshake = time.Since(start)
+		if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+			reader := acquireReader(conn)
+			defer releaseReader(reader)
+			s.serveHTTP2(conn, reader, nil)
+			return
+		}
+	}
+
+	reader := acquireReader(conn)
+	defer releaseReader(reader)
+	for served := 0; ; served++ {
+		// Wait for the first byte of the next request under the idle
+		// timeout; the rest of the head is then under the header timeout.
+		s.setConnIdle(conn, true)
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if _, err := reader.Peek(1); err != nil {
+			if !isIdleClose(err) {
+				s.logger("http").Warn("Failed to read request", "err", err)
+			}
+			return
+		}
+		s.setConnIdle(conn, false)
+		if served == 0 && !s.DisableHTTP2 && isHTTP2Preface(reader) {
+			s.serveHTTP2(conn, reader, nil)
+			return
+		}
+
+		start := time.Now()
+		conn.SetReadDeadline(deadline(start, headerTimeout))
+		request, err := s.parseRequest(reader)
+		if err != nil {
+			s.logger("http").Warn("Failed to parse request", "err", err)
+			var reqErr *requestError
+			if errors.As(err, &reqErr) {
+				s.rejectRequest(conn, reqErr)
+			}
+			return
+		}
+		if !s.DisableHTTP2 && wantsH2CUpgrade(request) {
+			s.serveHTTP2(conn, reader, request)
+			return
+		}
+		conn.SetReadDeadline(deadline(start, s.ReadTimeout))
+		conn.SetWriteDeadline(deadline(time.Now(), s.WriteTimeout))
+
+		timing := requestTiming{parse: time.Since(start)}
+		if served == 0 {
+			timing.queue, timing.handshake = queue, handshake
+		}
+		keepAlive := s.serveRequest(conn, reader, request, timing)
+		releaseRequest(request)
+		if !keepAlive {
+			return
+		}
+	}
+}
+
+// tcpConnOf returns the TCP connection underneath conn, which may be
+// wrapped for TLS or bandwidth throttling.
+func tcpConnOf(conn net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, true
+		case *tls.Conn:
+			conn = c.NetConn()
+		case *throttledConn:
+			conn = c.Conn
+		default:
+			return nil, false
+		}
+	}
+}
+
+// deadline returns the time timeout after start, or no deadline at all for
+// a zero timeout.
+func deadline(start time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
+
+// requestError is a request too malformed to be served. It is answered
+// with status before the connection is closed.
+type requestError struct {
+	status StatusCode
+	err    error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+func (e *requestError) Unwrap() error { return e.err }
+
+func (s *Server) rejectRequest(conn net.Conn, reqErr *requestError) {
+	w := s.newResponseWriter(conn)
+	s.sendResponse(w, reqErr.status, ContentTypePlainText, "")
+}
+
+// serveRequest dispatches one parsed request and reports whether the
+// connection can carry another one.
+func (s *Server) serveRequest(conn net.Conn, reader *bufio.Reader, request *HTTPRequest, timing requestTiming) (keepAlive bool) {
+	ctx, cancel := context.WithCancel(request.Context())
+	if s.HandlerTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.HandlerTimeout)
+	}
+	defer cancel()
+	request.ctx = ctx
+	request.RemoteAddr = conn.RemoteAddr().String()
+	request.trustedProxies = s.TrustedProxies
+	// The connection is only watched once the body has been read, as until
+	// then the handler may still be reading from it. HTTP/2 streams, which
+	// come without a reader of their own, are canceled by their connection.
+	defer request.removeFormFiles()
+	if reader != nil {
+		watcher := &disconnectWatcher{conn: conn, reader: reader, cancel: cancel}
+		defer watcher.stop()
+		if request.body.err == io.EOF {
+			watcher.start()
+		} else {
+			request.body.onEOF = watcher.start
+		}
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		request.TLS = &state
+	}
+
+	w := s.newResponseWriter(conn)
+	w.proto = request.Proto
+	w.omitBody = request.Method == MethodHead
+	w.keepAlive = wantsKeepAlive(request)
+	w.body = &request.body
+	defer func() {
+		// A panicking handler only takes down its own connection.
+		if v := recover(); v != nil {
+			s.handlePanic(w, request, v)
+			keepAlive = false
+		}
+	}()
+
+	if s.Metrics != nil {
+		s.Metrics.inFlight.Add(1)
+		defer s.Metrics.inFlight.Add(-1)
+	}
+	start := time.Now()
+	s.dispatch(w, request)
+	timing.write = w.writeTime
+	timing.handler = time.Since(start) - w.writeTime
+
+	if s.Metrics != nil {
+		s.Metrics.observe(request.Route, request.Method, w.status, timing.total(), w.bodySize)
+	}
+	if s.Alerts != nil {
+		s.Alerts.record(request, w.status, nil)
+	}
+	if s.SlowLog != nil {
+		s.SlowLog.record(request, w.status, timing)
+	}
+	if s.AccessLog != nil {
+		s.AccessLog.record(request, w, timing.total())
+	}
+	if reader != nil && !request.body.drain() {
+		return false
+	}
+	return w.status != "" && !w.closing()
+}
+
+// wantsKeepAlive reports whether the client is willing to send another
+// request on the connection: HTTP/1.1 connections persist unless closed
+// explicitly, HTTP/1.0 ones only when asked to.
+func wantsKeepAlive(request *HTTPRequest) bool {
+	connection := request.Headers.Get("Connection")
+	if request.Proto == "HTTP/1.0" {
+		return headerHasToken(connection, "keep-alive")
+	}
+	return !headerHasToken(connection, "close")
+}
+
+// headerHasToken reports whether a comma-separated header value contains
+// token, ignoring case.
+func headerHasToken(value, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdleClose reports whether err ended a kept-alive connection between
+// requests: the client hung up or the idle timeout expired.
+func isIdleClose(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || isTimeout(err)
+}
+
+// dispatch routes a parsed request to its handler, applying the host and
+// authorization policies on the way.
+func (s *Server) dispatch(w *ResponseWriter, request *HTTPRequest) {
+	router := &s.router
+	host := s.hostFor(request.Headers.Get("Host"))
+	if host != nil {
+		if !s.applyHostPolicy(w, request, host) {
+			return
+		}
+		router = &host.router
+	}
+	s.applyMethodOverride(request)
+	if request.Method == MethodOptions && request.RequestURI == "*" {
+		s.serverOptions(w)
+		return
+	}
+	if !s.applyCORS(w, request) {
+		return
+	}
+	if !s.applyAuthPolicy(w, request) {
 		return
 	}
 
-	for route, handler := range s.routes {
-		params := make(map[string]string)
-		if s.matchRoute(request.Path, route, params) {
-			handler(conn, request, params)
+	params := acquireParams()
+	defer releaseParams(params)
+	rt := router.match(request.RawPath, params)
+	if rt == nil {
+		var redirected bool
+		if rt, redirected = s.matchTrailingSlash(w, request, router, params); redirected {
 			return
 		}
 	}
+	if rt != nil {
+		request.Route = rt.pattern
+		s.serveRoute(w, request, host, rt, params)
+		return
+	}
 
-	s.sendResponse(conn, StatusNotFound, ContentTypePlainText, "", "", false)
+	if s.NotFoundHandler != nil {
+		s.serveHandler(w, request, host, s.NotFoundHandler, params)
+		return
+	}
+	s.sendResponse(w, StatusNotFound, ContentTypePlainText, "")
 }
 
-func (s *Server) matchRoute(requestPath, route string, params map[string]string) bool {
+// matchRoute matches rawPath, the path as sent by the client, against a
+// route pattern. Segments starting with ":" capture a single path segment;
+// a final segment starting with "*" captures the rest of the path, slashes
+// included. Segments are percent-decoded before they are compared or
+// captured, so an encoded "/" never splits a segment.
+func (s *Server) matchRoute(rawPath, route string, params map[string]string) bool {
 	routeParts := strings.Split(route, "/")
-	pathParts := strings.Split(requestPath, "/")
+	pathParts := strings.Split(rawPath, "/")
 
-	if len(routeParts) != len(pathParts) {
+	catchAll := strings.HasPrefix(routeParts[len(routeParts)-1], "*")
+	if catchAll {
+		if len(pathParts) < len(routeParts) {
+			return false
+		}
+	} else if len(routeParts) != len(pathParts) {
 		return false
 	}
 
 	for i, part := range routeParts {
-		if strings.HasPrefix(part, ":") {
+		if strings.HasPrefix(part, "*") && i == len(routeParts)-1 {
+			params[part[1:]] = unescapePath(strings.Join(pathParts[i:], "/"))
+		} else if strings.HasPrefix(part, ":") {
 			paramName := part[1:]
-			params[paramName] = pathParts[i]
-		} else if part != pathParts[i] {
+			params[paramName] = unescapePath(pathParts[i])
+		} else if part != unescapePath(pathParts[i]) {
 			return false
 		}
 	}
@@ -118,125 +813,491 @@ func (s *Server) matchRoute(requestPath, route string, params map[string]string)
 	return true
 }
 
+// unescapePath decodes a path that has already been validated by
+// parseRequest.
+func unescapePath(raw string) string {
+	decoded, err := url.PathUnescape(raw)
+	if err != nil {
+		return raw
+	}
+	return decoded
+}
+
 // Response and Request Handler
 
 // Parse the request from the client.
 // https://developer.mozilla.org/en-US/docs/Web/HTTP/Messages#http_requests
-func (s *Server) parseRequest(conn net.Conn) (*HTTPRequest, error) {
-	reader := bufio.NewReader(conn)
-	requestLine, err := reader.ReadString('\n')
+func (s *Server) parseRequest(reader *bufio.Reader) (*HTTPRequest, error) {
+	budget := s.maxHeaderBytes()
+	requestLine, err := s.readLine(reader, &budget)
 	if err != nil {
 		return nil, err
 	}
+	if requestLine == "" && s.LenientLineEndings {
+		if requestLine, err = s.readLine(reader, &budget); err != nil {
+			return nil, err
+		}
+	}
 
-	method, path, err := s.parseRequestLine(requestLine)
+	method, target, proto, err := s.parseRequestLine(requestLine)
 	if err != nil {
 		return nil, err
 	}
-
-	headers, err := s.parseHeaders(reader)
+	requestURI := target
+	target, authority, err := originForm(target)
+	if err != nil {
+		return nil, &requestError{status: StatusBadRequest, err: err}
+	}
+	if target != "*" && target[0] != '/' {
+		return nil, &requestError{status: StatusBadRequest, err: fmt.Errorf("unsupported request target %q", target)}
+	}
+	if target == "*" && method != MethodOptions {
+		return nil, &requestError{status: StatusBadRequest, err: fmt.Errorf("request target * is only for OPTIONS")}
+	}
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	path, err := url.PathUnescape(rawPath)
 	if err != nil {
+		return nil, &requestError{status: StatusBadRequest, err: err}
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, &requestError{status: StatusBadRequest, err: err}
+	}
+
+	request := acquireRequest()
+	if err := s.parseHeaders(reader, request.Headers, &budget); err != nil {
+		releaseRequest(request)
+		return nil, err
+	}
+	if err := checkHost(request.Headers, proto, authority); err != nil {
+		releaseRequest(request)
 		return nil, err
 	}
 
-	body, err := s.parseBody(reader, headers)
-	if err != nil {
+	if err := s.parseBody(reader, request); err != nil {
+		releaseRequest(request)
 		return nil, err
 	}
 
-	return &HTTPRequest{
-		Method:  method,
-		Path:    path,
-		Headers: headers,
-		Body:    body,
-	}, nil
+	request.Method = method
+	request.Path = path
+	request.RawPath = rawPath
+	request.RequestURI = requestURI
+	request.Proto = proto
+	request.Query = query
+	return request, nil
 }
 
-func (s *Server) parseRequestLine(requestLine string) (HTTPMethod, string, error) {
-	parts := strings.Split(strings.TrimSpace(requestLine), " ")
-	if len(parts) < 2 {
-		return "", "", fmt.Errorf("malformed request line")
+// parseRequestLine splits a request line into its method, target and
+// version, which must be separated by single spaces. Malformed lines and
+// targets holding whitespace or control characters are answered with 400,
+// methods the server doesn't know with 501, and versions other than
+// HTTP/1.0 and HTTP/1.1 with 505.
+// https://www.rfc-editor.org/rfc/rfc9112#section-3
+func (s *Server) parseRequestLine(requestLine string) (HTTPMethod, string, string, error) {
+	parts := strings.Split(requestLine, " ")
+	if len(parts) != 3 || !isToken(parts[0]) || parts[1] == "" {
+		return "", "", "", &requestError{status: StatusBadRequest, err: fmt.Errorf("malformed request line %q", requestLine)}
 	}
-	method := HTTPMethod(parts[0])
-	if method != MethodGet && method != MethodPost {
-		return "", "", fmt.Errorf("unsupported method: %s", method)
+	method, target, proto := HTTPMethod(parts[0]), parts[1], parts[2]
+	for i := 0; i < len(target); i++ {
+		if target[i] <= ' ' || target[i] == 0x7f {
+			return "", "", "", &requestError{status: StatusBadRequest, err: fmt.Errorf("invalid character in request target %q", target)}
+		}
+	}
+
+	major, minor, ok := parseHTTPVersion(proto)
+	if !ok {
+		return "", "", "", &requestError{status: StatusBadRequest, err: fmt.Errorf("malformed HTTP version %q", proto)}
+	}
+	if major != 1 || minor > 1 {
+		return "", "", "", &requestError{status: StatusHTTPVersionNotSupported, err: fmt.Errorf("unsupported HTTP version %q", proto)}
 	}
-	return method, parts[1], nil
+	if !knownMethods[method] {
+		return "", "", "", &requestError{status: StatusNotImplemented, err: fmt.Errorf("unsupported method: %s", method)}
+	}
+	return method, target, proto, nil
 }
 
-func (s *Server) parseHeaders(reader *bufio.Reader) (map[string]string, error) {
-	headers := make(map[string]string)
+// parseHTTPVersion parses an HTTP-version of the form HTTP/x.y.
+func parseHTTPVersion(proto string) (major, minor int, ok bool) {
+	digits, found := strings.CutPrefix(proto, "HTTP/")
+	if !found || len(digits) != 3 || digits[1] != '.' ||
+		digits[0] < '0' || digits[0] > '9' || digits[2] < '0' || digits[2] > '9' {
+		return 0, 0, false
+	}
+	return int(digits[0] - '0'), int(digits[2] - '0'), true
+}
+
+// isToken reports whether s is a non-empty RFC 9110 token, as methods and
+// header names are.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkHost requires the single Host header HTTP/1.1 requests must carry,
+// answering 400 for none or several. The authority of an absolute-form
+// target replaces any Host header sent with it.
+// https://www.rfc-editor.org/rfc/rfc9112#section-3.2
+func checkHost(headers Header, proto, authority string) error {
+	if authority != "" {
+		headers.Set("Host", authority)
+		return nil
+	}
+	switch hosts := headers.Values("Host"); {
+	case len(hosts) > 1:
+		return &requestError{status: StatusBadRequest, err: fmt.Errorf("%d Host headers", len(hosts))}
+	case len(hosts) == 0 && proto == "HTTP/1.1":
+		return &requestError{status: StatusBadRequest, err: errors.New("HTTP/1.1 request without a Host header")}
+	}
+	return nil
+}
+
+// originForm reduces an absolute-form request target, as sent to proxies
+// and allowed of any client, to the path and query it names, returning
+// its authority to stand in for the Host header. Other targets are
+// returned as they are.
+// https://www.rfc-editor.org/rfc/rfc9112#section-3.2.2
+func originForm(target string) (string, string, error) {
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		return target, "", nil
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return "", "", fmt.Errorf("malformed absolute request target %q", target)
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path, u.Host, nil
+}
+
+// defaultMaxHeaderBytes and defaultMaxHeaderCount are the limits on a
+// request's head when Server.MaxHeaderBytes and Server.MaxHeaderCount are
+// zero.
+const (
+	defaultMaxHeaderBytes = 1 << 20
+	defaultMaxHeaderCount = 100
+)
+
+var errHeaderTooLarge = errors.New("request head too large")
+
+func (s *Server) maxHeaderBytes() int {
+	if s.MaxHeaderBytes > 0 {
+		return s.MaxHeaderBytes
+	}
+	return defaultMaxHeaderBytes
+}
+
+// readLine reads one line of the request head and returns it without its
+// line ending, which must be CRLF unless LenientLineEndings is set. The
+// line is taken out of budget, the bytes left for the rest of the head;
+// a line that doesn't fit fails with 431 before it is read in full.
+func (s *Server) readLine(reader *bufio.Reader, budget *int) (string, error) {
+	var line []byte
 	for {
-		line, err := reader.ReadString('\n')
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > *budget {
+			return "", &requestError{status: StatusRequestHeaderFieldsTooLarge, err: errHeaderTooLarge}
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
 		if err != nil {
-			return nil, err
+			return "", err
+		}
+		break
+	}
+	*budget -= len(line)
+
+	if trimmed, ok := bytes.CutSuffix(line, []byte("\r\n")); ok {
+		return string(trimmed), nil
+	}
+	if !s.LenientLineEndings {
+		return "", fmt.Errorf("line not terminated by CRLF")
+	}
+	return string(bytes.TrimSuffix(line, []byte("\n"))), nil
+}
+
+func (s *Server) maxHeaderCount() int {
+	if s.MaxHeaderCount > 0 {
+		return s.MaxHeaderCount
+	}
+	return defaultMaxHeaderCount
+}
+
+func (s *Server) parseHeaders(reader *bufio.Reader, headers Header, budget *int) error {
+	maxCount := s.maxHeaderCount()
+	for count := 0; ; count++ {
+		line, err := s.readLine(reader, budget)
+		if err != nil {
+			return err
+		}
+		if line != "" && (line[0] == ' ' || line[0] == '\t') {
+			// Obsolete line folding continues the previous field, which
+			// not every proxy in front of the server would agree on.
+			return &requestError{status: StatusBadRequest, err: errors.New("obsolete line folding in headers")}
 		}
 		line = strings.TrimSpace(line)
 		if line == "" {
 			break
 		}
-		parts := strings.SplitN(line, ": ", 2)
-		if len(parts) < 2 {
-			continue
+		if count == maxCount {
+			return &requestError{status: StatusRequestHeaderFieldsTooLarge, err: fmt.Errorf("more than %d header fields", maxCount)}
+		}
+		// No whitespace is allowed between the field name and the colon
+		// (RFC 9112, section 5.1): a server that ignored it would disagree
+		// over the field with one that didn't, which is what request
+		// smuggling relies on.
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return &requestError{status: StatusBadRequest, err: fmt.Errorf("header line without a colon: %q", line)}
 		}
-		headers[parts[0]] = parts[1]
+		if !isToken(key) {
+			return &requestError{status: StatusBadRequest, err: fmt.Errorf("invalid header name %q", key)}
+		}
+		value = strings.TrimSpace(value)
+		if !validFieldValue(value) {
+			return &requestError{status: StatusBadRequest, err: fmt.Errorf("invalid value for header %s", key)}
+		}
+		headers.Add(key, value)
 	}
-	return headers, nil
+	return nil
 }
 
-func (s *Server) parseBody(reader *bufio.Reader, headers map[string]string) (string, error) {
-	contentLength, ok := headers["Content-Length"]
-	if !ok {
-		return "", nil
+// Send a response to the client.
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Messages#http_responses
+func (s *Server) sendResponse(w *ResponseWriter, status StatusCode, contentType ContentType, body string) {
+	bodyBytes := []byte(body)
+	if w.compresses(status, contentType, int64(len(bodyBytes))) {
+		compressed := acquireBuffer()
+		defer releaseBuffer(compressed)
+		if err := w.compress.compressBytes(compressed, bodyBytes); err != nil {
+			s.logger("http").Error("Failed to compress body", "err", err)
+			w.compress = nil
+			w.header.Del("Content-Encoding")
+		} else {
+			bodyBytes = compressed.Bytes()
+		}
 	}
-
-	return s.readBody(reader, contentLength)
+	headers := w.formatHeaders(status, contentType)
+	s.writeResponse(w, status, headers, bodyBytes)
 }
 
-func (s *Server) readBody(reader *bufio.Reader, contentLength string) (string, error) {
-	length := 0
+// sendEmpty sends a response that by definition has no body, such as 204 or
+// 304, and so carries neither a Content-Type nor a Content-Length.
+func (s *Server) sendEmpty(w *ResponseWriter, status StatusCode) {
+	w.writeHeader(status, []byte(w.formatHeaders(status, "")+"\r\n"))
+}
 
-	fmt.Sscanf(contentLength, "%d", &length)
-	body := make([]byte, length)
+func (s *Server) writeResponse(w *ResponseWriter, status StatusCode, headers string, body []byte) {
+	head := acquireBuffer()
+	defer releaseBuffer(head)
+	head.WriteString(headers)
+	head.WriteString("Content-Length: ")
+	head.Write(strconv.AppendInt(head.AvailableBuffer(), int64(len(body)), 10))
+	head.WriteString("\r\n\r\n")
+	if w.omitBody {
+		w.writeHeader(status, head.Bytes())
+		return
+	}
 
-	_, err := reader.Read(body)
+	// The head and body go out in a single write, so that a small response
+	// is a single segment rather than a head the client has to wait on the
+	// body after. Small bodies are copied in with the head; larger ones
+	// are handed to the kernel alongside it with writev.
+	w.status = status
+	start := time.Now()
+	var err error
+	if len(body) <= maxCoalescedBody {
+		head.Write(body)
+		_, err = w.conn.Write(head.Bytes())
+	} else {
+		buffers := net.Buffers{head.Bytes(), body}
+		_, err = buffers.WriteTo(w.conn)
+	}
+	w.writeTime += time.Since(start)
 	if err != nil {
-		return "", err
+		s.logger("http").Warn("Failed to write response", "err", err)
+		return
 	}
+	w.wroteHeader = true
+	w.bodySize += int64(len(body))
+}
+
+// maxCoalescedBody is the largest body writeResponse copies into the
+// buffer holding the response head.
+const maxCoalescedBody = 16 << 10
 
-	return string(body), nil
+// sendContent sends size bytes read from content, copying them to the
+// connection rather than buffering them, and stops early if ctx is done.
+func (s *Server) sendContent(w *ResponseWriter, ctx context.Context, status StatusCode, contentType ContentType, content io.Reader, size int64) {
+	if w.compresses(status, contentType, size) {
+		// The compressed size isn't known until it has been sent.
+		s.SendStream(w, status, contentType, func(out io.Writer) error {
+			written, err := copyContext(ctx, out, io.LimitReader(content, size))
+			if err == nil && written < size {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		})
+		return
+	}
+	headers := w.formatHeaders(status, contentType) + fmt.Sprintf("Content-Length: %d\r\n\r\n", size)
+	if !w.writeHeader(status, []byte(headers)) {
+		return
+	}
+	written, err := copyContext(ctx, w, io.LimitReader(content, size))
+	if err == nil && written < size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		s.logger("http").Warn("Failed to write body", "err", err)
+		// The client is owed more bytes than it got.
+		w.header.Set("Connection", "close")
+	}
 }
 
-// Send a response to the client.
-// https://developer.mozilla.org/en-US/docs/Web/HTTP/Messages#http_responses
-func (s *Server) sendResponse(conn net.Conn, status StatusCode, contentType ContentType, body, contentEncoding string, bodyIsCompressed bool) {
-	var bodyBytes []byte
-	headers := fmt.Sprintf("%s\r\nContent-Type: %s\r\n", status, contentType)
-
-	if bodyIsCompressed && contentEncoding == "gzip" {
-		headers += fmt.Sprintf("Content-Encoding: %s\r\n", contentEncoding)
-		var b bytes.Buffer
-		gz := gzip.NewWriter(&b)
-		defer gz.Close()
-		if _, err := gz.Write([]byte(body)); err != nil {
-			log.Printf("Failed to compress body: %v", err)
-			return
-		}
-		if err := gz.Close(); err != nil {
-			log.Printf("Failed to close gzip writer: %v", err)
-			return
-		}
-		bodyBytes = b.Bytes()
+// SendStream sends a response whose length is not known up front, such as
+// a log tail or generated content. Everything write produces goes out as it
+// is written: as chunks under Transfer-Encoding: chunked to HTTP/1.1
+// clients, so the connection stays usable, and delimited by closing the
+// connection for HTTP/1.0 ones.
+func (s *Server) SendStream(w *ResponseWriter, status StatusCode, contentType ContentType, write func(io.Writer) error) {
+	compressed := w.compresses(status, contentType, -1)
+	chunked := w.proto != "HTTP/1.0"
+	if chunked {
+		w.header.Set("Transfer-Encoding", "chunked")
 	} else {
-		bodyBytes = []byte(body)
+		w.header.Set("Connection", "close")
+	}
+	headers := w.formatHeaders(status, contentType) + "\r\n"
+	if !w.writeHeader(status, []byte(headers)) {
+		return
 	}
 
-	headers += fmt.Sprintf("Content-Length: %d\r\n\r\n", len(bodyBytes))
-	if _, err := conn.Write([]byte(headers)); err != nil {
-		log.Printf("Failed to write headers: %v", err)
+	if !chunked {
+		if err := writeCompressed(w, w.compress, compressed, write); err != nil {
+			s.logger("http").Warn("Failed to stream body", "err", err)
+		}
 		return
 	}
-	if _, err := conn.Write(bodyBytes); err != nil {
-		log.Printf("Failed to write body: %v", err)
+	body := &chunkedWriter{w: w}
+	err := writeCompressed(body, w.compress, compressed, write)
+	if err == nil {
+		err = body.Close()
 	}
+	if err != nil {
+		s.logger("http").Warn("Failed to stream body", "err", err)
+		// The body is cut short without its last chunk; closing the
+		// connection is the only way left to tell the client.
+		w.header.Set("Connection", "close")
+	}
+}
+
+// ReadFrom copies src to the connection, letting the kernel do the copy
+// where it can: for a file, or a LimitReader over one, sent over plain TCP,
+// net.TCPConn uses sendfile. It counts towards the response like Write.
+func (w *ResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !w.canSendfile() {
+		return io.Copy(writerOnly{w}, src)
+	}
+	start := time.Now()
+	n, err := w.conn.(io.ReaderFrom).ReadFrom(src)
+	w.writeTime += time.Since(start)
+	if w.wroteHeader {
+		w.bodySize += n
+	}
+	return n, err
+}
+
+// canSendfile reports whether the connection can be handed files to copy
+// itself, which is only worth it for plain TCP: TLS and throttled
+// connections would copy through a buffer anyway.
+func (w *ResponseWriter) canSendfile() bool {
+	_, ok := w.conn.(*net.TCPConn)
+	return ok
+}
+
+// writerOnly hides a ReadFrom method from io.Copy, which would otherwise
+// call it right back.
+type writerOnly struct {
+	io.Writer
 }
+
+// writeCompressed calls write with dst, through c's compression if
+// compressed is set.
+func writeCompressed(dst io.Writer, c *compressor, compressed bool, write func(io.Writer) error) error {
+	if !compressed {
+		return write(dst)
+	}
+	zw := c.newWriter(dst)
+	if err := write(zw); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	c.release(zw)
+	return nil
+}
+
+// writeHeader sends the status line and headers of the response and
+// reports whether its body should follow, which it must not in reply to a
+// HEAD request.
+func (w *ResponseWriter) writeHeader(status StatusCode, head []byte) bool {
+	w.status = status
+	if _, err := w.Write(head); err != nil {
+		w.server.logger("http").Warn("Failed to write headers", "err", err)
+		return false
+	}
+	w.wroteHeader = true
+	return !w.omitBody
+}
+
+// formatHeaders builds the head of a response, up to but not including the
+// framing headers. Date, Server and Connection are filled in here so that
+// every response carries them.
+func (w *ResponseWriter) formatHeaders(status StatusCode, contentType ContentType) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\r\n", status)
+	if contentType != "" {
+		fmt.Fprintf(&b, "Content-Type: %s\r\n", contentType)
+	}
+	if !w.header.Has("Date") {
+		w.header.Set("Date", time.Now().UTC().Format(dateFormat))
+	}
+	if w.server.ServerName != "" && !w.header.Has("Server") {
+		w.header.Set("Server", w.server.ServerName)
+	}
+	if !w.keepAlive || w.server.shuttingDown.Load() || (w.body != nil && !w.body.drainable()) {
+		w.header.Set("Connection", "close")
+	} else if w.proto == "HTTP/1.0" && !w.closing() {
+		w.header.Set("Connection", "keep-alive")
+	}
+	w.header.write(&b)
+	return b.String()
+}
+
+// closing reports whether the response closes the connection.
+func (w *ResponseWriter) closing() bool {
+	return headerHasToken(w.header.Get("Connection"), "close")
+}
+
+// dateFormat is the IMF-fixdate format of the Date header, RFC 7231
+// section 7.1.1.1. Times must be in UTC.
+const dateFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// requestStatus returns the status a request head error is answered with,
+// or "" for errors that aren't a requestError.
+func requestStatus(err error) StatusCode {
+	var re *requestError
+	if errors.As(err, &re) {
+		return re.status
+	}
+	return ""
+}
+
+func TestParseRequestLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		method HTTPMethod
+		target string
+		proto  string
+		status StatusCode // empty when the line parses
+	}{
+		{line: "GET / HTTP/1.1", method: MethodGet, target: "/", proto: "HTTP/1.1"},
+		{line: "POST /files/a?b=c HTTP/1.0", method: MethodPost, target: "/files/a?b=c", proto: "HTTP/1.0"},
+		{line: "OPTIONS * HTTP/1.1", method: MethodOptions, target: "*", proto: "HTTP/1.1"},
+		{line: "GET http://example.com/ HTTP/1.1", method: MethodGet, target: "http://example.com/", proto: "HTTP/1.1"},
+		{line: "GET /  HTTP/1.1", status: StatusBadRequest},
+		{line: "GET / HTTP/1.1 ", status: StatusBadRequest},
+		{line: "GET /", status: StatusBadRequest},
+		{line: "", status: StatusBadRequest},
+		{line: "G(T / HTTP/1.1", status: StatusBadRequest},
+		{line: "GET /a\tb HTTP/1.1", status: StatusBadRequest},
+		{line: "GET /\x7f HTTP/1.1", status: StatusBadRequest},
+		{line: "GET / HTTP/11", status: StatusBadRequest},
+		{line: "GET / http/1.1", status: StatusBadRequest},
+		{line: "GET / HTTP/2.0", status: StatusHTTPVersionNotSupported},
+		{line: "GET / HTTP/1.2", status: StatusHTTPVersionNotSupported},
+		{line: "BREW / HTTP/1.1", status: StatusNotImplemented},
+	}
+	s := &Server{}
+	for _, tt := range tests {
+		method, target, proto, err := s.parseRequestLine(tt.line)
+		if tt.status != "" {
+			if status := requestStatus(err); status != tt.status {
+				t.Errorf("parseRequestLine(%q) = %v, want status %s", tt.line, err, tt.status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRequestLine(%q): %v", tt.line, err)
+			continue
+		}
+		if method != tt.method || target != tt.target || proto != tt.proto {
+			t.Errorf("parseRequestLine(%q) = %q, %q, %q, want %q, %q, %q", tt.line, method, target, proto, tt.method, tt.target, tt.proto)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		head    string
+		lenient bool
+		want    Header
+		status  StatusCode // empty when the head parses
+	}{
+		{
+			name: "fields",
+			head: "Host: example.com\r\nAccept:text/plain\r\nX-Empty:\r\n\r\n",
+			want: Header{"Host": {"example.com"}, "Accept": {"text/plain"}, "X-Empty": {""}},
+		},
+		{
+			name: "repeated field",
+			head: "Accept: a\r\nAccept: b\r\n\r\n",
+			want: Header{"Accept": {"a", "b"}},
+		},
+		{
+			name: "value whitespace trimmed",
+			head: "Host: \t example.com \t\r\n\r\n",
+			want: Header{"Host": {"example.com"}},
+		},
+		{
+			name:    "bare LF when lenient",
+			head:    "Host: example.com\n\n",
+			lenient: true,
+			want:    Header{"Host": {"example.com"}},
+		},
+		{name: "bare LF", head: "Host: example.com\n\n"},
+		{name: "whitespace before colon", head: "Host : example.com\r\n\r\n", status: StatusBadRequest},
+		{name: "no colon", head: "Host example.com\r\n\r\n", status: StatusBadRequest},
+		{name: "empty name", head: ": value\r\n\r\n", status: StatusBadRequest},
+		{name: "non-token name", head: "X(a): value\r\n\r\n", status: StatusBadRequest},
+		{name: "NUL in value", head: "X-A: a\x00b\r\n\r\n", status: StatusBadRequest},
+		{name: "CR in value", head: "X-A: a\rb\r\n\r\n", status: StatusBadRequest},
+		{name: "obsolete line folding", head: "X-A: a\r\n b\r\n\r\n", status: StatusBadRequest},
+		{name: "too many fields", head: strings.Repeat("X-A: a\r\n", 101) + "\r\n", status: StatusRequestHeaderFieldsTooLarge},
+		{name: "too large", head: "X-A: " + strings.Repeat("a", 2<<20) + "\r\n\r\n", status: StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{LenientLineEndings: tt.lenient}
+			headers := make(Header)
+			budget := s.maxHeaderBytes()
+			err := s.parseHeaders(bufio.NewReader(strings.NewReader(tt.head)), headers, &budget)
+			switch {
+			case tt.want == nil && tt.status == "":
+				if err == nil {
+					t.Fatalf("parseHeaders succeeded, want an error")
+				}
+			case tt.status != "":
+				if status := requestStatus(err); status != tt.status {
+					t.Fatalf("parseHeaders = %v, want status %s", err, tt.status)
+				}
+			case err != nil:
+				t.Fatalf("parseHeaders: %v", err)
+			default:
+				if len(headers) != len(tt.want) {
+					t.Fatalf("headers = %q, want %q", headers, tt.want)
+				}
+				for name, values := range tt.want {
+					if got := headers.Values(name); strings.Join(got, "\n") != strings.Join(values, "\n") {
+						t.Errorf("%s = %q, want %q", name, got, values)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// defaultDrainTimeout is how long Shutdown waits for in-flight requests
+// when Server.DrainTimeout is zero.
+const defaultDrainTimeout = 30 * time.Second
+
+// Shutdown stops the server gracefully. It closes the listeners so no new
+// connections are accepted, marks the server as not ready, and waits for
+// in-flight requests to finish, for at most the drain timeout or until ctx
+// is done. Responses sent while draining carry Connection: close, and
+// kept-alive connections waiting for their next request are closed.
+// Connections still open after that are closed forcefully; the returned
+// error reports how many.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+
+	s.connMu.Lock()
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	s.connMu.Unlock()
+
+	timeout := s.DrainTimeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		remaining := s.closeIdleConns()
+		if remaining == 0 {
+			s.logger("http").Info("Server shut down cleanly")
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			forced := s.closeConns()
+			s.logger("http").Warn("Drain timed out, closed connections forcefully", "conns", forced)
+			return fmt.Errorf("%d connections closed forcefully: %w", forced, ctx.Err())
+		}
+	}
+}
+
+// closeIdleConns closes connections waiting for their next request and
+// returns how many connections remain open.
+func (s *Server) closeIdleConns() int {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	for conn, idle := range s.conns {
+		if idle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns)
+}
+
+func (s *Server) closeConns() int {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return len(s.conns)
+}
+
+func (s *Server) trackListener(listener net.Listener, add bool) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+	if add {
+		s.listeners[listener] = struct{}{}
+	} else {
+		delete(s.listeners, listener)
+	}
+}
+
+func (s *Server) trackConn(conn net.Conn, add bool) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	if add {
+		s.conns[conn] = false
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+// setConnIdle records whether conn is waiting for its next request, in
+// which case Shutdown may close it right away.
+func (s *Server) setConnIdle(conn net.Conn, idle bool) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if _, ok := s.conns[conn]; ok {
+		s.conns[conn] = idle
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// requestTiming breaks down where the time serving one request went.
+type requestTiming struct {
+	queue     time.Duration // accepted until picked up for serving
+	handshake time.Duration // TLS handshake, if any
+	parse     time.Duration // reading and parsing the request
+	handler   time.Duration // handler code, excluding time spent writing
+	write     time.Duration // blocked writing the response
+}
+
+func (t requestTiming) total() time.Duration {
+	return t.queue + t.handshake + t.parse + t.handler + t.write
+}
+
+// SlowLog writes one line for every request whose total time exceeds
+// Threshold, with the full timing breakdown, so tail-latency offenders can
+// be found without tracing every request.
+type SlowLog struct {
+	Threshold time.Duration
+	Output    io.Writer
+
+	mu sync.Mutex
+}
+
+func NewSlowLog(output io.Writer, threshold time.Duration) *SlowLog {
+	return &SlowLog{Threshold: threshold, Output: output}
+}
+
+func (l *SlowLog) record(request *HTTPRequest, status StatusCode, timing requestTiming) {
+	total := timing.total()
+	if total < l.Threshold {
+		return
+	}
+
+	line := fmt.Sprintf("%s slow request: %s %s status=%d total=%s queue=%s handshake=%s parse=%s handler=%s write=%s",
+		time.Now().Format(time.RFC3339), request.Method, request.Path, status.Code(),
+		total, timing.queue, timing.handshake, timing.parse, timing.handler, timing.write)
+	if id := RequestIDFromContext(request.Context()); id != "" {
+		line += " id=" + id
+	}
+	line += "\n"
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.Output, line)
+}
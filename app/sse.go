@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sseKeepAliveInterval is how often an idle event stream gets a comment,
+// so that proxies and clients don't time the connection out.
+const sseKeepAliveInterval = 15 * time.Second
+
+// SSEEvent is one Server-Sent Event. Only Data is required; Data spanning
+// several lines is sent as several data fields, which the client joins
+// back together.
+type SSEEvent struct {
+	// ID is remembered by the client and sent back as Last-Event-ID when
+	// it reconnects.
+	ID string
+	// Event names the event; clients treat events without one as
+	// "message".
+	Event string
+	Data  string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// SSEWriter writes Server-Sent Events to a response started by ServeSSE.
+// It is safe to use from several goroutines.
+type SSEWriter struct {
+	mu          sync.Mutex
+	out         io.Writer
+	err         error
+	lastEventID string
+}
+
+// ServeSSE answers request with an event stream, calling stream to send
+// the events; the response ends when stream returns. Every write goes
+// straight to the connection, and an idle stream gets a keep-alive comment
+// every sseKeepAliveInterval. Handlers resuming a stream can pick up after
+// the client's LastEventID.
+// https://developer.mozilla.org/en-US/docs/Web/API/Server-sent_events/Using_server-sent_events
+func (s *Server) ServeSSE(w *ResponseWriter, request *HTTPRequest, stream func(sse *SSEWriter) error) {
+	w.Header().Set("Cache-Control", "no-cache")
+	// Tells nginx and similar proxies not to hold events back in a buffer.
+	w.Header().Set("X-Accel-Buffering", "no")
+	s.SendStream(w, StatusOK, ContentTypeEventStream, func(out io.Writer) error {
+		sse := &SSEWriter{out: out, lastEventID: request.Headers.Get("Last-Event-ID")}
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keepAlive := time.NewTicker(sseKeepAliveInterval)
+			defer keepAlive.Stop()
+			for {
+				select {
+				case <-keepAlive.C:
+					sse.Comment("keep-alive")
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		err := stream(sse)
+		close(done)
+		wg.Wait()
+		if err == nil {
+			err = sse.Err()
+		}
+		return err
+	})
+}
+
+// LastEventID returns the ID of the last event the client saw before it
+// reconnected, or "" on a first connection.
+func (sse *SSEWriter) LastEventID() string {
+	return sse.lastEventID
+}
+
+// Send writes event to the stream.
+func (sse *SSEWriter) Send(event SSEEvent) error {
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + sseField(event.ID) + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + sseField(event.Event) + "\n")
+	}
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return sse.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore.
+func (sse *SSEWriter) Comment(text string) error {
+	return sse.write(": " + sseField(text) + "\n\n")
+}
+
+// Err returns the first error writing to the stream, which usually means
+// the client has gone away.
+func (sse *SSEWriter) Err() error {
+	sse.mu.Lock()
+	defer sse.mu.Unlock()
+	return sse.err
+}
+
+func (sse *SSEWriter) write(s string) error {
+	sse.mu.Lock()
+	defer sse.mu.Unlock()
+	if sse.err != nil {
+		return sse.err
+	}
+	_, sse.err = io.WriteString(sse.out, s)
+	return sse.err
+}
+
+// sseField keeps a single-line field from breaking into several.
+func sseField(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
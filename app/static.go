@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// StaticMount serves a directory tree under a URL prefix.
+type StaticMount struct {
+	prefix string
+	root   fs.FS
+
+	// SPA enables single-page-app mode: GET requests for paths under the
+	// mount that don't name a file are answered with the root index.html, so
+	// client-side routes survive a page refresh. HTML responses are marked
+	// no-cache while every other asset is cached for a year.
+	SPA bool
+}
+
+// Static serves root under prefix, e.g. s.Static("/assets/", os.DirFS(dir)),
+// to GET and HEAD requests. Directories are answered with their index.html,
+// after a redirect adding the trailing slash its relative links rely on.
+// The Content-Type follows the file's extension, ETag and Last-Modified its
+// size and mtime, and byte ranges and conditional GETs are supported.
+// Paths are cleaned before they reach root, so ".." segments can't climb
+// out of it.
+func (s *Server) Static(prefix string, root fs.FS) *StaticMount {
+	mount := &StaticMount{
+		prefix: strings.TrimSuffix(prefix, "/"),
+		root:   root,
+	}
+	s.HandleFunc(mount.prefix+"/*filepath", mount.handler(s))
+	if mount.prefix != "" {
+		s.HandleFunc(mount.prefix, func(w *ResponseWriter, request *HTTPRequest, _ map[string]string) error {
+			s.Redirect(w, request, mount.prefix+"/", StatusMovedPermanently)
+			return nil
+		})
+	}
+	return mount
+}
+
+func (m *StaticMount) handler(s *Server) HandlerFunc {
+	return func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+		if request.Method != MethodGet && request.Method != MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			return &HTTPError{Code: StatusMethodNotAllowed}
+		}
+
+		name := strings.Trim(path.Clean("/"+params["filepath"]), "/")
+		if name == "" {
+			name = "."
+		}
+
+		content, servedName, info, err := m.read(name)
+		if errors.Is(err, fs.ErrNotExist) && m.SPA && path.Ext(name) == "" {
+			content, servedName, info, err = m.read(".")
+		} else if err == nil && servedName != name && !strings.HasSuffix(request.Path, "/") {
+			s.Redirect(w, request, path.Base(request.Path)+"/", StatusMovedPermanently)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		contentType := s.contentTypeFor(servedName, content)
+		if m.SPA {
+			if strings.HasPrefix(string(contentType), "text/html") {
+				w.Header().Set("Cache-Control", "no-cache")
+			} else {
+				w.Header().Set("Cache-Control", "public, max-age=31536000")
+			}
+		}
+		if !info.ModTime().IsZero() {
+			setFileValidators(w, info)
+		}
+		s.ServeContent(w, request, contentType, bytes.NewReader(content), int64(len(content)))
+		return nil
+	}
+}
+
+// read loads name from the mount, resolving directories to their
+// index.html. It returns the name and info of the file actually read.
+func (m *StaticMount) read(name string) ([]byte, string, fs.FileInfo, error) {
+	info, err := fs.Stat(m.root, name)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if info.IsDir() {
+		name = path.Join(name, "index.html")
+		if info, err = fs.Stat(m.root, name); err != nil {
+			return nil, "", nil, err
+		}
+	}
+	content, err := fs.ReadFile(m.root, name)
+	return content, name, info, err
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io"
+	"sync"
+)
+
+// Renderer executes HTML templates parsed from the files matching a glob
+// pattern, e.g. "templates/*.html". Templates are parsed once, when the
+// renderer is created, and addressed by file name.
+//
+// Templates can build links to named routes with the url function, e.g.
+// {{url "file" "filepath" .Name}}, once URL is set.
+type Renderer struct {
+	pattern string
+
+	// URL backs the templates' url function, typically Server.URL.
+	URL func(name string, pairs ...string) (string, error)
+
+	// Reload re-parses the templates before every execution instead of
+	// using the startup cache, so edits show up without a restart. It is
+	// meant for development only.
+	Reload bool
+
+	mu        sync.RWMutex
+	templates *template.Template
+}
+
+func NewRenderer(pattern string) (*Renderer, error) {
+	r := &Renderer{pattern: pattern}
+	templates, err := r.parse()
+	if err != nil {
+		return nil, err
+	}
+	r.templates = templates
+	return r, nil
+}
+
+func (r *Renderer) parse() (*template.Template, error) {
+	return template.New("").Funcs(template.FuncMap{"url": r.url}).ParseGlob(r.pattern)
+}
+
+func (r *Renderer) url(name string, pairs ...string) (string, error) {
+	if r.URL == nil {
+		return "", errors.New("url: Renderer.URL is not set")
+	}
+	return r.URL(name, pairs...)
+}
+
+// Has reports whether the renderer defines the named template.
+func (r *Renderer) Has(name string) bool {
+	return r.current().Lookup(name) != nil
+}
+
+func (r *Renderer) Execute(w io.Writer, name string, data any) error {
+	if r.Reload {
+		if err := r.reload(); err != nil {
+			return err
+		}
+	}
+	return r.current().ExecuteTemplate(w, name, data)
+}
+
+func (r *Renderer) current() *template.Template {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.templates
+}
+
+// reload re-parses the template files. On a parse error the cached
+// templates are left untouched and the error is reported to the caller.
+func (r *Renderer) reload() error {
+	templates, err := r.parse()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.templates = templates
+	r.mu.Unlock()
+	return nil
+}
+
+// Render executes the named template from the server's renderer and sends
+// the result as an HTML response. The template is rendered into a buffer
+// first so a failing template yields a clean 500 instead of a partial page.
+func (s *Server) Render(w *ResponseWriter, status StatusCode, name string, data any) {
+	if s.Renderer == nil {
+		s.logger("templates").Error("Failed to render, no renderer configured", "template", name)
+		s.sendResponse(w, StatusInternalServerError, ContentTypePlainText, "")
+		return
+	}
+
+	var body bytes.Buffer
+	if err := s.Renderer.Execute(&body, name, data); err != nil {
+		s.logger("templates").Error("Failed to render", "template", name, "err", err)
+		s.sendResponse(w, StatusInternalServerError, ContentTypePlainText, "")
+		return
+	}
+	s.sendResponse(w, status, ContentTypeHTML, body.String())
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// tokenBucket paces a byte stream to a fixed rate. The bucket holds at most
+// one second's worth of tokens, so an idle connection can burst briefly but
+// never exceeds the rate over any longer window.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(bytesPerSecond int64) *tokenBucket {
+	burst := float64(bytesPerSecond)
+	if burst < 1024 {
+		burst = 1024
+	}
+	return &tokenBucket{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// maxChunk is the largest single transfer the bucket can ever admit.
+func (b *tokenBucket) maxChunk() int {
+	return int(b.burst)
+}
+
+// wait blocks until n bytes may be transferred and consumes their tokens.
+func (b *tokenBucket) wait(n int) {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / b.rate * float64(time.Second)))
+	}
+}
+
+// throttledConn applies read and write rate limits to a connection. Either
+// bucket may be nil, leaving that direction unlimited.
+type throttledConn struct {
+	net.Conn
+	read  *tokenBucket
+	write *tokenBucket
+}
+
+func (c *throttledConn) Read(p []byte) (int, error) {
+	if c.read == nil {
+		return c.Conn.Read(p)
+	}
+	if len(p) > c.read.maxChunk() {
+		p = p[:c.read.maxChunk()]
+	}
+	n, err := c.Conn.Read(p)
+	c.read.wait(n)
+	return n, err
+}
+
+func (c *throttledConn) Write(p []byte) (int, error) {
+	if c.write == nil {
+		return c.Conn.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > c.write.maxChunk() {
+			chunk = chunk[:c.write.maxChunk()]
+		}
+		c.write.wait(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// throttledListener wraps every accepted connection in its own pair of token
+// buckets, so the limits apply per connection rather than to the listener as
+// a whole.
+type throttledListener struct {
+	net.Listener
+	readRate  int64
+	writeRate int64
+}
+
+func (l *throttledListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	throttled := &throttledConn{Conn: conn}
+	if l.readRate > 0 {
+		throttled.read = newTokenBucket(l.readRate)
+	}
+	if l.writeRate > 0 {
+		throttled.write = newTokenBucket(l.writeRate)
+	}
+	return throttled, nil
+}
+
+// throttleListener applies the server's per-connection bandwidth limits to
+// listener. It must wrap the raw TCP listener, beneath any TLS layer, so the
+// limits count bytes actually sent on the wire.
+func (s *Server) throttleListener(listener net.Listener) net.Listener {
+	if s.ConnReadLimit <= 0 && s.ConnWriteLimit <= 0 {
+		return listener
+	}
+	return &throttledListener{
+		Listener:  listener,
+		readRate:  s.ConnReadLimit,
+		writeRate: s.ConnWriteLimit,
+	}
+}
+
+// BandwidthLimit shapes the responses of a single route, in bytes per
+// second. PerResponse paces each response on its own; Aggregate is shared by
+// every response of the route in flight at once, so one large download route
+// cannot crowd out interactive endpoints. Zero leaves that limit off.
+type BandwidthLimit struct {
+	PerResponse int64
+	Aggregate   int64
+}
+
+// LimitBandwidth wraps handler so that everything it writes is paced
+// according to limit.
+func LimitBandwidth(limit BandwidthLimit, handler HandlerFunc) HandlerFunc {
+	var aggregate *tokenBucket
+	if limit.Aggregate > 0 {
+		aggregate = newTokenBucket(limit.Aggregate)
+	}
+
+	return func(w *ResponseWriter, request *HTTPRequest, params map[string]string) error {
+		conn := w.conn
+		defer func() { w.conn = conn }()
+
+		if aggregate != nil {
+			w.conn = &throttledConn{Conn: w.conn, write: aggregate}
+		}
+		if limit.PerResponse > 0 {
+			w.conn = &throttledConn{Conn: w.conn, write: newTokenBucket(limit.PerResponse)}
+		}
+		return handler(w, request, params)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+)
+
+// TLSPolicy holds per-host TLS settings. Empty certificate paths fall back to
+// the server's default certificate.
+type TLSPolicy struct {
+	CertFile string
+	KeyFile  string
+
+	// ClientAuth and ClientCAFile configure mutual TLS for the host, e.g.
+	// tls.RequireAndVerifyClientCert for an admin site.
+	ClientAuth   tls.ClientAuthType
+	ClientCAFile string
+
+	MinVersion uint16
+}
+
+func (p *TLSPolicy) requiresClientCert() bool {
+	return p.ClientAuth == tls.RequireAnyClientCert || p.ClientAuth == tls.RequireAndVerifyClientCert
+}
+
+// ListenAndServeTLS serves HTTPS using certFile and keyFile as the default
+// certificate. Virtual hosts with a TLS policy are selected during the
+// handshake from the client's SNI server name.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) {
+	config, err := s.tlsConfig(certFile, keyFile)
+	if err != nil {
+		log.Fatalf("Failed to load TLS configuration: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	s.logger("http").Info("Server started", "addr", s.Addr, "tls", true)
+	s.serve(tls.NewListener(s.throttleListener(listener), config))
+}
+
+func (s *Server) tlsConfig(certFile, keyFile string) (*tls.Config, error) {
+	defaultCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	hostConfigs := make(map[*VirtualHost]*tls.Config)
+	for _, host := range s.hosts {
+		if host.TLS == nil {
+			continue
+		}
+		config, err := host.TLS.config(defaultCert)
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %w", host.pattern, err)
+		}
+		config.NextProtos = s.nextProtos()
+		hostConfigs[host] = config
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{defaultCert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   s.nextProtos(),
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if host := s.hostFor(hello.ServerName); host != nil {
+				return hostConfigs[host], nil
+			}
+			return nil, nil
+		},
+	}, nil
+}
+
+// nextProtos lists the protocols offered to clients during the handshake
+// through ALPN, most preferred first. Clients that negotiate none of them
+// are served HTTP/1.1.
+func (s *Server) nextProtos() []string {
+	if s.DisableHTTP2 {
+		return []string{"http/1.1"}
+	}
+	return []string{"h2", "http/1.1"}
+}
+
+func (p *TLSPolicy) config(defaultCert tls.Certificate) (*tls.Config, error) {
+	cert := defaultCert
+	if p.CertFile != "" {
+		var err error
+		cert, err = tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   p.ClientAuth,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if p.MinVersion != 0 {
+		config.MinVersion = p.MinVersion
+	}
+
+	if p.ClientCAFile != "" {
+		pem, err := os.ReadFile(p.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", p.ClientCAFile)
+		}
+		config.ClientCAs = pool
+	}
+	return config, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import "strings"
+
+// TrailingSlashPolicy decides what becomes of a request whose path matches
+// no route as sent but would with its trailing slash added or removed,
+// e.g. /echo/hi/ for the route /echo/:message.
+type TrailingSlashPolicy int
+
+const (
+	// TrailingSlashStrict treats /a and /a/ as different paths, so the
+	// request gets a 404. It is the default.
+	TrailingSlashStrict TrailingSlashPolicy = iota
+	// TrailingSlashRedirect redirects the client to the path that
+	// matches: with 301 for GET and HEAD, and with 308 for other methods
+	// so that they are repeated with their body.
+	TrailingSlashRedirect
+	// TrailingSlashIgnore serves the request from the route that matches,
+	// as if the path had been sent that way.
+	TrailingSlashIgnore
+)
+
+// matchTrailingSlash applies s.TrailingSlash to a request that matched no
+// route in router. It returns the route matching the path with its
+// trailing slash toggled, if the policy serves that directly, and reports
+// whether a redirect has already answered the request instead.
+func (s *Server) matchTrailingSlash(w *ResponseWriter, request *HTTPRequest, router *router, params map[string]string) (*route, bool) {
+	if s.TrailingSlash == TrailingSlashStrict || request.RawPath == "/" {
+		return nil, false
+	}
+	other := request.RawPath + "/"
+	if trimmed, ok := strings.CutSuffix(request.RawPath, "/"); ok {
+		other = trimmed
+	}
+	rt := router.match(other, params)
+	if rt == nil {
+		return nil, false
+	}
+	if s.TrailingSlash == TrailingSlashIgnore {
+		return rt, false
+	}
+
+	// Leading slashes are collapsed into one: a target starting with "//",
+	// or with "/\" as browsers read it, would send the client to another
+	// host instead.
+	target := "/" + strings.TrimLeft(other, `/\`)
+	if _, query, ok := strings.Cut(request.RequestURI, "?"); ok {
+		target += "?" + query
+	}
+	status := StatusPermanentRedirect
+	if request.Method == MethodGet || request.Method == MethodHead {
+		status = StatusMovedPermanently
+	}
+	s.Redirect(w, request, target, status)
+	return nil, true
+}
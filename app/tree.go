@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// routeNode is a node of the routing tree, which has one level per path
+// segment. Lookups walk the request path once, trying a node's static
+// children before its param children and those before its catch-all, so
+// the most specific route always wins no matter the order routes were
+// registered in. Param children with a constraint are tried before the
+// one without, and a segment failing every constraint matches none of
+// them.
+type routeNode struct {
+	static   map[string]*routeNode
+	params   []*routeNode
+	catchAll *route
+	route    *route
+
+	// segment and constraint are those of the ":param" segment a param
+	// child stands for.
+	segment    string
+	constraint *regexp.Regexp
+}
+
+// insert adds rt to the tree under its pattern. It panics on a catch-all
+// that isn't the pattern's last segment or lacks a name, and on a second
+// catch-all under the same prefix, as both are mistakes in the route setup
+// the server can't serve around.
+func (n *routeNode) insert(rt *route) {
+	segments := strings.Split(rt.pattern, "/")
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, "*"):
+			if i != len(segments)-1 || segment == "*" {
+				panic(fmt.Sprintf("route %q: a catch-all must be a named, final segment like \"*filepath\"", rt.pattern))
+			}
+			if n.catchAll != nil {
+				panic(fmt.Sprintf("route %q: conflicts with catch-all route %q", rt.pattern, n.catchAll.pattern))
+			}
+			rt.params = append(rt.params, segment[1:])
+			n.catchAll = rt
+			return
+		case strings.HasPrefix(segment, ":"):
+			name, constraint := parseParamSegment(rt.pattern, segment)
+			rt.params = append(rt.params, name)
+			n = n.paramChild(segment[len(name)+1:], constraint)
+		default:
+			if n.static == nil {
+				n.static = make(map[string]*routeNode)
+			}
+			child, ok := n.static[segment]
+			if !ok {
+				child = &routeNode{}
+				n.static[segment] = child
+			}
+			n = child
+		}
+	}
+	n.route = rt
+}
+
+// paramChild returns the param child for a constraint, written as in the
+// route pattern, creating it on first use.
+func (n *routeNode) paramChild(segment string, constraint *regexp.Regexp) *routeNode {
+	for _, child := range n.params {
+		if child.segment == segment {
+			return child
+		}
+	}
+	child := &routeNode{segment: segment, constraint: constraint}
+	if constraint == nil {
+		n.params = append(n.params, child)
+	} else {
+		// Ahead of the unconstrained child, which is always last.
+		i := len(n.params)
+		if i > 0 && n.params[i-1].constraint == nil {
+			i--
+		}
+		n.params = slices.Insert(n.params, i, child)
+	}
+	return child
+}
+
+// lookup finds the route for the segments of a raw request path, appending
+// the percent-decoded value of each of its params to values.
+func (n *routeNode) lookup(segments []string, values []string) (*route, []string) {
+	if len(segments) == 0 {
+		return n.route, values
+	}
+
+	segment := segments[0]
+	if child, ok := n.static[unescapePath(segment)]; ok {
+		if rt, found := child.lookup(segments[1:], values); rt != nil {
+			return rt, found
+		}
+	}
+	if len(n.params) > 0 {
+		value := unescapePath(segment)
+		for _, child := range n.params {
+			if child.constraint != nil && !child.constraint.MatchString(value) {
+				continue
+			}
+			if rt, found := child.lookup(segments[1:], append(values, value)); rt != nil {
+				return rt, found
+			}
+		}
+	}
+	if n.catchAll != nil {
+		return n.catchAll, append(values, unescapePath(strings.Join(segments, "/")))
+	}
+	return nil, values
+}
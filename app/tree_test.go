@@ -0,0 +1,86 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestRouteTree(t *testing.T) {
+	r := newRouter()
+	for _, pattern := range []string{
+		"/",
+		"/users",
+		"/users/me",
+		"/users/:id<int>",
+		"/users/:name",
+		"/users/:name/posts/:post",
+		"/users/:id(\\d{4})/legacy",
+		"/static/*filepath",
+		"/static/index.html",
+		"/docs/:page/*rest",
+	} {
+		r.route(pattern)
+	}
+
+	tests := []struct {
+		path    string
+		pattern string // empty when nothing matches
+		params  map[string]string
+	}{
+		{path: "/", pattern: "/"},
+		{path: "/users", pattern: "/users"},
+		{path: "/users/", pattern: "/users/:name", params: map[string]string{"name": ""}},
+		{path: "/users/me", pattern: "/users/me"},
+		{path: "/users/42", pattern: "/users/:id<int>", params: map[string]string{"id": "42"}},
+		{path: "/users/-7", pattern: "/users/:id<int>", params: map[string]string{"id": "-7"}},
+		{path: "/users/alice", pattern: "/users/:name", params: map[string]string{"name": "alice"}},
+		{path: "/users/a%20b", pattern: "/users/:name", params: map[string]string{"name": "a b"}},
+		{path: "/users/%6De", pattern: "/users/me"},
+		{path: "/users/alice/posts/9", pattern: "/users/:name/posts/:post", params: map[string]string{"name": "alice", "post": "9"}},
+		{path: "/users/1234/legacy", pattern: "/users/:id(\\d{4})/legacy", params: map[string]string{"id": "1234"}},
+		{path: "/users/123/legacy", pattern: ""},
+		{path: "/users/alice/posts", pattern: ""},
+		{path: "/static/index.html", pattern: "/static/index.html"},
+		{path: "/static/css/site.css", pattern: "/static/*filepath", params: map[string]string{"filepath": "css/site.css"}},
+		{path: "/static/a%2Fb", pattern: "/static/*filepath", params: map[string]string{"filepath": "a/b"}},
+		{path: "/docs/intro/a/b", pattern: "/docs/:page/*rest", params: map[string]string{"page": "intro", "rest": "a/b"}},
+		{path: "/nowhere", pattern: ""},
+	}
+	for _, tt := range tests {
+		params := make(map[string]string)
+		rt := r.match(tt.path, params)
+		switch {
+		case rt == nil && tt.pattern != "":
+			t.Errorf("match(%q) = nil, want %q", tt.path, tt.pattern)
+		case rt != nil && rt.pattern != tt.pattern:
+			t.Errorf("match(%q) = %q, want %q", tt.path, rt.pattern, tt.pattern)
+		case rt != nil && !maps.Equal(params, tt.params):
+			t.Errorf("match(%q) params = %q, want %q", tt.path, params, tt.params)
+		}
+	}
+}
+
+func TestRouteTreeInsertPanics(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+	}{
+		{name: "catch-all before the end", patterns: []string{"/static/*filepath/more"}},
+		{name: "unnamed catch-all", patterns: []string{"/static/*"}},
+		{name: "second catch-all", patterns: []string{"/static/*filepath", "/static/*rest"}},
+		{name: "malformed constraint", patterns: []string{"/users/:id(\\d+"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("inserting %q didn't panic", tt.patterns)
+				}
+			}()
+			r := newRouter()
+			for _, pattern := range tt.patterns {
+				r.route(pattern)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultMaxFails is how many requests in a row may fail before an
+	// upstream is taken out of rotation.
+	defaultMaxFails = 3
+	// defaultEjectFor is how long an ejected upstream stays out.
+	defaultEjectFor = 30 * time.Second
+)
+
+// errNoUpstream is returned when every upstream in a pool is ejected.
+var errNoUpstream = errors.New("no upstream available")
+
+// Upstream is one backend of an UpstreamPool.
+type Upstream struct {
+	URL *url.URL
+	// Weight is the upstream's share of traffic under WeightedRoundRobin.
+	Weight int
+
+	active   atomic.Int64
+	failures atomic.Int64
+	// ejectedUntil is the UnixNano time the upstream is out until.
+	ejectedUntil atomic.Int64
+	// unhealthy is set while active health checks consider the upstream
+	// down; the check counters belong to the checker goroutine.
+	unhealthy    atomic.Bool
+	passedChecks int
+	failedChecks int
+}
+
+// Active returns how many requests the upstream is serving right now.
+func (u *Upstream) Active() int64 {
+	return u.active.Load()
+}
+
+// Healthy reports whether the upstream is passing its health checks, or
+// true if it isn't being checked.
+func (u *Upstream) Healthy() bool {
+	return !u.unhealthy.Load()
+}
+
+// available reports whether the upstream may be sent traffic at now.
+func (u *Upstream) available(now time.Time) bool {
+	return u.Healthy() && now.UnixNano() >= u.ejectedUntil.Load()
+}
+
+// BalanceStrategy picks the upstream for the next request out of the
+// available ones, of which there is always at least one.
+type BalanceStrategy func(candidates []*Upstream) *Upstream
+
+// RoundRobin sends requests to each upstream in turn.
+func RoundRobin() BalanceStrategy {
+	var next atomic.Uint64
+	return func(candidates []*Upstream) *Upstream {
+		return candidates[(next.Add(1)-1)%uint64(len(candidates))]
+	}
+}
+
+// LeastConnections sends each request to the upstream with the fewest in
+// flight, the first of them on a tie.
+func LeastConnections() BalanceStrategy {
+	return func(candidates []*Upstream) *Upstream {
+		best := candidates[0]
+		for _, u := range candidates[1:] {
+			if u.Active() < best.Active() {
+				best = u
+			}
+		}
+		return best
+	}
+}
+
+// WeightedRoundRobin shares requests out in proportion to the upstreams'
+// weights, interleaving them rather than sending runs to one upstream.
+// Upstreams with a weight below 1 count as 1.
+func WeightedRoundRobin() BalanceStrategy {
+	var mu sync.Mutex
+	current := make(map[*Upstream]int)
+	return func(candidates []*Upstream) *Upstream {
+		mu.Lock()
+		defer mu.Unlock()
+		// Smooth weighted round-robin, as nginx does it.
+		var best *Upstream
+		total := 0
+		for _, u := range candidates {
+			weight := max(u.Weight, 1)
+			total += weight
+			current[u] += weight
+			if best == nil || current[u] > current[best] {
+				best = u
+			}
+		}
+		current[best] -= total
+		return best
+	}
+}
+
+// UpstreamPool is a set of backends that requests are balanced across.
+// An upstream that fails MaxFails requests in a row, by not answering or
+// answering with a 5xx, is ejected for EjectFor before it gets traffic
+// again. Upstreams failing their health checks, if CheckHealth runs, are
+// left out the same way. If none is left, requests fail with 503.
+type UpstreamPool struct {
+	// Strategy picks the upstream for each request. NewUpstreamPool sets
+	// it to RoundRobin.
+	Strategy BalanceStrategy
+	MaxFails int
+	EjectFor time.Duration
+
+	upstreams []*Upstream
+}
+
+// NewUpstreamPool returns a round-robin pool of the upstreams at the given
+// base URLs, each with a weight of 1.
+func NewUpstreamPool(rawURLs ...string) (*UpstreamPool, error) {
+	pool := &UpstreamPool{
+		Strategy: RoundRobin(),
+		MaxFails: defaultMaxFails,
+		EjectFor: defaultEjectFor,
+	}
+	for _, rawURL := range rawURLs {
+		if _, err := pool.Add(rawURL, 1); err != nil {
+			return nil, err
+		}
+	}
+	return pool, nil
+}
+
+// Add adds the upstream at the base URL rawURL to the pool. Pools are not
+// safe to add to while they serve requests.
+func (p *UpstreamPool) Add(rawURL string, weight int) (*Upstream, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" || target.Host == "" {
+		return nil, fmt.Errorf("invalid upstream URL: %q", rawURL)
+	}
+	upstream := &Upstream{URL: target, Weight: weight}
+	p.upstreams = append(p.upstreams, upstream)
+	return upstream, nil
+}
+
+// Upstreams returns the pool's upstreams.
+func (p *UpstreamPool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+// pick chooses the upstream for a request and counts the request against
+// it. The caller reports how it went with done.
+func (p *UpstreamPool) pick() (*Upstream, error) {
+	now := time.Now()
+	candidates := make([]*Upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if u.available(now) {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errNoUpstream
+	}
+	upstream := p.Strategy(candidates)
+	upstream.active.Add(1)
+	return upstream, nil
+}
+
+// done records the outcome of a request picked with pick, ejecting the
+// upstream if it has now failed too many in a row.
+func (p *UpstreamPool) done(upstream *Upstream, failed bool) {
+	upstream.active.Add(-1)
+	if !failed {
+		upstream.failures.Store(0)
+		return
+	}
+	maxFails := int64(p.MaxFails)
+	if maxFails <= 0 {
+		maxFails = defaultMaxFails
+	}
+	if upstream.failures.Add(1) < maxFails {
+		return
+	}
+	ejectFor := p.EjectFor
+	if ejectFor <= 0 {
+		ejectFor = defaultEjectFor
+	}
+	upstream.failures.Store(0)
+	upstream.ejectedUntil.Store(time.Now().Add(ejectFor).UnixNano())
+	defaultLogger("proxy").Warn("Ejected upstream", "upstream", upstream.URL.String(), "for", ejectFor, "failures", maxFails)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RouteName is returned by the route registration methods, so that the
+// route can be named for URL:
+//
+//	s.GET("/files/*filepath", handleFiles).Name("file")
+type RouteName struct {
+	router  *router
+	pattern string
+}
+
+// Name names the route. A name can only be given to one route.
+func (n RouteName) Name(name string) {
+	r := n.router
+	if pattern, ok := r.names[name]; ok && pattern != n.pattern {
+		panic(fmt.Sprintf("route name %q: already names route %q", name, pattern))
+	}
+	if r.names == nil {
+		r.names = make(map[string]string)
+	}
+	r.names[name] = n.pattern
+}
+
+// URL builds the path of the route named name, filling its params from
+// pairs of param names and values, e.g.
+//
+//	s.URL("file", "filepath", "docs/a b.txt")
+//
+// returns "/files/docs/a%20b.txt". Param values are percent-encoded; a
+// catch-all's value keeps its slashes. Pairs naming no param of the route
+// are added as a query string. URL fails for an unknown name, an odd
+// number of pairs, or a param missing or failing its constraint.
+func (r *router) URL(name string, pairs ...string) (string, error) {
+	pattern, ok := r.names[name]
+	if !ok {
+		return "", fmt.Errorf("no route named %q", name)
+	}
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("route %q: params must come in name, value pairs", name)
+	}
+	values := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		values[pairs[i]] = pairs[i+1]
+	}
+
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if segment == "" || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+		param, constraint := segment[1:], (*regexp.Regexp)(nil)
+		if segment[0] == ':' {
+			param, constraint = parseParamSegment(pattern, segment)
+		}
+		value, ok := values[param]
+		if !ok {
+			return "", fmt.Errorf("route %q: missing param %q", name, param)
+		}
+		if constraint != nil && !constraint.MatchString(value) {
+			return "", fmt.Errorf("route %q: param %q doesn't match %s", name, param, segment)
+		}
+		delete(values, param)
+		if segment[0] == '*' {
+			parts := strings.Split(value, "/")
+			for j, part := range parts {
+				parts[j] = url.PathEscape(part)
+			}
+			segments[i] = strings.Join(parts, "/")
+		} else {
+			segments[i] = url.PathEscape(value)
+		}
+	}
+	path := strings.Join(segments, "/")
+
+	if len(values) > 0 {
+		query := make(url.Values, len(values))
+		for key, value := range values {
+			query.Set(key, value)
+		}
+		path += "?" + query.Encode()
+	}
+	return path, nil
+}
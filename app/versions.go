@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Previous versions of overwritten files live under this directory inside
+// the files root, in a subdirectory mirroring each file's relative path.
+// Each version is named by the time it was replaced, in nanoseconds, so
+// names sort chronologically.
+const versionsDirName = ".versions"
+
+type fileVersion struct {
+	Version  string    `json:"version"`
+	Size     int64     `json:"size"`
+	Replaced time.Time `json:"replaced"`
+}
+
+func (s *Server) versionsDir(filename string) string {
+	return filepath.Join(s.FilesDir, versionsDirName, filepath.FromSlash(filename))
+}
+
+// saveVersion moves the current contents of filePath aside before it is
+// overwritten, keeping at most FileVersions versions of filename.
+func (s *Server) saveVersion(filePath, filename string) error {
+	if s.FileVersions <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(filePath); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	dir := s.versionsDir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	version := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := os.Rename(filePath, filepath.Join(dir, version)); err != nil {
+		return err
+	}
+	return pruneVersions(dir, s.FileVersions)
+}
+
+func pruneVersions(dir string, keep int) error {
+	versions, err := listVersions(dir)
+	if err != nil {
+		return err
+	}
+	for len(versions) > keep {
+		if err := os.Remove(filepath.Join(dir, versions[0].Version)); err != nil {
+			return err
+		}
+		versions = versions[1:]
+	}
+	return nil
+}
+
+// listVersions returns the versions stored in dir, oldest first.
+func listVersions(dir string) ([]fileVersion, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []fileVersion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]fileVersion, 0, len(entries))
+	for _, entry := range entries {
+		nanos, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, fileVersion{
+			Version:  entry.Name(),
+			Size:     info.Size(),
+			Replaced: time.Unix(0, nanos).UTC(),
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions, nil
+}
+
+// sendFileVersions answers GET /files/{name}?versions with the stored
+// versions of a file as JSON.
+func (s *Server) sendFileVersions(w *ResponseWriter, filename string) error {
+	versions, err := listVersions(s.versionsDir(filename))
+	if err != nil {
+		return fmt.Errorf("listing versions: %w", err)
+	}
+	return s.WriteJSON(w, StatusOK, versions)
+}
+
+// sendFileVersion answers GET /files/{name}?version={id} with the contents
+// of one stored version.
+func (s *Server) sendFileVersion(w *ResponseWriter, request *HTTPRequest, filename, version string) {
+	if _, err := strconv.ParseInt(version, 10, 64); err != nil {
+		s.sendResponse(w, StatusNotFound, ContentTypePlainText, "")
+		return
+	}
+
+	s.SendFile(w, request, filepath.Join(s.versionsDir(filename), version), DispositionAttachment, path.Base(filename))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// VirtualHost is a site served by the Server under its own route table.
+// Requests are matched to a virtual host by their Host header; the pattern is
+// either an exact host name ("api.example.com") or a wildcard subdomain
+// ("*.example.com"). Requests that match no virtual host use the routes
+// of Server.DefaultHost, if set, or else those registered directly on the
+// Server.
+type VirtualHost struct {
+	router
+
+	pattern string
+
+	// TLS overrides the server's TLS settings for handshakes whose SNI
+	// server name matches this host.
+	TLS *TLSPolicy
+
+	// HSTS, when set, adds a Strict-Transport-Security header to every
+	// response this host sends over TLS.
+	HSTS *HSTSPolicy
+}
+
+// HSTSPolicy describes the Strict-Transport-Security header sent by a host.
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Strict-Transport-Security
+type HSTSPolicy struct {
+	MaxAge            time.Duration
+	IncludeSubDomains bool
+	Preload           bool
+}
+
+func (p *HSTSPolicy) headerValue() string {
+	value := fmt.Sprintf("max-age=%d", int64(p.MaxAge/time.Second))
+	if p.IncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if p.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// Host returns the virtual host for pattern, creating it on first use.
+func (s *Server) Host(pattern string) *VirtualHost {
+	pattern = strings.ToLower(pattern)
+	for _, host := range s.hosts {
+		if host.pattern == pattern {
+			return host
+		}
+	}
+
+	host := &VirtualHost{
+		router:  newRouter(),
+		pattern: pattern,
+	}
+	s.hosts = append(s.hosts, host)
+	return host
+}
+
+// lookupHost finds the virtual host serving name, which may carry a port.
+// Exact patterns win over wildcards, and longer wildcards win over shorter
+// ones.
+func (s *Server) lookupHost(name string) *VirtualHost {
+	name = strings.ToLower(strings.TrimSuffix(stripPort(name), "."))
+	if name == "" {
+		return nil
+	}
+
+	var best *VirtualHost
+	for _, host := range s.hosts {
+		if host.pattern == name {
+			return host
+		}
+		if suffix, ok := strings.CutPrefix(host.pattern, "*"); ok && strings.HasSuffix(name, suffix) {
+			if best == nil || len(host.pattern) > len(best.pattern) {
+				best = host
+			}
+		}
+	}
+	return best
+}
+
+// hostFor returns the virtual host serving name, falling back to
+// DefaultHost.
+func (s *Server) hostFor(name string) *VirtualHost {
+	if host := s.lookupHost(name); host != nil {
+		return host
+	}
+	return s.DefaultHost
+}
+
+// Subdomain returns the part of the request's host name matched by the
+// "*" of a wildcard host, e.g. "alice" for alice.example.com served by
+// "*.example.com", or "" for exact hosts.
+func (h *VirtualHost) Subdomain(request *HTTPRequest) string {
+	suffix, ok := strings.CutPrefix(h.pattern, "*")
+	if !ok {
+		return ""
+	}
+	name := strings.ToLower(strings.TrimSuffix(stripPort(request.Headers.Get("Host")), "."))
+	subdomain, ok := strings.CutSuffix(name, suffix)
+	if !ok {
+		return ""
+	}
+	return subdomain
+}
+
+func stripPort(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return hostport
+}
+
+// applyHostPolicy enforces the host's TLS requirements on a request and adds
+// its security headers to the response. It reports whether the request may
+// be dispatched; when it returns false a response has already been sent.
+func (s *Server) applyHostPolicy(w *ResponseWriter, request *HTTPRequest, host *VirtualHost) bool {
+	if host.TLS != nil && host.TLS.requiresClientCert() {
+		if request.TLS == nil {
+			s.sendResponse(w, StatusForbidden, ContentTypePlainText, "")
+			return false
+		}
+		// The handshake was negotiated for whichever host the client named in
+		// SNI. If that was not this host, the client certificate requirement
+		// was never applied and the client has to reconnect.
+		if s.hostFor(request.TLS.ServerName) != host || len(request.TLS.PeerCertificates) == 0 {
+			s.sendResponse(w, StatusMisdirectedRequest, ContentTypePlainText, "")
+			return false
+		}
+	}
+
+	if host.HSTS != nil && request.TLS != nil {
+		w.Header().Set("Strict-Transport-Security", host.HSTS.headerValue())
+	}
+	return true
+}
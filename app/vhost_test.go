@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLookupHost(t *testing.T) {
+	s := NewServer()
+	for _, pattern := range []string{"example.com", "API.example.com", "*.example.com", "*.eu.example.com"} {
+		s.Host(pattern)
+	}
+	s.DefaultHost = s.Host("fallback")
+
+	tests := []struct {
+		name      string
+		pattern   string
+		subdomain string
+	}{
+		{name: "example.com", pattern: "example.com"},
+		{name: "api.example.com", pattern: "api.example.com"},
+		{name: "Api.Example.com:8443", pattern: "api.example.com"},
+		{name: "api.example.com.", pattern: "api.example.com"},
+		{name: "alice.example.com", pattern: "*.example.com", subdomain: "alice"},
+		{name: "bob.eu.example.com", pattern: "*.eu.example.com", subdomain: "bob"},
+		{name: "a.b.example.com", pattern: "*.example.com", subdomain: "a.b"},
+		{name: "example.org", pattern: "fallback"},
+		{name: "", pattern: "fallback"},
+	}
+	for _, tt := range tests {
+		host := s.hostFor(tt.name)
+		if host == nil || host.pattern != tt.pattern {
+			t.Errorf("hostFor(%q) = %v, want %q", tt.name, host, tt.pattern)
+			continue
+		}
+		request := &HTTPRequest{Headers: Header{}}
+		request.Headers.Set("Host", tt.name)
+		if got := host.Subdomain(request); got != tt.subdomain {
+			t.Errorf("Subdomain for %q = %q, want %q", tt.name, got, tt.subdomain)
+		}
+	}
+}
+
+// testCA issues certificates for the TLS tests from a throwaway CA.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for a server with the given DNS names, or for
+// a client when there are none.
+func (ca *testCA) issue(t *testing.T, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		DNSNames:     dnsNames,
+	}
+	if len(dnsNames) > 0 {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writePEM stores cert and its key as PEM files in dir, returning their
+// paths.
+func writePEM(t *testing.T, dir string, cert tls.Certificate) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestVirtualHostPolicies(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile, keyFile := writePEM(t, dir, ca.issue(t, "admin.example.com", "www.example.com"))
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	clientCert := ca.issue(t)
+
+	s := NewServer(WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	s.DisableHTTP2 = true
+	admin := s.Host("admin.example.com")
+	admin.TLS = &TLSPolicy{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAFile: caFile}
+	www := s.Host("www.example.com")
+	www.HSTS = &HSTSPolicy{MaxAge: 365 * 24 * time.Hour, IncludeSubDomains: true}
+	for _, host := range []*VirtualHost{admin, www} {
+		name := host.pattern
+		host.GET("/", func(w *ResponseWriter, request *HTTPRequest, _ map[string]string) error {
+			s.sendResponse(w, StatusOK, ContentTypePlainText, name)
+			return nil
+		})
+	}
+
+	config, err := s.tlsConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serve(tls.NewListener(tlsListener, config))
+	defer tlsListener.Close()
+	plainListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.serve(plainListener)
+	defer plainListener.Close()
+
+	tests := []struct {
+		name       string
+		scheme     string
+		sni        string
+		host       string
+		clientCert bool
+		status     int // 0 when the handshake must fail
+		hsts       string
+	}{
+		{name: "HSTS over TLS", scheme: "https", sni: "www.example.com", host: "www.example.com", status: 200,
+			hsts: "max-age=31536000; includeSubDomains"},
+		{name: "no HSTS over plain HTTP", scheme: "http", host: "www.example.com", status: 200},
+		{name: "client certificate", scheme: "https", sni: "admin.example.com", host: "admin.example.com", clientCert: true, status: 200},
+		{name: "no client certificate", scheme: "https", sni: "admin.example.com", host: "admin.example.com"},
+		{name: "handshake for another host", scheme: "https", sni: "www.example.com", host: "admin.example.com", status: 421},
+		{name: "client certificate host over plain HTTP", scheme: "http", host: "admin.example.com", status: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := plainListener.Addr().String()
+			if tt.scheme == "https" {
+				addr = tlsListener.Addr().String()
+			}
+			clientConfig := &tls.Config{RootCAs: ca.pool, ServerName: tt.sni}
+			if tt.clientCert {
+				clientConfig.Certificates = []tls.Certificate{clientCert}
+			}
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: clientConfig,
+				DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, addr)
+				},
+			}}
+			request, err := http.NewRequest("GET", tt.scheme+"://"+tt.host+"/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := client.Do(request)
+			if tt.status == 0 {
+				if err == nil {
+					response.Body.Close()
+					t.Fatalf("request went through with status %d, want the handshake refused", response.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(response.Body)
+			response.Body.Close()
+			if response.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", response.StatusCode, tt.status)
+			}
+			if tt.status == 200 && string(body) != tt.host {
+				t.Errorf("served by %q, want %q", body, tt.host)
+			}
+			if got := response.Header.Get("Strict-Transport-Security"); got != tt.hsts {
+				t.Errorf("Strict-Transport-Security = %q, want %q", got, tt.hsts)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fsEvent describes a change to one file below a watched directory.
+type fsEvent struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+}
+
+type fileSnapshot struct {
+	size    int64
+	modTime time.Time
+}
+
+// dirWatcher reports file creations, modifications and deletions below root
+// to its subscribers. The tree is scanned at a fixed interval rather than
+// via OS notifications so it works the same on every platform, and
+// scanning only runs while at least one subscriber is listening. The price
+// is that every scan stats the whole tree, that events arrive up to an
+// interval late, and that a file created and removed between two scans, or
+// rewritten without its size or mtime changing, goes unreported.
+type dirWatcher struct {
+	root     string
+	interval time.Duration
+
+	mu          sync.Mutex
+	subscribers map[chan fsEvent]struct{}
+	running     bool
+}
+
+func newDirWatcher(root string, interval time.Duration) *dirWatcher {
+	return &dirWatcher{
+		root:        root,
+		interval:    interval,
+		subscribers: make(map[chan fsEvent]struct{}),
+	}
+}
+
+// subscribe registers a new listener and returns its event channel along
+// with the function that unregisters it.
+func (d *dirWatcher) subscribe() (<-chan fsEvent, func()) {
+	events := make(chan fsEvent, 64)
+
+	d.mu.Lock()
+	d.subscribers[events] = struct{}{}
+	if !d.running {
+		d.running = true
+		go d.run()
+	}
+	d.mu.Unlock()
+
+	return events, func() {
+		d.mu.Lock()
+		delete(d.subscribers, events)
+		d.mu.Unlock()
+	}
+}
+
+func (d *dirWatcher) run() {
+	previous := d.scan()
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.mu.Lock()
+		if len(d.subscribers) == 0 {
+			d.running = false
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+
+		current := d.scan()
+		for path, snapshot := range current {
+			old, existed := previous[path]
+			if !existed {
+				d.broadcast(fsEvent{Op: "create", Path: path})
+			} else if old != snapshot {
+				d.broadcast(fsEvent{Op: "modify", Path: path})
+			}
+		}
+		for path := range previous {
+			if _, exists := current[path]; !exists {
+				d.broadcast(fsEvent{Op: "delete", Path: path})
+			}
+		}
+		previous = current
+	}
+}
+
+func (d *dirWatcher) scan() map[string]fileSnapshot {
+	snapshots := make(map[string]fileSnapshot)
+	filepath.WalkDir(d.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() && entry.Name() == versionsDirName {
+			return filepath.SkipDir
+		}
+		if !entry.Type().IsRegular() || isUploadTemp(entry.Name()) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(d.root, path)
+		if err != nil {
+			return nil
+		}
+		snapshots[filepath.ToSlash(rel)] = fileSnapshot{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return snapshots
+}
+
+// broadcast delivers event to every subscriber. A subscriber that has fallen
+// behind loses the event rather than stalling the others.
+func (d *dirWatcher) broadcast(event fsEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for events := range d.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// filesWatcher returns the watcher of FilesDir, started on first use so
+// that FilesDir can still be set after NewServer.
+func (s *Server) filesWatcher() *dirWatcher {
+	s.fileWatcherOnce.Do(func() {
+		s.fileWatcher = newDirWatcher(s.FilesDir, time.Second)
+	})
+	return s.fileWatcher
+}
+
+// handleFileEvents streams changes to the files directory as Server-Sent
+// Events, one event per change named after the operation.
+// https://developer.mozilla.org/en-US/docs/Web/API/Server-sent_events/Using_server-sent_events
+func (s *Server) handleFileEvents(w *ResponseWriter, request *HTTPRequest, _ map[string]string) error {
+	events, unsubscribe := s.filesWatcher().subscribe()
+	defer unsubscribe()
+
+	s.ServeSSE(w, request, func(sse *SSEWriter) error {
+		for {
+			select {
+			case event := <-events:
+				data, err := json.Marshal(event)
+				if err != nil {
+					return err
+				}
+				if err := sse.Send(SSEEvent{Event: event.Op, Data: string(data)}); err != nil {
+					return err
+				}
+			case <-request.Context().Done():
+				return nil
+			}
+		}
+	})
+	return nil
+}
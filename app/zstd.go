@@ -0,0 +1,604 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math/bits"
+)
+
+// zstdBlockSize is how much input goes into each block, half the largest
+// a block may decompress to. Copies stay within their block.
+const zstdBlockSize = 1 << 16
+
+// zstdWindowLog sizes the window the frame header announces, 128 KiB,
+// enough for any copy within a block.
+const zstdWindowLog = 17
+
+const zstdMagic = 0xFD2FB528
+
+// Literal and match lengths are sent as codes, each covering a range
+// starting at its base with as many extra bits as the range needs (RFC
+// 8878, section 3.1.1.3.2.1.1).
+var (
+	zstdLiteralBase = [36]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	zstdLiteralBits = [36]uint{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	zstdMatchBase = [53]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051, 4099, 8195, 16387, 32771, 65539}
+	zstdMatchBits = [53]uint{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// The predefined FSE distributions of the three sequence codes, which
+// sequences are always sent with so that no tables need describing.
+var (
+	zstdLiteralTable = newFSETable([]int16{4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1, -1, -1, -1, -1}, 6)
+	zstdMatchTable = newFSETable([]int16{1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1, -1, -1}, 6)
+	zstdOffsetTable = newFSETable([]int16{1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}, 5)
+)
+
+// zstdWriter compresses into a Zstandard frame (RFC 8878). Each block's
+// literals are Huffman coded and its sequences, the copies lzMatcher
+// finds, are FSE coded with the predefined distributions. Blocks that
+// would come out larger are stored raw instead.
+type zstdWriter struct {
+	dst     io.Writer
+	buf     []byte
+	out     []byte
+	matcher lzMatcher
+	seqs    []lzSequence
+	started bool
+	err     error
+}
+
+func newZstdWriter(dst io.Writer) io.WriteCloser {
+	return &zstdWriter{dst: dst}
+}
+
+func (w *zstdWriter) Reset(dst io.Writer) {
+	w.dst = dst
+	w.buf = w.buf[:0]
+	w.started = false
+	w.err = nil
+}
+
+func (w *zstdWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if len(w.buf) == zstdBlockSize {
+			// A full block only goes out once more input shows it isn't
+			// the last.
+			if err := w.flushBlock(false); err != nil {
+				return n - len(p), err
+			}
+		}
+		take := min(len(p), zstdBlockSize-len(w.buf))
+		w.buf = append(w.buf, p[:take]...)
+		p = p[take:]
+	}
+	return n, nil
+}
+
+// Close writes the buffered input as the frame's last block.
+func (w *zstdWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.flushBlock(true); err != nil {
+		return err
+	}
+	w.err = errWriterClosed
+	return nil
+}
+
+func (w *zstdWriter) flushBlock(last bool) error {
+	w.out = w.out[:0]
+	if !w.started {
+		// No content size, checksum or dictionary, and a window of
+		// 1<<zstdWindowLog bytes.
+		w.out = binary.LittleEndian.AppendUint32(w.out, zstdMagic)
+		w.out = append(w.out, 0, (zstdWindowLog-10)<<3)
+		w.started = true
+	}
+
+	// The 3-byte block header is filled in once the block's type and size
+	// are known: compressed, or raw if that came out no smaller.
+	start := len(w.out)
+	w.out = w.compressBlock(append(w.out, 0, 0, 0), w.buf)
+	blockType, size := 2, len(w.out)-start-3
+	if size >= len(w.buf) {
+		blockType, size = 0, len(w.buf)
+		w.out = append(w.out[:start+3], w.buf...)
+	}
+	header := size<<3 | blockType<<1
+	if last {
+		header |= 1
+	}
+	w.out[start], w.out[start+1], w.out[start+2] = byte(header), byte(header>>8), byte(header>>16)
+	w.buf = w.buf[:0]
+	if _, err := w.dst.Write(w.out); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+// compressBlock appends the compressed form of data, its literals section
+// and then its sequences section, to dst.
+func (w *zstdWriter) compressBlock(dst, data []byte) []byte {
+	if len(data) == 0 {
+		return dst
+	}
+	w.seqs = w.matcher.find(data, w.seqs[:0])
+
+	literals := make([]byte, 0, len(data))
+	pos := 0
+	for _, seq := range w.seqs {
+		literals = append(literals, data[pos:pos+seq.literals]...)
+		pos += seq.literals + seq.length
+	}
+	dst = appendZstdLiterals(dst, literals)
+
+	seqs := w.seqs
+	if len(seqs) > 0 && seqs[len(seqs)-1].length == 0 {
+		// Literals after the last copy are implied.
+		seqs = seqs[:len(seqs)-1]
+	}
+	switch n := len(seqs); {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7F00:
+		dst = append(dst, byte(n>>8+128), byte(n))
+	default:
+		dst = append(dst, 255, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if len(seqs) == 0 {
+		return dst
+	}
+	// The predefined distribution for all three codes.
+	dst = append(dst, 0)
+	return appendZstdSequences(dst, seqs)
+}
+
+// zstdSequence is a sequence as the codes and extra bits it is sent as.
+type zstdSequence struct {
+	literalCode, matchCode, offsetCode       uint8
+	literalExtra, matchExtra, offsetExtra    uint32
+	literalBits, matchBits, offsetCodeLength uint
+}
+
+func appendZstdSequences(dst []byte, seqs []lzSequence) []byte {
+	coded := make([]zstdSequence, len(seqs))
+	for i, seq := range seqs {
+		c := &coded[i]
+		code := zstdLengthCode(zstdLiteralBase[:], seq.literals)
+		c.literalCode, c.literalExtra, c.literalBits = uint8(code), uint32(seq.literals-zstdLiteralBase[code]), zstdLiteralBits[code]
+		code = zstdLengthCode(zstdMatchBase[:], seq.length)
+		c.matchCode, c.matchExtra, c.matchBits = uint8(code), uint32(seq.length-zstdMatchBase[code]), zstdMatchBits[code]
+		// Offset values of 1 to 3 name recent offsets, so real ones are
+		// sent 3 higher.
+		value := uint32(seq.offset + 3)
+		code = bits.Len32(value) - 1
+		c.offsetCode, c.offsetExtra, c.offsetCodeLength = uint8(code), value-1<<code, uint(code)
+	}
+
+	// The sequences are encoded last to first, so that the decoder,
+	// reading the stream backwards, meets them in order.
+	var bw bitWriter
+	bw.out = dst
+	last := coded[len(coded)-1]
+	matchState := zstdMatchTable.start(last.matchCode)
+	offsetState := zstdOffsetTable.start(last.offsetCode)
+	literalState := zstdLiteralTable.start(last.literalCode)
+	bw.write(uint64(last.literalExtra), last.literalBits)
+	bw.write(uint64(last.matchExtra), last.matchBits)
+	bw.write(uint64(last.offsetExtra), last.offsetCodeLength)
+	for i := len(coded) - 2; i >= 0; i-- {
+		c := coded[i]
+		offsetState.encode(&bw, c.offsetCode)
+		matchState.encode(&bw, c.matchCode)
+		literalState.encode(&bw, c.literalCode)
+		bw.write(uint64(c.literalExtra), c.literalBits)
+		bw.write(uint64(c.matchExtra), c.matchBits)
+		bw.write(uint64(c.offsetExtra), c.offsetCodeLength)
+	}
+	matchState.flush(&bw)
+	offsetState.flush(&bw)
+	literalState.flush(&bw)
+	closeBackwardStream(&bw)
+	return bw.out
+}
+
+// closeBackwardStream ends a stream read from its end: a 1 bit marks where
+// the data starts, then padding to a byte.
+func closeBackwardStream(bw *bitWriter) {
+	bw.write(1, 1)
+	bw.align()
+}
+
+func zstdLengthCode(base []int, n int) int {
+	code := len(base) - 1
+	for base[code] > n {
+		code--
+	}
+	return code
+}
+
+// appendZstdLiterals appends the literals section for literals to dst:
+// Huffman coded when that is smaller, otherwise raw, or run-length coded
+// for a single repeated byte.
+func appendZstdLiterals(dst, literals []byte) []byte {
+	counts := make([]int, 256)
+	for _, c := range literals {
+		counts[c]++
+	}
+	distinct := 0
+	for _, count := range counts {
+		if count > 0 {
+			distinct++
+		}
+	}
+	if distinct == 1 && len(literals) > 1 {
+		return append(appendZstdRawLiteralsHeader(dst, 1, len(literals)), literals[0])
+	}
+	if distinct > 1 && len(literals) >= 32 {
+		if compressed, ok := zstdHuffmanLiterals(literals, counts); ok && len(compressed) < len(literals) {
+			return append(dst, compressed...)
+		}
+	}
+	return append(appendZstdRawLiteralsHeader(dst, 0, len(literals)), literals...)
+}
+
+// appendZstdRawLiteralsHeader appends the header of a raw (blockType 0) or
+// run-length (1) literals section regenerating size bytes.
+func appendZstdRawLiteralsHeader(dst []byte, blockType, size int) []byte {
+	switch {
+	case size < 32:
+		return append(dst, byte(blockType|size<<3))
+	case size < 4096:
+		header := blockType | 1<<2 | size<<4
+		return append(dst, byte(header), byte(header>>8))
+	default:
+		header := blockType | 3<<2 | size<<4
+		return append(dst, byte(header), byte(header>>8), byte(header>>16))
+	}
+}
+
+// zstdHuffmanLiterals returns the literals section Huffman coding
+// literals, whose byte values occur counts times: the header, the
+// description of the code and the coded literals, in one stream when
+// there are few of them and four otherwise. It reports false when the code
+// can't be described.
+func zstdHuffmanLiterals(literals []byte, counts []int) ([]byte, bool) {
+	lengths := huffmanLengths(counts, 11)
+	maxLength, lastSymbol := uint8(0), 0
+	for symbol, length := range lengths {
+		if length > 0 {
+			maxLength = max(maxLength, length)
+			lastSymbol = symbol
+		}
+	}
+
+	// The code is described by the weights of all symbols but the last,
+	// whose weight follows from the others.
+	weights := make([]uint8, lastSymbol)
+	for symbol := range weights {
+		if lengths[symbol] > 0 {
+			weights[symbol] = maxLength + 1 - lengths[symbol]
+		}
+	}
+	var description []byte
+	if lastSymbol <= 128 {
+		description = append(description, byte(127+lastSymbol))
+		for i := 0; i < len(weights); i += 2 {
+			b := weights[i] << 4
+			if i+1 < len(weights) {
+				b |= weights[i+1]
+			}
+			description = append(description, b)
+		}
+	} else {
+		compressed, ok := fseCompressWeights(weights)
+		if !ok {
+			return nil, false
+		}
+		description = append(append(description, byte(len(compressed))), compressed...)
+	}
+
+	// Codes are canonical with the longest first: they count up from 0
+	// within a length, in symbol order, then halve going to each shorter
+	// length.
+	codes := make([]uint16, 256)
+	code := 0
+	for length := maxLength; length > 0; length-- {
+		for symbol, l := range lengths {
+			if l == length {
+				codes[symbol] = uint16(code)
+				code++
+			}
+		}
+		code >>= 1
+	}
+	stream := func(dst []byte, literals []byte) []byte {
+		var bw bitWriter
+		bw.out = dst
+		for i := len(literals) - 1; i >= 0; i-- {
+			c := literals[i]
+			bw.write(uint64(codes[c]), uint(lengths[c]))
+		}
+		closeBackwardStream(&bw)
+		return bw.out
+	}
+
+	sizeFormat := 0
+	body := description
+	if len(literals) < 1024 {
+		body = stream(body, literals)
+	} else {
+		segment := (len(literals) + 3) / 4
+		jump := len(body)
+		body = append(body, 0, 0, 0, 0, 0, 0)
+		for i := 0; i < 4; i++ {
+			streamStart := len(body)
+			body = stream(body, literals[i*segment:min((i+1)*segment, len(literals))])
+			if i < 3 {
+				binary.LittleEndian.PutUint16(body[jump+2*i:], uint16(len(body)-streamStart))
+			}
+		}
+		sizeFormat = 1
+	}
+
+	regenerated, compressed := len(literals), len(body)
+	var header []byte
+	switch size := max(regenerated, compressed); {
+	case size < 1024:
+		v := 2 | sizeFormat<<2 | regenerated<<4 | compressed<<14
+		header = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	case sizeFormat == 0:
+		// A single stream only has room for sizes below 1024.
+		return nil, false
+	case size < 16384:
+		v := uint32(2 | 2<<2 | regenerated<<4 | compressed<<18)
+		header = binary.LittleEndian.AppendUint32(nil, v)
+	case size < 262144:
+		v := uint64(2 | 3<<2 | regenerated<<4 | compressed<<22)
+		header = binary.LittleEndian.AppendUint64(nil, v)[:5]
+	default:
+		return nil, false
+	}
+	return append(header, body...), true
+}
+
+// fseCompressWeights FSE codes Huffman weights, for codes with symbols
+// beyond the 128 whose weights can be sent directly, as a table
+// description and then a stream alternating between two states. It
+// reports false when the result doesn't fit the 127 bytes allowed.
+func fseCompressWeights(weights []uint8) ([]byte, bool) {
+	const tableLog = 6
+	var counts [16]int
+	maxWeight := 0
+	for _, weight := range weights {
+		counts[weight]++
+		maxWeight = max(maxWeight, int(weight))
+	}
+	distinct := 0
+	for _, count := range counts {
+		if count > 0 {
+			distinct++
+		}
+	}
+	if distinct < 2 {
+		return nil, false
+	}
+	norm := normalizeCounts(counts[:maxWeight+1], len(weights), tableLog)
+	out := appendFSETableDescription(nil, norm, tableLog)
+	table := newFSETable(norm, tableLog)
+
+	var bw bitWriter
+	bw.out = out
+	i := len(weights)
+	var state1, state2 fseState
+	if len(weights)%2 == 1 {
+		state1 = table.start(weights[i-1])
+		state2 = table.start(weights[i-2])
+		state1.encode(&bw, weights[i-3])
+		i -= 3
+	} else {
+		state2 = table.start(weights[i-1])
+		state1 = table.start(weights[i-2])
+		i -= 2
+	}
+	for i > 0 {
+		state2.encode(&bw, weights[i-1])
+		state1.encode(&bw, weights[i-2])
+		i -= 2
+	}
+	state2.flush(&bw)
+	state1.flush(&bw)
+	closeBackwardStream(&bw)
+	if len(bw.out) >= 128 {
+		return nil, false
+	}
+	return bw.out, true
+}
+
+// normalizeCounts scales counts, totalling total, to sum to 1<<tableLog,
+// keeping every symbol that occurs at a count of at least 1.
+func normalizeCounts(counts []int, total int, tableLog uint) []int16 {
+	size := 1 << tableLog
+	norm := make([]int16, len(counts))
+	sum, largest := 0, 0
+	for symbol, count := range counts {
+		if count == 0 {
+			continue
+		}
+		norm[symbol] = int16(max(1, count*size/total))
+		sum += int(norm[symbol])
+		if norm[symbol] > norm[largest] {
+			largest = symbol
+		}
+	}
+	norm[largest] += int16(size - sum)
+	for norm[largest] < 1 {
+		// Too many symbols were rounded up to 1; take the excess from
+		// the others that can spare it.
+		for symbol := range norm {
+			if norm[symbol] > 1 && norm[largest] < 1 {
+				norm[symbol]--
+				norm[largest]++
+			}
+		}
+	}
+	return norm
+}
+
+// appendFSETableDescription appends the description of an FSE table with
+// normalized counts norm, a count of -1 standing for less than one (RFC
+// 8878, section 4.1.1).
+func appendFSETableDescription(dst []byte, norm []int16, tableLog uint) []byte {
+	var bw bitWriter
+	bw.out = dst
+	bw.write(uint64(tableLog-5), 4)
+	remaining := 1<<tableLog + 1
+	threshold := 1 << tableLog
+	nbits := tableLog + 1
+	previousZero := false
+	for symbol := 0; symbol < len(norm) && remaining > 1; {
+		if previousZero {
+			// A zero count is followed by the number of further zeros,
+			// in 2-bit steps of up to 3.
+			start := symbol
+			for symbol < len(norm) && norm[symbol] == 0 {
+				symbol++
+			}
+			for ; symbol >= start+3; start += 3 {
+				bw.write(3, 2)
+			}
+			bw.write(uint64(symbol-start), 2)
+		}
+		count := int(norm[symbol])
+		symbol++
+		limit := 2*threshold - 1 - remaining
+		remaining -= max(count, -count)
+		count++
+		if count >= threshold {
+			count += limit
+		}
+		if count < limit {
+			bw.write(uint64(count), nbits-1)
+		} else {
+			bw.write(uint64(count), nbits)
+		}
+		previousZero = count == 1
+		for remaining < threshold {
+			nbits--
+			threshold >>= 1
+		}
+	}
+	bw.align()
+	return bw.out
+}
+
+// fseTable is an FSE coding table built from normalized counts.
+type fseTable struct {
+	tableLog   uint
+	stateTable []uint16
+	symbols    []fseSymbol
+}
+
+// fseSymbol holds what encoding a symbol from any state takes: how to get
+// the number of bits to write out, and where its next states start.
+type fseSymbol struct {
+	deltaBits  uint32
+	deltaState int
+}
+
+// newFSETable builds the coding table for the normalized counts norm,
+// spreading symbols over the states exactly as decoders do.
+func newFSETable(norm []int16, tableLog uint) *fseTable {
+	size := 1 << tableLog
+	mask := size - 1
+	step := size>>1 + size>>3 + 3
+	high := size - 1
+	cumulative := make([]int, len(norm)+1)
+	tableSymbol := make([]uint8, size)
+	for symbol, count := range norm {
+		if count == -1 {
+			cumulative[symbol+1] = cumulative[symbol] + 1
+			tableSymbol[high] = uint8(symbol)
+			high--
+		} else {
+			cumulative[symbol+1] = cumulative[symbol] + int(count)
+		}
+	}
+	position := 0
+	for symbol, count := range norm {
+		for i := 0; i < int(count); i++ {
+			tableSymbol[position] = uint8(symbol)
+			position = (position + step) & mask
+			for position > high {
+				position = (position + step) & mask
+			}
+		}
+	}
+
+	t := &fseTable{tableLog: tableLog, stateTable: make([]uint16, size), symbols: make([]fseSymbol, len(norm))}
+	for u, symbol := range tableSymbol {
+		t.stateTable[cumulative[symbol]] = uint16(size + u)
+		cumulative[symbol]++
+	}
+	total := 0
+	for symbol, count := range norm {
+		s := &t.symbols[symbol]
+		switch count {
+		case 0:
+			s.deltaBits = uint32((tableLog+1)<<16 - uint(size))
+		case -1, 1:
+			s.deltaBits = uint32(tableLog<<16 - uint(size))
+			s.deltaState = total - 1
+			total++
+		default:
+			maxBits := tableLog - uint(bits.Len32(uint32(count-1))-1)
+			s.deltaBits = uint32(maxBits<<16) - uint32(int(count)<<maxBits)
+			s.deltaState = total - int(count)
+			total += int(count)
+		}
+	}
+	return t
+}
+
+// fseState is the state of an FSE encoder over a table.
+type fseState struct {
+	value uint32
+	table *fseTable
+}
+
+// start returns the state that a stream ending in symbol starts from.
+func (t *fseTable) start(symbol uint8) fseState {
+	s := t.symbols[symbol]
+	nbits := (s.deltaBits + 1<<15) >> 16
+	value := nbits<<16 - s.deltaBits
+	return fseState{value: uint32(t.stateTable[int(value>>nbits)+s.deltaState]), table: t}
+}
+
+func (st *fseState) encode(bw *bitWriter, symbol uint8) {
+	s := st.table.symbols[symbol]
+	nbits := (st.value + s.deltaBits) >> 16
+	bw.write(uint64(st.value), uint(nbits))
+	st.value = uint32(st.table.stateTable[int(st.value>>nbits)+s.deltaState])
+}
+
+// flush writes the final state, which the decoder starts from.
+func (st *fseState) flush(bw *bitWriter) {
+	bw.write(uint64(st.value), st.table.tableLog)
+}
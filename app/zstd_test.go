@@ -0,0 +1,20 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func TestZstdWriterRoundTrip(t *testing.T) {
+	testEncoderRoundTrip(t, newZstdWriter, "zstd")
+}
+
+func TestZstdWriterClosed(t *testing.T) {
+	zw := newZstdWriter(io.Discard)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write([]byte("late")); err != errWriterClosed {
+		t.Errorf("Write after Close = %v, want %v", err, errWriterClosed)
+	}
+}